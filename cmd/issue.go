@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var issueCmd = &cobra.Command{
+	Use:   "issue <repo> <number>",
+	Short: "work on a single issue end-to-end, then exit",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		number, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("invalid issue number", err)
+			return
+		}
+		cfg := oneShotConfig(cmd, args[0])
+
+		p, err := getPullPal(cmd.Context(), cfg)
+		if err != nil {
+			fmt.Println("error creating new pull pal", err)
+			return
+		}
+		fmt.Println("Successfully initialized pull pal")
+
+		err = p.HandleIssue(cfg.repos[0], number)
+		if err != nil {
+			fmt.Println("error handling issue", err)
+			return
+		}
+	},
+}
+
+var commentCmd = &cobra.Command{
+	Use:   "comment <repo> <id>",
+	Short: "respond to a single review comment end-to-end, then exit",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("invalid comment ID", err)
+			return
+		}
+		cfg := oneShotConfig(cmd, args[0])
+
+		p, err := getPullPal(cmd.Context(), cfg)
+		if err != nil {
+			fmt.Println("error creating new pull pal", err)
+			return
+		}
+		fmt.Println("Successfully initialized pull pal")
+
+		err = p.HandleComment(cfg.repos[0], id)
+		if err != nil {
+			fmt.Println("error handling comment", err)
+			return
+		}
+	},
+}
+
+// oneShotConfig returns the config for working on a single issue or comment in repo, which may be given with or
+// without the host (e.g. "github.com/owner/name" or "owner/name"). Only repo is checked out.
+func oneShotConfig(cmd *cobra.Command, repo string) config {
+	cfg := getConfig()
+	if strings.Count(repo, "/") == 1 {
+		repo = "github.com/" + repo
+	}
+	cfg.repos = []string{repo}
+	cfg.model, _ = cmd.Flags().GetString("model")
+	return cfg
+}
+
+func init() {
+	for _, c := range []*cobra.Command{issueCmd, commentCmd} {
+		c.Flags().String("model", "", "the model to use instead of the configured models and models set with commands")
+		rootCmd.AddCommand(c)
+	}
+}
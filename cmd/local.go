@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/pullpal"
+	"github.com/mobyvb/pull-pal/vc"
+	"go.uber.org/zap"
+
+	"github.com/spf13/cobra"
+)
+
+var localCmd = &cobra.Command{
+	Use:   "local <task.md>",
+	Short: "make the changes described in a local markdown file in a local repository, without using Github",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := getConfig()
+		repoPath, _ := cmd.Flags().GetString("repo-path")
+		branch, _ := cmd.Flags().GetString("branch")
+		patchDir, _ := cmd.Flags().GetString("patch-dir")
+
+		log, err := zap.NewProduction()
+		if err != nil {
+			panic(err)
+		}
+		models := []llm.Model{}
+		for _, m := range cfg.models {
+			models = append(models, llm.Model{Name: m})
+		}
+		localCfg := pullpal.LocalConfig{
+			RepoPath: repoPath,
+			Self: vc.Author{
+				Email:  cfg.selfEmail,
+				Handle: cfg.selfHandle,
+			},
+			Models: models,
+			LLMRetry: llm.RetryConfig{
+				Timeout:    cfg.llmTimeout,
+				MaxRetries: cfg.llmMaxRetries,
+				BaseDelay:  2 * time.Second,
+			},
+			OpenAIToken: cfg.openAIToken,
+			DebugDir:    cfg.debugDir,
+			PromptsDir:  cfg.promptsDir,
+			Branch:      branch,
+			PatchDir:    patchDir,
+			StateDir:    cfg.stateDir,
+			Prices:      cfg.prices,
+		}
+
+		result, err := pullpal.RunLocalTask(cmd.Context(), log.Named("pullpal"), localCfg, args[0])
+		if err != nil {
+			fmt.Println("error running local task", err)
+			return
+		}
+		if result.PatchPath != "" {
+			fmt.Printf("Saved the changes generated by %s to %s\n", result.Model, result.PatchPath)
+		} else {
+			fmt.Printf("Committed the changes generated by %s to %s\n", result.Model, result.Branch)
+		}
+		if result.Summary != "" {
+			fmt.Println(result.Summary)
+		}
+		if result.Notes != "" {
+			fmt.Println(result.Notes)
+		}
+	},
+}
+
+func init() {
+	localCmd.Flags().String("repo-path", ".", "the path of the local repository to change")
+	localCmd.Flags().String("branch", "", "the branch to commit the changes to; a name is generated from the task if empty")
+	localCmd.Flags().String("patch-dir", "", "a directory to save the changes to as a .patch file, instead of leaving them on a branch")
+	rootCmd.AddCommand(localCmd)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/pullpal"
+
+	"github.com/spf13/cobra"
+)
+
+var promptCmd = &cobra.Command{
+	Use:   "prompt",
+	Short: "render prompts and parse responses without calling a model",
+}
+
+var promptRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "print the code change prompt that would be sent for an issue body, with token counts per section",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := getConfig()
+		issueFile, _ := cmd.Flags().GetString("issue-file")
+		repoPath, _ := cmd.Flags().GetString("repo-path")
+		model, _ := cmd.Flags().GetString("model")
+		if issueFile == "" {
+			fmt.Println("--issue-file is required")
+			return
+		}
+		if model == "" && len(cfg.models) > 0 {
+			model = cfg.models[0]
+		}
+
+		prompts, err := llm.LoadPrompts(cfg.promptsDir, filepath.Join(repoPath, ".pullpal", "prompts"))
+		if err != nil {
+			fmt.Println("error loading prompt templates", err)
+			return
+		}
+		req, err := pullpal.LocalRequest(issueFile, repoPath)
+		if err != nil {
+			fmt.Println("error reading issue", err)
+			return
+		}
+		req.Prompts = prompts
+
+		preview, err := llm.PreviewCodeChangeRequest(llm.Model{Name: model}, req)
+		if preview.Prompt == "" {
+			fmt.Println("error rendering prompt", err)
+			return
+		}
+		fmt.Println(preview.Prompt)
+		if preview.Schema != "" {
+			fmt.Printf("\n--- response schema (%s) ---\n%s\n", preview.Format, preview.Schema)
+		}
+
+		fmt.Printf("\n--- tokens for %s ---\n", preview.Model)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SECTION\tTOKENS")
+		for _, s := range preview.Sections {
+			fmt.Fprintf(w, "%s\t%d\n", s.Name, s.Tokens)
+		}
+		fmt.Fprintf(w, "TOTAL\t%d\n", preview.Tokens)
+		w.Flush()
+		if len(preview.OmittedFiles) > 0 {
+			fmt.Println("\nomitted to fit the model's limits:", strings.Join(preview.OmittedFiles, ", "))
+		}
+		if err != nil {
+			fmt.Println("\n" + err.Error())
+		}
+	},
+}
+
+var promptParseCmd = &cobra.Command{
+	Use:   "parse <response>",
+	Short: "show how a saved response to a code change prompt is interpreted",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Println("error reading response", err)
+			return
+		}
+		res, structured, err := llm.ParseSavedCodeChangeResponse(string(data))
+		format := "yaml"
+		if structured {
+			format = "structured output"
+		}
+		if err != nil {
+			fmt.Printf("error parsing response as %s: %s\n", format, err)
+			return
+		}
+
+		fmt.Printf("parsed as %s\n\n", format)
+		fmt.Printf("Summary:\n%s\n\n", strings.TrimSpace(res.Summary))
+		fmt.Println("Changes:")
+		for _, c := range res.Changes {
+			fmt.Printf("  %s: %s\n", c.Path, c.Rationale)
+		}
+		fmt.Println()
+		fmt.Print(res.String())
+	},
+}
+
+func init() {
+	promptRenderCmd.Flags().String("issue-file", "", "a markdown file containing an issue body, optionally starting with a \"# \" subject heading")
+	promptRenderCmd.Flags().String("repo-path", ".", "the repository to read the files mentioned in the issue from")
+	promptRenderCmd.Flags().String("model", "", "the model to count tokens and fit the prompt for; the first configured model is used if empty")
+
+	promptCmd.AddCommand(promptRenderCmd)
+	promptCmd.AddCommand(promptParseCmd)
+	rootCmd.AddCommand(promptCmd)
+}
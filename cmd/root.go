@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/mobyvb/pull-pal/llm"
 	"github.com/mobyvb/pull-pal/pullpal"
 	"github.com/mobyvb/pull-pal/vc"
 	"github.com/sashabaranov/go-openai"
@@ -34,9 +36,83 @@ type config struct {
 	requiredIssueLabels []string
 	waitDuration        time.Duration
 	debugDir            string
+
+	// webhook settings
+	webhookAddr   string
+	webhookSecret string
+
+	// llm settings
+	models        []string
+	llmTimeout    time.Duration
+	llmMaxRetries int
+
+	// prompt settings
+	promptsDir string
+
+	// branch settings
+	branchTemplate string
+	existingBranch string
+
+	// pull request settings
+	pullRequests  vc.PullRequestSettings
+	verifyCommand string
+	statusType    string
+
+	// issue lifecycle settings
+	lifecycleLabels pullpal.LifecycleLabels
+	approval        pullpal.ApprovalSettings
+
+	// usage settings
+	stateDir string
+	prices   []pullpal.Price
+	budget   pullpal.Budget
+
+	// per-repo settings
+	repoSettings []pullpal.RepoSettings
+
+	// dry run settings
+	dryRun    bool
+	outputDir string
+
+	// one-shot settings
+	model string
 }
 
 func getConfig() config {
+	var repoSettings []pullpal.RepoSettings
+	err := viper.UnmarshalKey("repo-settings", &repoSettings)
+	if err != nil {
+		fmt.Println("error parsing repo settings", err)
+	}
+	var prices []pullpal.Price
+	err = viper.UnmarshalKey("prices", &prices)
+	if err != nil {
+		fmt.Println("error parsing prices", err)
+	}
+	var budget pullpal.Budget
+	err = viper.UnmarshalKey("budget", &budget)
+	if err != nil {
+		fmt.Println("error parsing budget", err)
+	}
+
+	var pullRequests vc.PullRequestSettings
+	err = viper.UnmarshalKey("pull-requests", &pullRequests)
+	if err != nil {
+		fmt.Println("error parsing pull request settings", err)
+	}
+
+	var lifecycleLabels pullpal.LifecycleLabels
+	err = viper.UnmarshalKey("lifecycle-labels", &lifecycleLabels)
+	if err != nil {
+		fmt.Println("error parsing lifecycle labels", err)
+	}
+
+	var approval pullpal.ApprovalSettings
+	err = viper.UnmarshalKey("approval", &approval)
+	if err != nil {
+		fmt.Println("error parsing approval settings", err)
+	}
+
 	return config{
 		selfHandle:  viper.GetString("handle"),
 		selfEmail:   viper.GetString("email"),
@@ -49,8 +125,36 @@ func getConfig() config {
 
 		usersToListenTo:     viper.GetStringSlice("users-to-listen-to"),
 		requiredIssueLabels: viper.GetStringSlice("required-issue-labels"),
-		waitDuration:        viper.GetDuration("wait-duration"),
+		waitDuration:        viper.GetDuration("wait-time"),
 		debugDir:            viper.GetString("debug-dir"),
+
+		webhookAddr:   viper.GetString("webhook-addr"),
+		webhookSecret: viper.GetString("webhook-secret"),
+
+		models:        viper.GetStringSlice("models"),
+		llmTimeout:    viper.GetDuration("llm-timeout"),
+		llmMaxRetries: viper.GetInt("llm-max-retries"),
+
+		promptsDir: viper.GetString("prompts-dir"),
+
+		branchTemplate: viper.GetString("branch-template"),
+		existingBranch: viper.GetString("existing-branch"),
+
+		pullRequests:  pullRequests,
+		verifyCommand: viper.GetString("verify-command"),
+		statusType:    viper.GetString("status-type"),
+
+		lifecycleLabels: lifecycleLabels,
+		approval:        approval,
+
+		stateDir: viper.GetString("state-dir"),
+		prices:   prices,
+		budget:   budget,
+
+		repoSettings: repoSettings,
+
+		dryRun:    viper.GetBool("dry-run"),
+		outputDir: viper.GetString("output-dir"),
 	}
 }
 
@@ -71,17 +175,48 @@ func getPullPal(ctx context.Context, cfg config) (*pullpal.PullPal, error) {
 		Handles: cfg.usersToListenTo,
 		Labels:  cfg.requiredIssueLabels,
 	}
-	// TODO make model configurable
+	models := []llm.Model{}
+	for _, m := range cfg.models {
+		models = append(models, llm.Model{Name: m})
+	}
 	ppCfg := pullpal.Config{
 		WaitDuration:     cfg.waitDuration,
 		LocalRepoPath:    cfg.localRepoPath,
 		Repos:            cfg.repos,
 		Self:             author,
 		ListIssueOptions: listIssueOptions,
-		// TODO configurable model
-		Model:       openai.GPT4,
-		OpenAIToken: cfg.openAIToken,
-		DebugDir:    cfg.debugDir,
+		Models:           models,
+		LLMRetry: llm.RetryConfig{
+			Timeout:    cfg.llmTimeout,
+			MaxRetries: cfg.llmMaxRetries,
+			BaseDelay:  2 * time.Second,
+		},
+		OpenAIToken:  cfg.openAIToken,
+		DebugDir:     cfg.debugDir,
+		PromptsDir:   cfg.promptsDir,
+		RepoSettings: cfg.repoSettings,
+
+		WebhookAddr:   cfg.webhookAddr,
+		WebhookSecret: cfg.webhookSecret,
+
+		StateDir: cfg.stateDir,
+		Prices:   cfg.prices,
+		Budget:   cfg.budget,
+
+		BranchTemplate: cfg.branchTemplate,
+		ExistingBranch: pullpal.ExistingBranchMode(cfg.existingBranch),
+		PullRequests:   cfg.pullRequests,
+		VerifyCommand:  cfg.verifyCommand,
+		StatusType:     pullpal.StatusType(cfg.statusType),
+
+		LifecycleLabels: cfg.lifecycleLabels,
+		Approval:        cfg.approval,
+
+		DryRun:    cfg.dryRun,
+		DryRunDir: cfg.outputDir,
+	}
+	if cfg.model != "" {
+		ppCfg.ModelOverride = []string{cfg.model}
 	}
 	p, err := pullpal.NewPullPal(ctx, log.Named("pullpal"), ppCfg)
 
@@ -140,6 +275,30 @@ func init() {
 	rootCmd.PersistentFlags().Duration("wait-time", 30*time.Second, "the amount of time Pull Pal should wait when no issues or comments are found to address")
 	rootCmd.PersistentFlags().StringP("debug-dir", "d", "", "the path to use for the pull pal debug directory")
 
+	rootCmd.PersistentFlags().String("webhook-addr", "", "address to listen for Github webhooks on (e.g. \":8080\"); polling is used as a fallback")
+	rootCmd.PersistentFlags().String("webhook-secret", "", "secret used to verify Github webhook signatures")
+
+	rootCmd.PersistentFlags().String("prompts-dir", "", "a directory containing prompt templates that override the built-in templates")
+
+	rootCmd.PersistentFlags().String("branch-template", pullpal.DefaultBranchTemplate, "template for the names of branches created for issues; .Number, .Slug and .Attempt are available")
+	rootCmd.PersistentFlags().String("existing-branch", string(pullpal.ExistingBranchUpdate), "what to do when an issue already has a branch: \"update\" it and its pull request, or create a \"new\" branch numbered with the attempt")
+
+	rootCmd.PersistentFlags().String("verify-command", "", "a shell command run in the repository to verify changes (e.g. \"go build ./... && go test ./...\")")
+	rootCmd.PersistentFlags().String("status-type", string(pullpal.StatusTypeStatuses), "how progress is reported on branches: \"statuses\", \"checks\" (requires a Github App), or \"none\"")
+
+	defaultStateDir := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultStateDir = filepath.Join(home, ".pull-pal")
+	}
+	rootCmd.PersistentFlags().String("state-dir", defaultStateDir, "a directory to persist state in, such as LLM usage")
+
+	rootCmd.PersistentFlags().Bool("dry-run", false, "generate and commit changes locally, but record pushes, pull requests, comments and labels instead of making them")
+	rootCmd.PersistentFlags().String("output-dir", "", "a directory to record what a dry run would have done in; stdout is used if empty")
+
+	rootCmd.PersistentFlags().StringSlice("models", []string{openai.GPT4}, "the models to use, in order of preference; if a model fails, the next one is tried")
+	rootCmd.PersistentFlags().Duration("llm-timeout", 5*time.Minute, "the maximum duration of a single LLM request")
+	rootCmd.PersistentFlags().Int("llm-max-retries", 3, "the number of times a failed LLM request is retried before falling back to the next model")
+
 	viper.BindPFlag("handle", rootCmd.PersistentFlags().Lookup("handle"))
 	viper.BindPFlag("email", rootCmd.PersistentFlags().Lookup("email"))
 	viper.BindPFlag("github-token", rootCmd.PersistentFlags().Lookup("github-token"))
@@ -153,6 +312,26 @@ func init() {
 	viper.BindPFlag("required-issue-labels", rootCmd.PersistentFlags().Lookup("required-issue-labels"))
 	viper.BindPFlag("wait-time", rootCmd.PersistentFlags().Lookup("wait-time"))
 	viper.BindPFlag("debug-dir", rootCmd.PersistentFlags().Lookup("debug-dir"))
+
+	viper.BindPFlag("webhook-addr", rootCmd.PersistentFlags().Lookup("webhook-addr"))
+	viper.BindPFlag("webhook-secret", rootCmd.PersistentFlags().Lookup("webhook-secret"))
+
+	viper.BindPFlag("prompts-dir", rootCmd.PersistentFlags().Lookup("prompts-dir"))
+
+	viper.BindPFlag("branch-template", rootCmd.PersistentFlags().Lookup("branch-template"))
+	viper.BindPFlag("existing-branch", rootCmd.PersistentFlags().Lookup("existing-branch"))
+
+	viper.BindPFlag("verify-command", rootCmd.PersistentFlags().Lookup("verify-command"))
+	viper.BindPFlag("status-type", rootCmd.PersistentFlags().Lookup("status-type"))
+
+	viper.BindPFlag("state-dir", rootCmd.PersistentFlags().Lookup("state-dir"))
+
+	viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run"))
+	viper.BindPFlag("output-dir", rootCmd.PersistentFlags().Lookup("output-dir"))
+
+	viper.BindPFlag("models", rootCmd.PersistentFlags().Lookup("models"))
+	viper.BindPFlag("llm-timeout", rootCmd.PersistentFlags().Lookup("llm-timeout"))
+	viper.BindPFlag("llm-max-retries", rootCmd.PersistentFlags().Lookup("llm-max-retries"))
 }
 
 func initConfig() {
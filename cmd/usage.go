@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mobyvb/pull-pal/pullpal"
+
+	"github.com/spf13/cobra"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "show LLM token usage and cost, per repository and model",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := getConfig()
+		if cfg.stateDir == "" {
+			fmt.Println("no state directory configured")
+			return
+		}
+
+		records, err := pullpal.ReadUsage(filepath.Join(cfg.stateDir, "usage.jsonl"))
+		if err != nil {
+			fmt.Println("error reading usage", err)
+			return
+		}
+		if len(records) == 0 {
+			fmt.Println("no usage recorded")
+			return
+		}
+
+		type key struct {
+			repo  string
+			model string
+		}
+		type totals struct {
+			today, month, all pullpal.UsageTotals
+		}
+		now := time.Now()
+		byKey := make(map[key]*totals)
+		var overall totals
+		for _, r := range records {
+			k := key{repo: r.Repo, model: r.Model}
+			t, ok := byKey[k]
+			if !ok {
+				t = &totals{}
+				byKey[k] = t
+			}
+			for _, t := range []*totals{t, &overall} {
+				t.all.Add(r)
+				if pullpal.SameMonth(r.Time, now) {
+					t.month.Add(r)
+				}
+				if pullpal.SameDay(r.Time, now) {
+					t.today.Add(r)
+				}
+			}
+		}
+
+		keys := make([]key, 0, len(byKey))
+		for k := range byKey {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].repo != keys[j].repo {
+				return keys[i].repo < keys[j].repo
+			}
+			return keys[i].model < keys[j].model
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPO\tMODEL\tTODAY\tTHIS MONTH\tALL TIME\tTOKENS (PROMPT/COMPLETION)")
+		row := func(repo, model string, t totals) {
+			fmt.Fprintf(w, "%s\t%s\t$%.2f\t$%.2f\t$%.2f\t%d/%d\n", repo, model, t.today.Cost, t.month.Cost, t.all.Cost, t.all.PromptTokens, t.all.CompletionTokens)
+		}
+		for _, k := range keys {
+			row(k.repo, k.model, *byKey[k])
+		}
+		row("total", "", overall)
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+}
@@ -0,0 +1,19 @@
+// Package timeutil contains helpers for waiting that are shared by the Github and LLM clients.
+package timeutil
+
+import (
+	"context"
+	"time"
+)
+
+// SleepContext waits for d, or until ctx is done, in which case the context's error is returned.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,248 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	// use the encodings embedded in the binary, rather than downloading them at runtime
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+// modelLimits contains the token limits of a model.
+type modelLimits struct {
+	// contextWindow is the maximum number of tokens in the prompt and response combined.
+	contextWindow int
+	// maxOutput is the maximum number of tokens in the response.
+	maxOutput int
+}
+
+// knownModelLimits contains the limits of known models, keyed by model name prefix.
+// The longest matching prefix is used.
+var knownModelLimits = map[string]modelLimits{
+	"gpt-3.5-turbo":      {16385, 4096},
+	"gpt-3.5-turbo-0613": {4096, 4096},
+	"gpt-3.5-turbo-16k":  {16385, 4096},
+	"gpt-4":              {8192, 8192},
+	"gpt-4-32k":          {32768, 32768},
+	"gpt-4-turbo":        {128000, 4096},
+	"gpt-4-1106":         {128000, 4096},
+	"gpt-4-0125":         {128000, 4096},
+	"gpt-4o":             {128000, 16384},
+	"gpt-4o-2024-05-13":  {128000, 4096},
+	"gpt-4.1":            {1047576, 32768},
+	"o1":                 {200000, 100000},
+	"o3":                 {200000, 100000},
+	"o4-mini":            {200000, 100000},
+}
+
+// defaultModelLimits are used for models that are unknown and have no configured limits.
+var defaultModelLimits = modelLimits{contextWindow: 8192, maxOutput: 4096}
+
+// limits returns the token limits of the model. Limits configured on the model take precedence over known limits.
+func (m Model) limits() modelLimits {
+	limits := defaultModelLimits
+	longestPrefix := 0
+	for prefix, l := range knownModelLimits {
+		if strings.HasPrefix(m.Name, prefix) && len(prefix) > longestPrefix {
+			limits = l
+			longestPrefix = len(prefix)
+		}
+	}
+
+	if m.ContextWindow > 0 {
+		limits.contextWindow = m.ContextWindow
+	}
+	if m.MaxOutputTokens > 0 {
+		limits.maxOutput = m.MaxOutputTokens
+	}
+	if limits.maxOutput > limits.contextWindow {
+		limits.maxOutput = limits.contextWindow
+	}
+	return limits
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// CountTokens counts the tokens in text, using the tokenizer for the model provided.
+// Models that are unknown to the tokenizer (e.g. locally hosted models) are assumed to use the cl100k_base encoding.
+func CountTokens(model, text string) int {
+	encodingName := tiktoken.MODEL_CL100K_BASE
+	if e, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		encodingName = e
+	} else {
+		longestPrefix := 0
+		for prefix, e := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+			if strings.HasPrefix(model, prefix) && len(prefix) > longestPrefix {
+				encodingName = e
+				longestPrefix = len(prefix)
+			}
+		}
+	}
+
+	encodingsMu.Lock()
+	enc, ok := encodings[encodingName]
+	if !ok {
+		var err error
+		enc, err = tiktoken.GetEncoding(encodingName)
+		if err != nil {
+			encodingsMu.Unlock()
+			// this should never happen, since encodings are embedded - fall back to a rough estimate
+			return len(text)/4 + 1
+		}
+		encodings[encodingName] = enc
+	}
+	encodingsMu.Unlock()
+
+	return len(enc.Encode(text, nil, nil))
+}
+
+// responseOverhead is the number of tokens reserved in the response for notes and formatting.
+const responseOverhead = 1000
+
+// PromptTooLargeError is returned when a prompt cannot fit in a model's context window.
+type PromptTooLargeError struct {
+	Model          string
+	PromptTokens   int
+	ResponseTokens int
+	Limits         string
+}
+
+func (e PromptTooLargeError) Error() string {
+	return fmt.Sprintf("prompt too large for %s: %d prompt tokens and an estimated %d response tokens exceed %s", e.Model, e.PromptTokens, e.ResponseTokens, e.Limits)
+}
+
+// fitCodeChangeRequest removes files from the request until the prompt and the expected response fit in the model's
+// limits. Since the response contains the full contents of every file that is changed, it is expected to be about as
+// large as the files in the prompt. Files that are not mentioned in the issue are removed before files that are, and
+// larger files are removed before smaller ones. The paths of removed files are recorded in req.OmittedFiles.
+func fitCodeChangeRequest(model Model, req CodeChangeRequest, structured bool) (CodeChangeRequest, error) {
+	limits := model.limits()
+
+	fileTokens := make(map[string]int)
+	for _, f := range req.Files {
+		fileTokens[f.Path] = CountTokens(model.Name, f.Contents)
+	}
+
+	candidates := removalOrder(req.Files, req.Subject+"\n"+req.Body, fileTokens)
+
+	for {
+		prompt, err := req.getPrompt(structured)
+		if err != nil {
+			return req, err
+		}
+		promptTokens := CountTokens(model.Name, prompt)
+		responseTokens := responseOverhead
+		for _, f := range req.Files {
+			responseTokens += fileTokens[f.Path]
+		}
+
+		if promptTokens+responseTokens <= limits.contextWindow && responseTokens <= limits.maxOutput {
+			return req, nil
+		}
+
+		if len(candidates) == 0 || len(req.Files) == 0 {
+			return req, PromptTooLargeError{
+				Model:          model.Name,
+				PromptTokens:   promptTokens,
+				ResponseTokens: responseTokens,
+				Limits:         fmt.Sprintf("a context window of %d tokens with at most %d response tokens", limits.contextWindow, limits.maxOutput),
+			}
+		}
+
+		omit := candidates[0]
+		candidates = candidates[1:]
+		if fileTokens[omit.Path] == 0 {
+			// removing an empty file does not help, and the file may be meant to be created
+			continue
+		}
+
+		files := []File{}
+		for _, f := range req.Files {
+			if f.Path != omit.Path {
+				files = append(files, f)
+			}
+		}
+		req.Files = files
+		req.OmittedFiles = append(req.OmittedFiles, omit.Path)
+	}
+}
+
+// removalOrder returns files in the order they should be removed from a prompt to make it fit: files that are not
+// mentioned in text before files that are, and larger files before smaller ones.
+func removalOrder(files []File, text string, fileTokens map[string]int) []File {
+	candidates := make([]File, len(files))
+	copy(candidates, files)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		mentionedI := strings.Contains(text, candidates[i].Path)
+		mentionedJ := strings.Contains(text, candidates[j].Path)
+		if mentionedI != mentionedJ {
+			return !mentionedI
+		}
+		return fileTokens[candidates[i].Path] > fileTokens[candidates[j].Path]
+	})
+	return candidates
+}
+
+// fitDiffCommentRequest removes context files from the request until the conversation and the expected response fit
+// in the model's limits. The commented file is never removed.
+func fitDiffCommentRequest(model Model, req DiffCommentRequest, structured bool) (DiffCommentRequest, []openai.ChatCompletionMessage, error) {
+	fileTokens := make(map[string]int)
+	for _, f := range append([]File{req.File}, req.ContextFiles...) {
+		fileTokens[f.Path] = CountTokens(model.Name, f.Contents)
+	}
+	candidates := removalOrder(req.ContextFiles, req.Contents, fileTokens)
+
+	for {
+		messages, err := req.getMessages(structured)
+		if err != nil {
+			return req, nil, err
+		}
+		// if the comment is a request for changes, the response may contain the full contents of every file
+		responseTokens := fileTokens[req.File.Path]
+		for _, f := range req.ContextFiles {
+			responseTokens += fileTokens[f.Path]
+		}
+
+		err = checkPromptSize(model, transcript(messages), responseTokens)
+		if err == nil || len(candidates) == 0 {
+			return req, messages, err
+		}
+
+		omit := candidates[0]
+		candidates = candidates[1:]
+		files := []File{}
+		for _, f := range req.ContextFiles {
+			if f.Path != omit.Path {
+				files = append(files, f)
+			}
+		}
+		req.ContextFiles = files
+	}
+}
+
+// checkPromptSize returns an error if a prompt and the expected response cannot fit in the model's limits.
+func checkPromptSize(model Model, prompt string, expectedResponseTokens int) error {
+	limits := model.limits()
+	promptTokens := CountTokens(model.Name, prompt)
+	responseTokens := expectedResponseTokens + responseOverhead
+	if promptTokens+responseTokens <= limits.contextWindow && responseTokens <= limits.maxOutput {
+		return nil
+	}
+	return PromptTooLargeError{
+		Model:          model.Name,
+		PromptTokens:   promptTokens,
+		ResponseTokens: responseTokens,
+		Limits:         fmt.Sprintf("a context window of %d tokens with at most %d response tokens", limits.contextWindow, limits.maxOutput),
+	}
+}
@@ -0,0 +1,110 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitCodeChangeRequest(t *testing.T) {
+	small := File{Path: "small.go", Contents: strings.Repeat("x := 1\n", 50)}
+	large := File{Path: "large.go", Contents: strings.Repeat("x := 1\n", 500)}
+	mentioned := File{Path: "mentioned.go", Contents: strings.Repeat("x := 1\n", 600)}
+	empty := File{Path: "new.go"}
+
+	req := CodeChangeRequest{
+		Subject: "update mentioned.go",
+		Body:    "do something",
+		Files:   []File{small, mentioned, large, empty},
+	}
+	promptTokens := func(req CodeChangeRequest) int {
+		prompt, err := req.getPrompt(false)
+		require.NoError(t, err)
+		return CountTokens("gpt-4", prompt)
+	}
+	full := promptTokens(req)
+
+	var testCases = []struct {
+		testcase      string
+		contextWindow int
+		omitted       []string
+		fails         bool
+	}{
+		{"everything fits", 100000, nil, false},
+		{"unmentioned large file omitted first", 2*full - CountTokens("gpt-4", large.Contents), []string{"large.go"}, false},
+		{"mentioned file omitted last", full, []string{"large.go", "small.go", "mentioned.go"}, false},
+		{"nothing fits", 100, nil, true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		model := Model{Name: "gpt-4", ContextWindow: tt.contextWindow, MaxOutputTokens: tt.contextWindow}
+		fitted, err := fitCodeChangeRequest(model, req, false)
+		if tt.fails {
+			require.Error(t, err)
+			require.IsType(t, PromptTooLargeError{}, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.omitted, fitted.OmittedFiles)
+		require.Equal(t, len(req.Files)-len(tt.omitted), len(fitted.Files))
+		for _, path := range tt.omitted {
+			prompt, err := fitted.getPrompt(false)
+			require.NoError(t, err)
+			require.NotContains(t, prompt, "name: "+path)
+		}
+	}
+}
+
+func TestFitDiffCommentRequest(t *testing.T) {
+	commented := File{Path: "commented.go", Contents: strings.Repeat("x := 1\n", 200)}
+	caller := File{Path: "caller.go", Contents: strings.Repeat("x := 1\n", 100)}
+	large := File{Path: "large.go", Contents: strings.Repeat("x := 1\n", 400)}
+
+	req := DiffCommentRequest{
+		File:         commented,
+		Contents:     "rename this function and update caller.go",
+		ContextFiles: []File{caller, large},
+	}
+	// required returns the context window needed to fit the request with only the context files provided
+	required := func(contextFiles ...File) int {
+		r := req
+		r.ContextFiles = contextFiles
+		messages, err := r.getMessages(false)
+		require.NoError(t, err)
+		tokens := CountTokens("gpt-4", transcript(messages)) + CountTokens("gpt-4", commented.Contents) + responseOverhead
+		for _, f := range contextFiles {
+			tokens += CountTokens("gpt-4", f.Contents)
+		}
+		return tokens
+	}
+
+	var testCases = []struct {
+		testcase      string
+		contextWindow int
+		kept          []string
+		fails         bool
+	}{
+		{"everything fits", required(caller, large), []string{"caller.go", "large.go"}, false},
+		{"unmentioned large file omitted first", required(caller), []string{"caller.go"}, false},
+		{"commented file is never omitted", required(), []string{}, false},
+		{"nothing fits", 100, nil, true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		model := Model{Name: "gpt-4", ContextWindow: tt.contextWindow, MaxOutputTokens: tt.contextWindow}
+		fitted, _, err := fitDiffCommentRequest(model, req, false)
+		if tt.fails {
+			require.Error(t, err)
+			require.IsType(t, PromptTooLargeError{}, err)
+			continue
+		}
+		require.NoError(t, err)
+		kept := []string{}
+		for _, f := range fitted.ContextFiles {
+			kept = append(kept, f.Path)
+		}
+		require.Equal(t, tt.kept, kept)
+		require.Equal(t, commented, fitted.File)
+	}
+}
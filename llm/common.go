@@ -2,8 +2,39 @@ package llm
 
 // File represents a file in a git repository.
 type File struct {
-	Path     string `yaml:"path"`
-	Contents string `yaml:"contents"`
+	Path     string `yaml:"path" json:"path"`
+	Contents string `yaml:"contents" json:"contents"`
+}
+
+// Model is a model served by an OpenAI-compatible API.
+type Model struct {
+	Name string `mapstructure:"name"`
+	// BaseURL is the URL of an OpenAI-compatible API serving the model (e.g. a locally hosted model).
+	// The OpenAI API is used if empty.
+	BaseURL string `mapstructure:"base-url"`
+	// Token is used to authenticate requests to BaseURL. The OpenAI token is used if empty.
+	Token string `mapstructure:"token"`
+	// ContextWindow overrides the maximum number of tokens in the prompt and response combined.
+	ContextWindow int `mapstructure:"context-window"`
+	// MaxOutputTokens overrides the maximum number of tokens in the response.
+	MaxOutputTokens int `mapstructure:"max-output-tokens"`
+	// Output is the format the model is asked to respond in.
+	// If empty, function calling is used for the OpenAI API, and YAML is used for other APIs.
+	Output OutputFormat `mapstructure:"output"`
+}
+
+func (m Model) String() string {
+	if m.BaseURL == "" {
+		return m.Name
+	}
+	return m.Name + "@" + m.BaseURL
+}
+
+// Usage is the number of tokens used by a single request to a model.
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
 }
 
 type ResponseType int
@@ -15,29 +46,167 @@ const (
 
 // CodeChangeRequest contains all necessary information for generating a prompt for a LLM.
 type CodeChangeRequest struct {
+	// Prompts contains the templates used to generate the prompt. The default templates are used if nil.
+	Prompts     *Prompts
 	Files       []File
 	Subject     string
 	Body        string
 	IssueNumber int
 	BaseBranch  string
+	// OmittedFiles contains the paths of files that were removed from the prompt to fit the model's context window.
+	OmittedFiles []string
+	// PreviousDiff contains the changes already made for the task, when refining an existing pull request.
+	PreviousDiff string
+	// Feedback contains the comments left since the previous changes were made, oldest first.
+	Feedback []ThreadComment
 }
 
 // CodeChangeResponse contains data derived from an LLM response to a prompt generated via a CodeChangeRequest.
 type CodeChangeResponse struct {
-	Files []File `yaml:"files"`
-	Notes string `yaml:"notes"`
+	Files []File `yaml:"files" json:"files"`
+	Notes string `yaml:"notes" json:"notes"`
+	// Summary is a short description of the changes, for the pull request.
+	Summary string `yaml:"summary" json:"summary"`
+	// Changes explains why each file was changed.
+	Changes []FileChange `yaml:"changes" json:"changes"`
+	// Model is the model that generated the response.
+	Model string `yaml:"-" json:"-"`
+	// OmittedFiles contains the paths of files that were removed from the prompt to fit the model's context window.
+	OmittedFiles []string `yaml:"-" json:"-"`
+	// Usage contains the tokens used to generate the response. It is populated even if the request fails.
+	Usage []Usage `yaml:"-" json:"-"`
+}
+
+func (res *CodeChangeResponse) setResult(model string, usage []Usage) {
+	res.Model = model
+	res.Usage = usage
+}
+
+// FileChange explains the change made to a single file.
+type FileChange struct {
+	Path      string `yaml:"path" json:"path"`
+	Rationale string `yaml:"rationale" json:"rationale"`
 }
 
-// TODO support threads
 type DiffCommentRequest struct {
+	// Prompts contains the templates used to generate the prompt. The default templates are used if nil.
+	Prompts  *Prompts
 	File     File
 	Contents string
 	Diff     string
 	PRNumber int
+	// ContextFiles contains other files that may need to change to address the comment (e.g. callers of a function).
+	ContextFiles []File
+	// Thread contains the earlier comments in the review thread, oldest first. Contents is the latest comment.
+	// If the thread is not empty, the prompt is generated for the first comment, and the rest of the thread is sent
+	// as a conversation.
+	Thread []ThreadComment
+}
+
+// ThreadComment is a comment in a review thread.
+type ThreadComment struct {
+	Author string
+	Body   string
+	// Self is true if the comment was written by pull pal.
+	Self bool
+}
+
+// IssueCommentRequest is a request to respond to a comment in the conversation of an issue or pull request.
+type IssueCommentRequest struct {
+	// Prompts contains the templates used to generate the prompt. The default templates are used if nil.
+	Prompts *Prompts
+	// Number is the number of the issue or pull request.
+	Number int
+	// PullRequest is true if the conversation is on a pull request opened by pull pal, rather than an issue.
+	PullRequest bool
+	// CanChange is true if pull pal has an open pull request for the conversation, which can be changed if the comment
+	// asks for it.
+	CanChange bool
+	Subject   string
+	Body      string
+	// Diff contains the changes made by the pull request, if any.
+	Diff string
+	// Contents is the latest comment.
+	Contents string
+	// Thread contains the earlier comments in the conversation, oldest first.
+	Thread []ThreadComment
+}
+
+type IssueCommentResponse struct {
+	Response string `yaml:"response" json:"response"`
+	// ChangeRequested is true if the comment asks for changes to the pull request, which are made separately.
+	ChangeRequested bool `yaml:"changeRequested" json:"changeRequested"`
+	// Model is the model that generated the response.
+	Model string `yaml:"-" json:"-"`
+	// Usage contains the tokens used to generate the response.
+	Usage []Usage `yaml:"-" json:"-"`
+}
+
+func (res *IssueCommentResponse) setResult(model string, usage []Usage) {
+	res.Model = model
+	res.Usage = usage
+}
+
+// ReviewRequest is a request to address every comment in a pull request review at once.
+type ReviewRequest struct {
+	// Prompts contains the templates used to generate the prompt. The default templates are used if nil.
+	Prompts  *Prompts
+	PRNumber int
+	// Body is the summary left with the review, if any.
+	Body string
+	// Files contains the current contents of every file that was commented on.
+	Files    []File
+	Comments []ReviewComment
+}
+
+// ReviewComment is a comment in a pull request review.
+type ReviewComment struct {
+	// Number identifies the comment in the response.
+	Number   int
+	Author   string
+	FilePath string
+	Diff     string
+	Contents string
+	// Thread contains the earlier comments in the comment's review thread, oldest first.
+	Thread []ThreadComment
+}
+
+type ReviewResponse struct {
+	// Files contains the new contents of every file that was modified.
+	Files     []File                  `yaml:"files" json:"files"`
+	Responses []ReviewCommentResponse `yaml:"responses" json:"responses"`
+	// Model is the model that generated the response.
+	Model string `yaml:"-" json:"-"`
+	// Usage contains the tokens used to generate the response.
+	Usage []Usage `yaml:"-" json:"-"`
+}
+
+func (res *ReviewResponse) setResult(model string, usage []Usage) {
+	res.Model = model
+	res.Usage = usage
+}
+
+// ReviewCommentResponse is the response to a single comment in a review.
+type ReviewCommentResponse struct {
+	// Comment is the number of the comment being responded to.
+	Comment  int    `yaml:"comment" json:"comment"`
+	Response string `yaml:"response" json:"response"`
 }
 
 type DiffCommentResponse struct {
-	Type     ResponseType `yaml:"responseType"`
-	Response string       `yaml:"response"`
-	File     File         `yaml:"file"`
+	Type     ResponseType `yaml:"responseType" json:"responseType"`
+	Response string       `yaml:"response" json:"response"`
+	// Files contains the new contents of every file that was modified, if the response is a code change.
+	Files []File `yaml:"files" json:"files"`
+	// File is a modified file, in responses generated by templates written before responses could contain several files.
+	File File `yaml:"file" json:"-"`
+	// Model is the model that generated the response.
+	Model string `yaml:"-" json:"-"`
+	// Usage contains the tokens used to generate the response.
+	Usage []Usage `yaml:"-" json:"-"`
+}
+
+func (res *DiffCommentResponse) setResult(model string, usage []Usage) {
+	res.Model = model
+	res.Usage = usage
 }
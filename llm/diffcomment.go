@@ -1,14 +1,17 @@
 package llm
 
 import (
-	"bytes"
-	"text/template"
+	"fmt"
 
-	"gopkg.in/yaml.v3"
+	"github.com/sashabaranov/go-openai"
 )
 
 func (req DiffCommentRequest) String() string {
-	return req.MustGetPrompt()
+	prompt, err := req.GetPrompt()
+	if err != nil {
+		return fmt.Sprintf("invalid prompt: %s", err)
+	}
+	return prompt
 }
 
 // MustGetPrompt only returns the prompt, but panics if the data in the request cannot populate the template.
@@ -21,19 +24,62 @@ func (req DiffCommentRequest) MustGetPrompt() string {
 }
 
 // GetPrompt converts the information in the request to a prompt for an LLM.
+// The prompt asks for a response in YAML format.
 func (req DiffCommentRequest) GetPrompt() (string, error) {
-	tmpl, err := template.ParseFiles("./llm/prompts/comment-diff-request.tmpl")
+	return req.getPrompt(false)
+}
+
+// getPrompt converts the information in the request to a prompt for an LLM. If structured is true, the prompt does not
+// describe the response format, since it is provided to the LLM as a schema.
+func (req DiffCommentRequest) getPrompt(structured bool) (string, error) {
+	data := struct {
+		DiffCommentRequest
+		Structured bool
+	}{req, structured}
+
+	return req.Prompts.render(diffCommentTemplate, data)
+}
+
+// followUpInstructions are added to the latest comment in a thread, since the response format is only described in the
+// first message of the conversation.
+const followUpInstructions = "Respond to the latest comment above, exactly as described in the first message."
+
+// getMessages converts the request to a conversation. The prompt is generated for the first comment in the thread, and
+// later comments are sent as messages from the user, or from the assistant if they were written by pull pal.
+func (req DiffCommentRequest) getMessages(structured bool) ([]openai.ChatCompletionMessage, error) {
+	first := req
+	if len(req.Thread) > 0 {
+		first.Contents = req.Thread[0].Body
+	}
+	prompt, err := first.getPrompt(structured)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var result bytes.Buffer
-	err = tmpl.Execute(&result, req)
-	if err != nil {
-		return "", err
+	if len(req.Thread) == 0 {
+		return userMessage(prompt), nil
 	}
+	return conversation(prompt, req.Thread[1:], req.Contents), nil
+}
 
-	return result.String(), nil
+// conversation returns the prompt, followed by the comments in thread and the latest comment. Comments are sent as
+// messages from the user, or from the assistant if they were written by pull pal.
+func conversation(prompt string, thread []ThreadComment, latest string) []openai.ChatCompletionMessage {
+	messages := userMessage(prompt)
+	for _, c := range thread {
+		role := openai.ChatMessageRoleUser
+		if c.Self {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: c.Body,
+		})
+	}
+	return append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: fmt.Sprintf("%s\n\n%s", latest, followUpInstructions),
+	})
 }
 
 // String is a string representation of DiffCommentResponse.
@@ -50,14 +96,26 @@ func (res DiffCommentResponse) String() string {
 	out += "Response:\n"
 	out += res.Response + "\n\n"
 	out += "Files:\n"
-	out += res.File.Path + ":\n```\n"
-	out += res.File.Contents + "\n```\n"
+	for _, f := range res.ChangedFiles() {
+		out += f.Path + ":\n```\n"
+		out += f.Contents + "\n```\n"
+	}
 
 	return out
 }
 
+// ChangedFiles returns every file modified by the response.
+func (res DiffCommentResponse) ChangedFiles() []File {
+	files := res.Files
+	if res.File.Path != "" {
+		files = append([]File{res.File}, files...)
+	}
+	return files
+}
+
+// ParseDiffCommentResponse parses the LLM's response to DiffCommentRequest (string) into a DiffCommentResponse.
 func ParseDiffCommentResponse(llmResponse string) (DiffCommentResponse, error) {
 	var response DiffCommentResponse
-	err := yaml.Unmarshal([]byte(llmResponse), &response)
+	err := parseYAML(llmResponse, &response)
 	return response, err
 }
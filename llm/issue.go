@@ -1,15 +1,14 @@
 package llm
 
-import (
-	"bytes"
-	"text/template"
-
-	"gopkg.in/yaml.v3"
-)
+import "fmt"
 
 // String is the string representation of a CodeChangeRequest. Functionally, it contains the LLM prompt.
 func (req CodeChangeRequest) String() string {
-	return req.MustGetPrompt()
+	prompt, err := req.GetPrompt()
+	if err != nil {
+		return fmt.Sprintf("invalid prompt: %s", err)
+	}
+	return prompt
 }
 
 // MustGetPrompt only returns the prompt, but panics if the data in the request cannot populate the template.
@@ -22,19 +21,20 @@ func (req CodeChangeRequest) MustGetPrompt() string {
 }
 
 // GetPrompt converts the information in the request to a prompt for an LLM.
+// The prompt asks for a response in YAML format.
 func (req CodeChangeRequest) GetPrompt() (string, error) {
-	tmpl, err := template.ParseFiles("./llm/prompts/code-change-request.tmpl")
-	if err != nil {
-		return "", err
-	}
+	return req.getPrompt(false)
+}
 
-	var result bytes.Buffer
-	err = tmpl.Execute(&result, req)
-	if err != nil {
-		return "", err
-	}
+// getPrompt converts the information in the request to a prompt for an LLM. If structured is true, the prompt does not
+// describe the response format, since it is provided to the LLM as a schema.
+func (req CodeChangeRequest) getPrompt(structured bool) (string, error) {
+	data := struct {
+		CodeChangeRequest
+		Structured bool
+	}{req, structured}
 
-	return result.String(), nil
+	return req.Prompts.render(codeChangeTemplate, data)
 }
 
 // String is a string representation of CodeChangeResponse.
@@ -53,6 +53,6 @@ func (res CodeChangeResponse) String() string {
 // ParseCodeChangeResponse parses the LLM's response to CodeChangeRequest (string) into a CodeChangeResponse.
 func ParseCodeChangeResponse(llmResponse string) (CodeChangeResponse, error) {
 	var response CodeChangeResponse
-	err := yaml.Unmarshal([]byte(llmResponse), &response)
+	err := parseYAML(llmResponse, &response)
 	return response, err
 }
@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func (req IssueCommentRequest) String() string {
+	prompt, err := req.GetPrompt()
+	if err != nil {
+		return fmt.Sprintf("invalid prompt: %s", err)
+	}
+	return prompt
+}
+
+// GetPrompt converts the information in the request to a prompt for an LLM.
+// The prompt asks for a response in YAML format. The comments themselves are sent separately, as a conversation.
+func (req IssueCommentRequest) GetPrompt() (string, error) {
+	return req.getPrompt(false)
+}
+
+// getPrompt converts the information in the request to a prompt for an LLM. If structured is true, the prompt does not
+// describe the response format, since it is provided to the LLM as a schema.
+func (req IssueCommentRequest) getPrompt(structured bool) (string, error) {
+	data := struct {
+		IssueCommentRequest
+		Structured bool
+	}{req, structured}
+
+	return req.Prompts.render(issueCommentTemplate, data)
+}
+
+// getMessages converts the request to a conversation, starting with the prompt, followed by every comment.
+func (req IssueCommentRequest) getMessages(structured bool) ([]openai.ChatCompletionMessage, error) {
+	prompt, err := req.getPrompt(structured)
+	if err != nil {
+		return nil, err
+	}
+	return conversation(prompt, req.Thread, req.Contents), nil
+}
+
+// ParseIssueCommentResponse parses the LLM's response to IssueCommentRequest (string) into an IssueCommentResponse.
+func ParseIssueCommentResponse(llmResponse string) (IssueCommentResponse, error) {
+	var response IssueCommentResponse
+	err := parseYAML(llmResponse, &response)
+	return response, err
+}
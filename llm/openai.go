@@ -2,94 +2,487 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/mobyvb/pull-pal/internal/timeutil"
+
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
 
+// RetryConfig defines how LLM requests are retried.
+type RetryConfig struct {
+	// Timeout is the maximum duration of a single request. There is no timeout if zero.
+	Timeout time.Duration
+	// MaxRetries is the number of times a request is retried on the same model after a transient error,
+	// before falling back to the next model.
+	MaxRetries int
+	// BaseDelay is the initial delay for exponential backoff between retries.
+	BaseDelay time.Duration
+}
+
 type OpenAIClient struct {
-	log          *zap.Logger
-	client       *openai.Client
-	debugDir     string
-	defaultModel string
+	log           *zap.Logger
+	token         string
+	debugDir      string
+	defaultModels []Model
+	retry         RetryConfig
+
+	mu sync.Mutex
+	// clients contains a client for each API that has been used, keyed by base URL and token.
+	clients map[string]*openai.Client
 }
 
-func NewOpenAIClient(log *zap.Logger, defaultModel, token, debugDir string) *OpenAIClient {
+// NewOpenAIClient creates a client for OpenAI-compatible APIs. If a request does not specify which models to use,
+// defaultModels are tried in order until one succeeds.
+func NewOpenAIClient(log *zap.Logger, defaultModels []Model, token, debugDir string, retry RetryConfig) *OpenAIClient {
 	return &OpenAIClient{
-		log:          log,
-		client:       openai.NewClient(token),
-		defaultModel: defaultModel,
-		debugDir:     debugDir,
-	}
-}
-
-func (oc *OpenAIClient) EvaluateCCR(ctx context.Context, model string, req CodeChangeRequest) (res CodeChangeResponse, err error) {
-	if model == "" {
-		model = oc.defaultModel
-	}
-	resp, err := oc.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: req.String(),
-				},
-			},
+		log:           log,
+		token:         token,
+		defaultModels: defaultModels,
+		debugDir:      debugDir,
+		retry:         retry,
+		clients:       make(map[string]*openai.Client),
+	}
+}
+
+// EvaluateCCR sends a code change request to the first available model in the chain provided (or the default chain if empty).
+func (oc *OpenAIClient) EvaluateCCR(ctx context.Context, models []Model, req CodeChangeRequest) (CodeChangeResponse, error) {
+	var omittedFiles []string
+	res, err := evaluateResponse(ctx, oc, models, request{
+		prompt: func(model Model, structured bool) ([]openai.ChatCompletionMessage, error) {
+			fitted, err := fitCodeChangeRequest(model, req, structured)
+			if err != nil {
+				return nil, err
+			}
+			if len(fitted.OmittedFiles) > len(req.OmittedFiles) {
+				oc.log.Warn("omitted files from prompt to fit context window", zap.String("model", model.String()), zap.Int("issue", req.IssueNumber), zap.Strings("files", fitted.OmittedFiles))
+			}
+			omittedFiles = fitted.OmittedFiles
+			prompt, err := fitted.getPrompt(structured)
+			return userMessage(prompt), err
 		},
-	)
+		schema:      codeChangeSchema,
+		attribution: []zap.Field{zap.Int("issue", req.IssueNumber)},
+	}, ParseCodeChangeResponse, "codechangeresponse", req.IssueNumber)
 	if err != nil {
-		oc.log.Error("chat completion error", zap.Error(err))
 		return res, err
 	}
 
-	choice := resp.Choices[0].Message.Content
+	res.OmittedFiles = omittedFiles
+	return res, nil
+}
 
-	oc.log.Info("got response from llm")
+// EvaluateDiffComment sends a diff comment request to the first available model in the chain provided (or the default chain if empty).
+func (oc *OpenAIClient) EvaluateDiffComment(ctx context.Context, models []Model, req DiffCommentRequest) (DiffCommentResponse, error) {
+	return evaluateResponse(ctx, oc, models, request{
+		prompt: func(model Model, structured bool) ([]openai.ChatCompletionMessage, error) {
+			fitted, messages, err := fitDiffCommentRequest(model, req, structured)
+			if len(fitted.ContextFiles) < len(req.ContextFiles) {
+				oc.log.Warn("omitted context files from prompt to fit context window", zap.String("model", model.String()), zap.Int("pr", req.PRNumber), zap.Int("kept", len(fitted.ContextFiles)), zap.Int("total", len(req.ContextFiles)))
+			}
+			return messages, err
+		},
+		schema:      diffCommentSchema,
+		attribution: []zap.Field{zap.Int("pr", req.PRNumber)},
+	}, ParseDiffCommentResponse, "diffcommentresponse", req.PRNumber)
+}
 
-	debugFilePrefix := fmt.Sprintf("%d-%d", req.IssueNumber, time.Now().Unix())
-	oc.writeDebug("codechangeresponse", debugFilePrefix+"-req.txt", req.String())
-	oc.writeDebug("codechangeresponse", debugFilePrefix+"-res.yaml", choice)
+// EvaluateIssueComment sends a request to respond to an issue or pull request conversation to the first available
+// model in the chain provided (or the default chain if empty).
+func (oc *OpenAIClient) EvaluateIssueComment(ctx context.Context, models []Model, req IssueCommentRequest) (IssueCommentResponse, error) {
+	return evaluateResponse(ctx, oc, models, request{
+		prompt: func(model Model, structured bool) ([]openai.ChatCompletionMessage, error) {
+			messages, err := req.getMessages(structured)
+			if err != nil {
+				return nil, err
+			}
+			return messages, checkPromptSize(model, transcript(messages), 0)
+		},
+		schema:      issueCommentSchema,
+		attribution: []zap.Field{zap.Int("issue", req.Number)},
+	}, ParseIssueCommentResponse, "issuecommentresponse", req.Number)
+}
 
-	return ParseCodeChangeResponse(choice)
+// EvaluateReview sends a request to address a pull request review to the first available model in the chain provided
+// (or the default chain if empty).
+func (oc *OpenAIClient) EvaluateReview(ctx context.Context, models []Model, req ReviewRequest) (ReviewResponse, error) {
+	return evaluateResponse(ctx, oc, models, request{
+		prompt: func(model Model, structured bool) ([]openai.ChatCompletionMessage, error) {
+			prompt, err := req.getPrompt(structured)
+			if err != nil {
+				return nil, err
+			}
+			// the response may contain the full contents of every file
+			responseTokens := 0
+			for _, f := range req.Files {
+				responseTokens += CountTokens(model.Name, f.Contents)
+			}
+			return userMessage(prompt), checkPromptSize(model, prompt, responseTokens)
+		},
+		schema:      reviewSchema,
+		attribution: []zap.Field{zap.Int("pr", req.PRNumber)},
+	}, ParseReviewResponse, "reviewresponse", req.PRNumber)
 }
 
-func (oc *OpenAIClient) EvaluateDiffComment(ctx context.Context, model string, req DiffCommentRequest) (res DiffCommentResponse, err error) {
-	if model == "" {
-		model = oc.defaultModel
+// evaluateResponse sends r to the first available model in the chain provided, and parses the response into a T:
+// with parseYAML, or as JSON if the model responds with structured output. The model is set on the response if the
+// request succeeds, and the usage is set either way. The prompt and response are written to subdir of the debug
+// directory, prefixed with the number of the issue or pull request.
+func evaluateResponse[T any, PT interface {
+	*T
+	setResult(model string, usage []Usage)
+}](ctx context.Context, oc *OpenAIClient, models []Model, r request, parseYAML func(string) (T, error), subdir string, number int) (T, error) {
+	var res T
+	r.parse = func(content string, structured bool) (err error) {
+		var empty T
+		res = empty
+		if structured {
+			return parseJSONResponse(content, &res)
+		}
+		res, err = parseYAML(content)
+		return err
 	}
-	resp, err := oc.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: req.String(),
-				},
-			},
-		},
-	)
+	c, err := oc.evaluate(ctx, models, r)
+
+	debugFilePrefix := fmt.Sprintf("%d-%d", number, time.Now().Unix())
+	oc.writeDebug(subdir, debugFilePrefix+"-req.txt", c.prompt)
+	oc.writeDebug(subdir, debugFilePrefix+"-res.yaml", c.content)
 	if err != nil {
-		oc.log.Error("chat completion error", zap.Error(err))
+		PT(&res).setResult("", c.usage)
 		return res, err
 	}
 
-	choice := resp.Choices[0].Message.Content
+	oc.log.Info("got response from llm", zap.String("model", c.model.Name))
+	PT(&res).setResult(c.model.Name, c.usage)
+	return res, nil
+}
+
+// maxRepairAttempts is the number of times a model is asked to correct a response that could not be parsed.
+const maxRepairAttempts = 2
+
+// request is an LLM request that can be made with any output format.
+type request struct {
+	// prompt returns the messages for the request, fitted to the model's limits.
+	// If structured is true, the response format is provided separately via schema.
+	prompt func(model Model, structured bool) ([]openai.ChatCompletionMessage, error)
+	schema responseSchema
+	// parse parses the content of a response. If structured is true, the content is JSON matching schema. It is set by
+	// evaluateResponse.
+	parse func(content string, structured bool) error
+	// attribution contains fields to identify the request in logs.
+	attribution []zap.Field
+}
+
+// completion is the result of a successful chat completion.
+type completion struct {
+	// content is the response - either the message content, or the arguments of a function call.
+	content string
+	message openai.ChatCompletionMessage
+	model   Model
+	// prompt is a transcript of the messages sent to the model.
+	prompt string
+	// usage contains the tokens used by every request made to produce the completion, including failed attempts.
+	usage []Usage
+}
+
+// evaluate sends the request to each model in the chain until one of them responds with something that can be parsed.
+func (oc *OpenAIClient) evaluate(ctx context.Context, models []Model, r request) (completion, error) {
+	if len(models) == 0 {
+		models = oc.defaultModels
+	}
+	if len(models) == 0 {
+		return completion{}, errors.New("no models configured")
+	}
+
+	var lastErr error
+	var last completion
+	var usage []Usage
+	for _, model := range models {
+		c, err := oc.evaluateModel(ctx, model, r)
+		usage = append(usage, c.usage...)
+		c.usage = usage
+		if err == nil {
+			return c, nil
+		}
+		if ctx.Err() != nil {
+			return c, ctx.Err()
+		}
+		oc.log.Error("model failed, falling back to next model", append([]zap.Field{zap.String("model", model.String()), zap.Error(err)}, r.attribution...)...)
+		lastErr = fmt.Errorf("%s: %w", model, err)
+		if c.content != "" {
+			last = c
+		}
+	}
+
+	last.usage = usage
+	return last, fmt.Errorf("all models failed, last error: %w", lastErr)
+}
+
+// evaluateModel sends the request to a single model, using the model's output format.
+// If the response cannot be parsed, the parse error is sent back to the model so that it can correct its response.
+func (oc *OpenAIClient) evaluateModel(ctx context.Context, model Model, r request) (completion, error) {
+	format := model.outputFormat()
+	structured := format != OutputYAML
+
+	messages, err := r.prompt(model, structured)
+	if err != nil {
+		return completion{}, err
+	}
+	prompt := transcript(messages)
+
+	chatReq := openai.ChatCompletionRequest{
+		Model:    model.Name,
+		Messages: messages,
+	}
+	switch format {
+	case OutputTools:
+		chatReq.Tools = []openai.Tool{{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        r.schema.name,
+				Description: r.schema.description,
+				Parameters:  r.schema.schema,
+			},
+		}}
+		chatReq.ToolChoice = openai.ToolChoice{
+			Type:     openai.ToolTypeFunction,
+			Function: openai.ToolFunction{Name: r.schema.name},
+		}
+	case OutputJSONSchema:
+		chatReq.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        r.schema.name,
+				Description: r.schema.description,
+				Schema:      r.schema.schema,
+				Strict:      true,
+			},
+		}
+	}
+
+	c, err := oc.complete(ctx, model, chatReq, r.attribution...)
+	if err != nil {
+		if structured && isStructuredOutputUnsupported(err) {
+			// the API may not support structured output, so fall back to describing the format in the prompt
+			oc.log.Warn("structured output request failed, falling back to yaml", append([]zap.Field{zap.String("model", model.String()), zap.String("format", string(format)), zap.Error(err)}, r.attribution...)...)
+			model.Output = OutputYAML
+			return oc.evaluateModel(ctx, model, r)
+		}
+		return c, err
+	}
+	c.prompt = prompt
+
+	err = r.parse(c.content, structured)
+	for attempt := 1; err != nil && attempt <= maxRepairAttempts; attempt++ {
+		oc.log.Warn("could not parse llm response, asking for a correction", append([]zap.Field{zap.String("model", model.String()), zap.Int("repair attempt", attempt), zap.Error(err)}, r.attribution...)...)
+
+		chatReq.Messages = append(chatReq.Messages, c.message)
+		if len(c.message.ToolCalls) > 0 {
+			// every tool call must be responded to
+			for _, call := range c.message.ToolCalls {
+				chatReq.Messages = append(chatReq.Messages, openai.ChatCompletionMessage{
+					Role:       openai.ChatMessageRoleTool,
+					ToolCallID: call.ID,
+					Content:    fmt.Sprintf("The arguments could not be parsed:\n%s\n\nCall %s again with corrected arguments.", err, r.schema.name),
+				})
+			}
+		} else {
+			format := "YAML, following the template exactly. Respond only with YAML"
+			if structured {
+				format = "JSON, following the schema exactly. Respond only with JSON"
+			}
+			chatReq.Messages = append(chatReq.Messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("Your response could not be parsed:\n%s\n\nRespond again with the corrected %s, and nothing else.", err, format),
+			})
+		}
 
-	oc.log.Info("got response from llm", zap.String("output", choice))
+		var repaired completion
+		repaired, err = oc.complete(ctx, model, chatReq, r.attribution...)
+		if err != nil {
+			return c, err
+		}
+		repaired.prompt = prompt
+		repaired.usage = append(c.usage, repaired.usage...)
+		c = repaired
+		err = r.parse(c.content, structured)
+	}
+
+	return c, err
+}
 
-	debugFilePrefix := fmt.Sprintf("%d-%d", req.PRNumber, time.Now().Unix())
-	oc.writeDebug("diffcommentresponse", debugFilePrefix+"-req.txt", req.String())
-	oc.writeDebug("diffcommentresponse", debugFilePrefix+"-res.yaml", choice)
+// complete sends a chat completion request to a model. Transient errors (rate limits, server errors and timeouts)
+// are retried with backoff. Every attempt is logged with the attribution fields provided.
+func (oc *OpenAIClient) complete(ctx context.Context, model Model, chatReq openai.ChatCompletionRequest, attribution ...zap.Field) (completion, error) {
+	client := oc.getClient(model)
+
+	var err error
+	for attempt := 0; attempt <= oc.retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			err := timeutil.SleepContext(ctx, backoff(oc.retry.BaseDelay, attempt))
+			if err != nil {
+				return completion{}, err
+			}
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if oc.retry.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, oc.retry.Timeout)
+		}
+		start := time.Now()
+		var resp openai.ChatCompletionResponse
+		resp, err = client.CreateChatCompletion(attemptCtx, chatReq)
+		cancel()
+		if err == nil && len(resp.Choices) == 0 {
+			err = errors.New("response contained no choices")
+		}
+
+		fields := append([]zap.Field{
+			zap.String("model", model.String()),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("duration", time.Since(start)),
+		}, attribution...)
+		if err == nil {
+			oc.log.Info("chat completion succeeded", fields...)
+			message := resp.Choices[0].Message
+			content := message.Content
+			if len(message.ToolCalls) > 0 {
+				content = message.ToolCalls[0].Function.Arguments
+			}
+			return completion{
+				content: content,
+				message: message,
+				model:   model,
+				usage: []Usage{{
+					Model:            model.Name,
+					PromptTokens:     resp.Usage.PromptTokens,
+					CompletionTokens: resp.Usage.CompletionTokens,
+				}},
+			}, nil
+		}
+		oc.log.Error("chat completion error", append(fields, zap.Error(err))...)
+
+		if ctx.Err() != nil {
+			return completion{}, ctx.Err()
+		}
+		if !isTransient(err) {
+			break
+		}
+	}
+
+	return completion{}, err
+}
+
+// userMessage returns a conversation consisting of a single message from the user.
+func userMessage(prompt string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt,
+	}}
+}
+
+// transcript returns the contents of a conversation as a single string, for debugging and counting tokens.
+// A conversation with a single message is returned as is.
+func transcript(messages []openai.ChatCompletionMessage) string {
+	if len(messages) == 1 {
+		return messages[0].Content
+	}
+	out := ""
+	for _, m := range messages {
+		out += fmt.Sprintf("%s:\n%s\n\n", m.Role, m.Content)
+	}
+	return out
+}
+
+// getClient returns a client for the API serving the provided model.
+func (oc *OpenAIClient) getClient(model Model) *openai.Client {
+	token := model.Token
+	if token == "" {
+		token = oc.token
+	}
+	key := model.BaseURL + "|" + token
 
-	return ParseDiffCommentResponse(choice)
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	client, ok := oc.clients[key]
+	if !ok {
+		cfg := openai.DefaultConfig(token)
+		if model.BaseURL != "" {
+			cfg.BaseURL = model.BaseURL
+		}
+		client = openai.NewClientWithConfig(cfg)
+		oc.clients[key] = client
+	}
+	return client
+}
+
+// isTransient returns true if the error is likely to succeed if the request is retried.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	status := httpStatus(err)
+	return status == 429 || status >= 500
+}
+
+// structuredOutputParams are the request parameters used to ask for structured output, which some APIs do not support.
+var structuredOutputParams = []string{"tool", "function", "response_format", "json_schema", "json schema"}
+
+// unsupportedPhrases are used by APIs to reject parameters they do not support.
+var unsupportedPhrases = []string{"not support", "unsupported", "invalid parameter", "unrecognized", "unknown", "not allowed", "extra inputs", "not permitted"}
+
+// isStructuredOutputUnsupported returns true if the API rejected a request because it does not support the
+// parameters used to ask for structured output. Other rejected requests (e.g. because the prompt is too long) would
+// fail in the same way without structured output.
+func isStructuredOutputUnsupported(err error) bool {
+	status := httpStatus(err)
+	if status != 400 && status != 422 {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	return containsAny(message, structuredOutputParams) && containsAny(message, unsupportedPhrases)
+}
+
+// containsAny returns true if s contains any of substrs.
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpStatus returns the HTTP status code associated with an API error, or 0 if there is none.
+func httpStatus(err error) int {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// backoff returns a random delay between 0 and base*2^attempt.
+func backoff(base time.Duration, attempt int) time.Duration {
+	max := base << uint(attempt)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
 }
 
 func (oc *OpenAIClient) writeDebug(subdir, filename, contents string) {
@@ -0,0 +1,220 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeResponse is a response of a fakeAPI. If status is not 200, body is the error message.
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+// reply is a successful response with content, which used 10 prompt tokens and 5 completion tokens.
+func reply(content string) fakeResponse {
+	return replyWith(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content})
+}
+
+// toolReply is a successful response calling the function name with arguments.
+func toolReply(name, arguments string) fakeResponse {
+	return replyWith(openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleAssistant,
+		ToolCalls: []openai.ToolCall{{
+			ID:       "call-1",
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: name, Arguments: arguments},
+		}},
+	})
+}
+
+func replyWith(message openai.ChatCompletionMessage) fakeResponse {
+	body, err := json.Marshal(openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: message}},
+		Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 5},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return fakeResponse{status: http.StatusOK, body: string(body)}
+}
+
+// failure is an API error with status.
+func failure(status int, message string) fakeResponse {
+	return fakeResponse{status: status, body: message}
+}
+
+// fakeAPI is an OpenAI-compatible API that responds to requests for each model with the next of its responses, and
+// records the requests it receives.
+type fakeAPI struct {
+	mu        sync.Mutex
+	url       string
+	responses map[string][]fakeResponse
+	requests  map[string][]openai.ChatCompletionRequest
+}
+
+func newFakeAPI(t *testing.T, responses map[string][]fakeResponse) *fakeAPI {
+	api := &fakeAPI{
+		responses: responses,
+		requests:  make(map[string][]openai.ChatCompletionRequest),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		api.mu.Lock()
+		api.requests[req.Model] = append(api.requests[req.Model], req)
+		var res fakeResponse
+		if len(api.responses[req.Model]) > 0 {
+			res = api.responses[req.Model][0]
+			api.responses[req.Model] = api.responses[req.Model][1:]
+		} else {
+			res = failure(http.StatusBadRequest, "no responses left")
+		}
+		api.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(res.status)
+		if res.status != http.StatusOK {
+			fmt.Fprintf(w, `{"error":{"message":%q,"type":"test"}}`, res.body)
+			return
+		}
+		w.Write([]byte(res.body))
+	}))
+	t.Cleanup(server.Close)
+	api.url = server.URL
+	return api
+}
+
+// model returns a model served by the API.
+func (api *fakeAPI) model(name string, output OutputFormat) Model {
+	return Model{Name: name, BaseURL: api.url, Token: "token", Output: output}
+}
+
+// attempts returns the number of requests received for a model.
+func (api *fakeAPI) attempts(name string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.requests[name])
+}
+
+func newTestClient(models []Model, maxRetries int) *OpenAIClient {
+	return NewOpenAIClient(zap.NewNop(), models, "", "", RetryConfig{MaxRetries: maxRetries, BaseDelay: time.Millisecond})
+}
+
+func TestIsTransient(t *testing.T) {
+	var testCases = []struct {
+		testcase  string
+		err       error
+		transient bool
+	}{
+		{"timeout", fmt.Errorf("request: %w", context.DeadlineExceeded), true},
+		{"rate limited", &openai.APIError{HTTPStatusCode: 429}, true},
+		{"server error", &openai.APIError{HTTPStatusCode: 500}, true},
+		{"bad gateway without an error body", &openai.RequestError{HTTPStatusCode: 502}, true},
+		{"bad request", &openai.APIError{HTTPStatusCode: 400}, false},
+		{"unauthorized", &openai.APIError{HTTPStatusCode: 401}, false},
+		{"canceled", context.Canceled, false},
+		{"other error", errors.New("response contained no choices"), false},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		require.Equal(t, tt.transient, isTransient(tt.err))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 5; attempt++ {
+		for i := 0; i < 100; i++ {
+			d := backoff(10*time.Millisecond, attempt)
+			require.GreaterOrEqual(t, d, time.Duration(0))
+			require.Less(t, d, (10*time.Millisecond)<<uint(attempt))
+		}
+	}
+	require.Zero(t, backoff(0, 3))
+}
+
+func TestComplete(t *testing.T) {
+	var testCases = []struct {
+		testcase  string
+		responses []fakeResponse
+		attempts  int
+		content   string
+		err       string
+	}{
+		{"success", []fakeResponse{reply("hello")}, 1, "hello", ""},
+		{"rate limited then success", []fakeResponse{failure(429, "slow down"), reply("hello")}, 2, "hello", ""},
+		{"server errors until retries run out", []fakeResponse{failure(500, "oops"), failure(502, "oops"), failure(503, "oops"), reply("hello")}, 3, "", "503"},
+		{"bad request not retried", []fakeResponse{failure(400, "bad"), reply("hello")}, 1, "", "400"},
+		{"no choices", []fakeResponse{{status: http.StatusOK, body: `{"choices":[]}`}, reply("hello")}, 1, "", "no choices"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		api := newFakeAPI(t, map[string][]fakeResponse{"m": tt.responses})
+		model := api.model("m", OutputYAML)
+		c, err := newTestClient(nil, 2).complete(context.Background(), model, openai.ChatCompletionRequest{Model: "m", Messages: userMessage("hi")})
+		require.Equal(t, tt.attempts, api.attempts("m"))
+		if tt.err != "" {
+			require.ErrorContains(t, err, tt.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.content, c.content)
+		require.Equal(t, []Usage{{Model: "m", PromptTokens: 10, CompletionTokens: 5}}, c.usage)
+	}
+}
+
+func TestEvaluateFallsBackToNextModel(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		first    []fakeResponse
+		// attempts is the number of requests made to the first model
+		attempts int
+		// usage is the number of requests that were paid for
+		usage int
+		model string
+		err   bool
+	}{
+		{"first model succeeds", []fakeResponse{reply("response: from first")}, 1, 1, "first", false},
+		{"first model retried", []fakeResponse{failure(500, "oops"), reply("response: from first")}, 2, 1, "first", false},
+		{"first model unavailable", []fakeResponse{failure(500, "oops"), failure(500, "oops")}, 2, 1, "second", false},
+		{"first model rejects the request", []fakeResponse{failure(401, "bad key")}, 1, 1, "second", false},
+		// every response of the first model was paid for, even though none could be parsed
+		{"first model responds with something else", []fakeResponse{reply("- a list"), reply("- a list"), reply("- a list")}, 3, 4, "second", false},
+		{"every model fails", []fakeResponse{failure(500, "oops"), failure(500, "oops")}, 2, 0, "", true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		second := []fakeResponse{reply("response: from second")}
+		if tt.err {
+			second = []fakeResponse{failure(403, "forbidden")}
+		}
+		api := newFakeAPI(t, map[string][]fakeResponse{"first": tt.first, "second": second})
+		client := newTestClient([]Model{api.model("first", OutputYAML), api.model("second", OutputYAML)}, 1)
+
+		res, err := client.EvaluateIssueComment(context.Background(), nil, IssueCommentRequest{Prompts: DefaultPrompts(), Number: 1, Contents: "hi"})
+		require.Equal(t, tt.attempts, api.attempts("first"))
+		require.Len(t, res.Usage, tt.usage)
+		if tt.err {
+			require.ErrorContains(t, err, "all models failed")
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.model, res.Model)
+		require.Equal(t, "from "+tt.model, res.Response)
+	}
+}
@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// responseKeys are the top-level keys that may start a YAML response.
+var responseKeys = []string{"files:", "notes:", "responseType:", "response:", "file:"}
+
+// keyPattern matches lines containing a key that is part of a response template.
+var keyPattern = regexp.MustCompile(`^\s*(-\s+)?(files|notes|responseType|response|file|path|contents):`)
+
+// parseYAML unmarshals an LLM response into out. If the response is not valid YAML as-is, the YAML document
+// is extracted from any surrounding prose or code fences, and common indentation mistakes are repaired.
+func parseYAML(llmResponse string, out interface{}) error {
+	err := yaml.Unmarshal([]byte(llmResponse), out)
+	if err == nil {
+		return nil
+	}
+
+	repaired := repairBlockIndentation(extractYAML(llmResponse))
+	if yaml.Unmarshal([]byte(repaired), out) != nil {
+		// return the original error, since it refers to the text the LLM actually returned
+		return err
+	}
+	return nil
+}
+
+// extractYAML locates the YAML document within an LLM response, removing code fences and any prose before or after it.
+func extractYAML(llmResponse string) string {
+	lines := strings.Split(strings.ReplaceAll(llmResponse, "\r\n", "\n"), "\n")
+
+	// if the YAML is wrapped in a code fence, only keep the contents of the fence
+	// file contents may contain code fences as well, but those will be indented within the YAML document
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "```") {
+			continue
+		}
+		if start == -1 {
+			start = i
+		} else {
+			end = i
+		}
+	}
+	if start != -1 {
+		// keep the line break before the closing fence
+		lines = append(lines[start+1:end:end], "")
+	}
+
+	// skip any prose before the first top-level key
+	for i, line := range lines {
+		for _, key := range responseKeys {
+			if strings.HasPrefix(line, key) {
+				return strings.Join(trimTrailingProse(lines[i:]), "\n")
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// trimTrailingProse removes unindented lines after the last block of the YAML document that do not contain a key.
+func trimTrailingProse(lines []string) []string {
+	end := len(lines)
+	for end > 0 {
+		line := lines[end-1]
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || keyPattern.MatchString(line) {
+			break
+		}
+		end--
+	}
+	return lines[:end]
+}
+
+// repairBlockIndentation fixes literal block scalars (e.g. "contents: |") whose lines are not indented more than
+// the key they belong to, or whose first line is indented more than the lines after it. The block ends at the next
+// line that contains a key from the response template and is not indented more than the block's key.
+func repairBlockIndentation(doc string) string {
+	lines := strings.Split(doc, "\n")
+	out := make([]string, 0, len(lines))
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimRight(line, " ")
+		if !keyPattern.MatchString(line) || !(strings.HasSuffix(trimmed, "|") || strings.HasSuffix(trimmed, "|-")) {
+			out = append(out, line)
+			continue
+		}
+
+		// the column of the key - for list items, this is after the "- " marker
+		keyIndent := indentation(line)
+		if rest := line[keyIndent:]; strings.HasPrefix(rest, "-") {
+			keyIndent = len(line) - len(strings.TrimLeft(rest[1:], " "))
+		}
+
+		end := i + 1
+		for end < len(lines) && !(keyPattern.MatchString(lines[end]) && indentation(lines[end]) <= keyIndent) {
+			end++
+		}
+		block := lines[i+1 : end]
+
+		minIndent, firstIndent := -1, -1
+		for _, l := range block {
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			if firstIndent == -1 {
+				firstIndent = indentation(l)
+			}
+			if minIndent == -1 || indentation(l) < minIndent {
+				minIndent = indentation(l)
+			}
+		}
+
+		if minIndent == -1 || (minIndent > keyIndent && firstIndent == minIndent) {
+			// the block is empty or already valid
+			out = append(out, line)
+			out = append(out, block...)
+			i = end - 1
+			continue
+		}
+
+		shift := 0
+		if minIndent <= keyIndent {
+			shift = keyIndent + 2 - minIndent
+		}
+		// an explicit indentation indicator allows the first line to be indented more than the rest of the block
+		indicator := strconv.Itoa(minIndent + shift - keyIndent)
+		if strings.HasSuffix(trimmed, "|-") {
+			out = append(out, strings.TrimSuffix(trimmed, "|-")+"|"+indicator+"-")
+		} else {
+			out = append(out, strings.TrimSuffix(trimmed, "|")+"|"+indicator)
+		}
+		for _, l := range block {
+			if strings.TrimSpace(l) == "" {
+				out = append(out, "")
+				continue
+			}
+			out = append(out, strings.Repeat(" ", shift)+l)
+		}
+		i = end - 1
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// indentation returns the number of leading spaces in a line.
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
@@ -0,0 +1,212 @@
+package llm_test
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCodeChangeResponse(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		response string
+		parsed   llm.CodeChangeResponse
+		fails    bool
+	}{
+		{
+			"valid yaml",
+			`files:
+  -
+    path: main.go
+    contents: |
+      package main
+
+      func main() {}
+notes: |
+  added main function
+`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "main.go", Contents: "package main\n\nfunc main() {}\n"}},
+				Notes: "added main function\n",
+			},
+			false,
+		},
+		{
+			"summary and rationale",
+			`files:
+  - path: main.go
+    contents: |
+      package main
+summary: |
+  added main package
+changes:
+  - path: main.go
+    rationale: the program needs an entry point
+notes: |
+  nothing else to add
+`,
+			llm.CodeChangeResponse{
+				Files:   []llm.File{{Path: "main.go", Contents: "package main\n"}},
+				Summary: "added main package\n",
+				Changes: []llm.FileChange{{Path: "main.go", Rationale: "the program needs an entry point"}},
+				Notes:   "nothing else to add\n",
+			},
+			false,
+		},
+		{
+			"wrapped in a code fence",
+			"```yaml\nfiles:\n  - path: index.html\n    contents: |\n      <html></html>\nnotes: |\n  added index\n```",
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "index.html", Contents: "<html></html>\n"}},
+				Notes: "added index\n",
+			},
+			false,
+		},
+		{
+			"preamble and trailing prose",
+			`Sure! Here are the changes you asked for:
+
+` + "```" + `
+files:
+  - path: README.md
+    contents: |
+      # Title
+      ` + "```" + `
+      go run main.go
+      ` + "```" + `
+notes: |
+  updated readme
+` + "```" + `
+
+Let me know if you need anything else.`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "README.md", Contents: "# Title\n```\ngo run main.go\n```\n"}},
+				Notes: "updated readme\n",
+			},
+			false,
+		},
+		{
+			"preamble without a code fence",
+			`Here is the updated file.
+files:
+  - path: a.txt
+    contents: |
+      hello
+notes: |
+  said hello`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "a.txt", Contents: "hello\n"}},
+				Notes: "said hello",
+			},
+			false,
+		},
+		{
+			"contents not indented past key",
+			`files:
+  - path: main.go
+    contents: |
+    package main
+
+    func main() {
+    	println("hi")
+    }
+notes: |
+  printed hi
+`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "main.go", Contents: "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"}},
+				Notes: "printed hi\n",
+			},
+			false,
+		},
+		{
+			"contents not indented at all",
+			`files:
+  - path: main.go
+    contents: |
+package main
+
+func main() {}
+  - path: other.go
+    contents: |
+      package main
+notes: |
+  two files
+`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{
+					{Path: "main.go", Contents: "package main\n\nfunc main() {}\n"},
+					{Path: "other.go", Contents: "package main\n"},
+				},
+				Notes: "two files\n",
+			},
+			false,
+		},
+		{
+			"first line of contents indented more than the rest",
+			`files:
+  - path: main.go
+    contents: |
+          // comment
+      package main
+notes: |
+  commented
+`,
+			llm.CodeChangeResponse{
+				Files: []llm.File{{Path: "main.go", Contents: "    // comment\npackage main\n"}},
+				Notes: "commented\n",
+			},
+			false,
+		},
+		{
+			"no yaml at all",
+			"I'm sorry, but I cannot help with that.",
+			llm.CodeChangeResponse{},
+			true,
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		parsed, err := llm.ParseCodeChangeResponse(tt.response)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.parsed, parsed)
+	}
+}
+
+func TestParseDiffCommentResponse(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		response string
+		parsed   llm.DiffCommentResponse
+	}{
+		{
+			"answer",
+			"responseType: 0\nresponse: |\n  it registers the handler\n",
+			llm.DiffCommentResponse{
+				Type:     llm.ResponseAnswer,
+				Response: "it registers the handler\n",
+			},
+		},
+		{
+			"fenced code change with preamble",
+			"The comment is a request for changes.\n```yml\nresponseType: 1\nfile:\n  path: main.go\n  contents: |\n  package main\nresponse: |\n  removed whitespace\n```",
+			llm.DiffCommentResponse{
+				Type:     llm.ResponseCodeChange,
+				Response: "removed whitespace\n",
+				File:     llm.File{Path: "main.go", Contents: "package main\n"},
+			},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		parsed, err := llm.ParseDiffCommentResponse(tt.response)
+		require.NoError(t, err)
+		require.Equal(t, tt.parsed, parsed)
+	}
+}
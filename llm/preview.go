@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PromptSection is a part of a prompt, with the number of tokens it contains.
+type PromptSection struct {
+	Name   string
+	Tokens int
+}
+
+// PromptPreview is a prompt as it would be sent to a model, with the number of tokens in each of its sections.
+type PromptPreview struct {
+	Model string
+	// Format is the format the model is asked to respond in.
+	Format OutputFormat
+	Prompt string
+	// Schema is the JSON schema sent with the prompt to request structured output, or empty if the format is YAML.
+	Schema string
+	// Sections contains the files, task, and other parts of the prompt. The template section contains the tokens that
+	// are not in any other section, such as instructions and formatting.
+	Sections []PromptSection
+	// Tokens is the total number of tokens in the prompt and schema.
+	Tokens int
+	// OmittedFiles contains the paths of files removed from the prompt to fit the model's limits.
+	OmittedFiles []string
+}
+
+// PreviewCodeChangeRequest returns the prompt that would be sent to model for req, after removing the files that do not
+// fit in the model's limits. If the prompt cannot fit, the preview is returned along with a PromptTooLargeError.
+func PreviewCodeChangeRequest(model Model, req CodeChangeRequest) (PromptPreview, error) {
+	format := model.outputFormat()
+	structured := format != OutputYAML
+
+	fitted, fitErr := fitCodeChangeRequest(model, req, structured)
+	prompt, err := fitted.getPrompt(structured)
+	if err != nil {
+		return PromptPreview{}, err
+	}
+
+	preview := PromptPreview{
+		Model:        model.Name,
+		Format:       format,
+		Prompt:       prompt,
+		Tokens:       CountTokens(model.Name, prompt),
+		OmittedFiles: fitted.OmittedFiles,
+	}
+	remaining := preview.Tokens
+	addSection := func(name, text string) {
+		// empty files are still listed, since they are in the prompt to be created
+		if text == "" && !strings.HasPrefix(name, "file ") {
+			return
+		}
+		tokens := CountTokens(model.Name, text)
+		remaining -= tokens
+		preview.Sections = append(preview.Sections, PromptSection{Name: name, Tokens: tokens})
+	}
+	for _, f := range fitted.Files {
+		addSection("file "+f.Path, f.Contents)
+	}
+	addSection("task", fitted.Subject+"\n"+fitted.Body)
+	addSection("previous diff", fitted.PreviousDiff)
+	feedback := []string{}
+	for _, c := range fitted.Feedback {
+		feedback = append(feedback, c.Body)
+	}
+	addSection("feedback", strings.Join(feedback, "\n"))
+	preview.Sections = append(preview.Sections, PromptSection{Name: "template", Tokens: remaining})
+
+	if structured {
+		schema, err := json.MarshalIndent(codeChangeSchema.schema, "", "  ")
+		if err != nil {
+			return PromptPreview{}, err
+		}
+		preview.Schema = string(schema)
+		tokens := CountTokens(model.Name, preview.Schema)
+		preview.Sections = append(preview.Sections, PromptSection{Name: "schema", Tokens: tokens})
+		preview.Tokens += tokens
+	}
+
+	return preview, fitErr
+}
+
+// ParseSavedCodeChangeResponse parses a response to a code change request that was saved from a model, e.g. in the
+// debug directory. Responses starting with "{" are parsed as structured output, and other responses as YAML.
+func ParseSavedCodeChangeResponse(llmResponse string) (res CodeChangeResponse, structured bool, err error) {
+	if strings.HasPrefix(strings.TrimSpace(llmResponse), "{") {
+		err = parseJSONResponse(llmResponse, &res)
+		return res, true, err
+	}
+	res, err = ParseCodeChangeResponse(llmResponse)
+	return res, false, err
+}
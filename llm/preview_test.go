@@ -0,0 +1,79 @@
+package llm_test
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewCodeChangeRequest(t *testing.T) {
+	req := llm.CodeChangeRequest{
+		Subject: "fix the bug",
+		Body:    "main.go panics on empty input",
+		Files: []llm.File{
+			{Path: "main.go", Contents: "package main\n\nfunc main() {}\n"},
+			{Path: "new.go", Contents: ""},
+		},
+	}
+
+	var testCases = []struct {
+		testcase   string
+		model      llm.Model
+		sections   []string
+		schema     bool
+		omitted    []string
+		tooLarge   bool
+		promptHave string
+	}{
+		{"structured", llm.Model{Name: "gpt-4o"}, []string{"file main.go", "file new.go", "task", "template", "schema"}, true, nil, false, "main.go panics on empty input"},
+		{"yaml", llm.Model{Name: "local", BaseURL: "http://localhost"}, []string{"file main.go", "file new.go", "task", "template"}, false, nil, false, "Respond in a parseable YAML format"},
+		{"too large", llm.Model{Name: "gpt-4o", ContextWindow: 100}, []string{"file new.go", "task", "template", "schema"}, true, []string{"main.go"}, true, "fix the bug"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		preview, err := llm.PreviewCodeChangeRequest(tt.model, req)
+		if tt.tooLarge {
+			require.ErrorAs(t, err, &llm.PromptTooLargeError{})
+		} else {
+			require.NoError(t, err)
+		}
+		require.Contains(t, preview.Prompt, tt.promptHave)
+		require.Equal(t, tt.schema, preview.Schema != "")
+		require.Equal(t, tt.omitted, preview.OmittedFiles)
+
+		names := []string{}
+		total := 0
+		for _, s := range preview.Sections {
+			names = append(names, s.Name)
+			total += s.Tokens
+		}
+		require.Equal(t, tt.sections, names)
+		require.Equal(t, preview.Tokens, total)
+	}
+}
+
+func TestParseSavedCodeChangeResponse(t *testing.T) {
+	var testCases = []struct {
+		testcase   string
+		response   string
+		structured bool
+		files      []string
+	}{
+		{"yaml", "files:\n  - path: a.go\n    contents: |\n      package a\nnotes: |\n  added a\n", false, []string{"a.go"}},
+		{"structured", `{"files":[{"path":"b.go","contents":"package b"}],"notes":"added b","summary":"","changes":[]}`, true, []string{"b.go"}},
+		{"structured with whitespace", "\n  " + `{"files":[{"path":"c.go","contents":"package c"}],"notes":"","summary":"","changes":[]}`, true, []string{"c.go"}},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		res, structured, err := llm.ParseSavedCodeChangeResponse(tt.response)
+		require.NoError(t, err)
+		require.Equal(t, tt.structured, structured)
+		paths := []string{}
+		for _, f := range res.Files {
+			paths = append(paths, f.Path)
+		}
+		require.Equal(t, tt.files, paths)
+	}
+}
@@ -0,0 +1,166 @@
+package llm
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+const (
+	codeChangeTemplate   = "code-change-request.tmpl"
+	diffCommentTemplate  = "comment-diff-request.tmpl"
+	issueCommentTemplate = "comment-issue-request.tmpl"
+	reviewTemplate       = "review-request.tmpl"
+
+	pullRequestBodyTemplate = "pull-request-body.tmpl"
+)
+
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+// defaultPrompts contains the templates embedded in the binary.
+var defaultPrompts = mustParseEmbeddedPrompts()
+
+// Prompts contains the templates used to generate prompts, keyed by file name.
+type Prompts struct {
+	templates map[string]*template.Template
+}
+
+func mustParseEmbeddedPrompts() *Prompts {
+	p := &Prompts{templates: make(map[string]*template.Template)}
+	names, err := fs.Glob(embeddedPrompts, "prompts/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range names {
+		tmpl := template.Must(template.ParseFS(embeddedPrompts, name))
+		p.templates[filepath.Base(name)] = tmpl
+	}
+	return p
+}
+
+// DefaultPrompts returns the templates embedded in the binary.
+func DefaultPrompts() *Prompts {
+	return defaultPrompts
+}
+
+// LoadPrompts returns the default templates, overridden by any templates with the same file name in the provided
+// directories. Directories are applied in order, so later directories take precedence. Directories that do not exist
+// are ignored. Every template is validated by rendering it with an empty request.
+func LoadPrompts(dirs ...string) (*Prompts, error) {
+	p := &Prompts{templates: make(map[string]*template.Template)}
+	for name, tmpl := range defaultPrompts.templates {
+		p.templates[name] = tmpl
+	}
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			if _, err := os.Stat(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		for _, path := range paths {
+			name := filepath.Base(path)
+			if _, ok := defaultPrompts.templates[name]; !ok {
+				return nil, fmt.Errorf("unknown prompt template %s", path)
+			}
+			tmpl, err := template.ParseFiles(path)
+			if err != nil {
+				return nil, err
+			}
+			p.templates[name] = tmpl
+		}
+	}
+
+	err := p.validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// validate renders every template with an empty request, so that errors are found before any requests are made.
+func (p *Prompts) validate() error {
+	sampleFile := File{Path: "main.go"}
+	sampleRefinement := CodeChangeRequest{Prompts: p, Files: []File{sampleFile}, PreviousDiff: "diff", Feedback: []ThreadComment{{}}}
+	_, err := CodeChangeRequest{Prompts: p, Files: []File{sampleFile}}.getPrompt(false)
+	if err != nil {
+		return err
+	}
+	_, err = CodeChangeRequest{Prompts: p, Files: []File{sampleFile}}.getPrompt(true)
+	if err != nil {
+		return err
+	}
+	_, err = sampleRefinement.getPrompt(false)
+	if err != nil {
+		return err
+	}
+	_, err = sampleRefinement.getPrompt(true)
+	if err != nil {
+		return err
+	}
+	_, err = DiffCommentRequest{Prompts: p, File: sampleFile, ContextFiles: []File{sampleFile}}.getPrompt(false)
+	if err != nil {
+		return err
+	}
+	_, err = DiffCommentRequest{Prompts: p, File: sampleFile, ContextFiles: []File{sampleFile}}.getPrompt(true)
+	if err != nil {
+		return err
+	}
+	_, err = IssueCommentRequest{Prompts: p}.getPrompt(false)
+	if err != nil {
+		return err
+	}
+	_, err = IssueCommentRequest{Prompts: p}.getPrompt(true)
+	if err != nil {
+		return err
+	}
+	sampleComment := ReviewComment{Number: 1, FilePath: sampleFile.Path, Thread: []ThreadComment{{}}}
+	_, err = ReviewRequest{Prompts: p, Files: []File{sampleFile}, Comments: []ReviewComment{sampleComment}}.getPrompt(false)
+	if err != nil {
+		return err
+	}
+	_, err = ReviewRequest{Prompts: p, Files: []File{sampleFile}, Comments: []ReviewComment{sampleComment}}.getPrompt(true)
+	if err != nil {
+		return err
+	}
+	_, err = p.RenderPullRequestBody(PullRequestBody{
+		Changes:      []FileChange{{}},
+		ContextFiles: []string{sampleFile.Path},
+		OmittedFiles: []string{sampleFile.Path},
+		Verification: "ok",
+	})
+	return err
+}
+
+// render executes the template with the provided name. If p is nil, the default templates are used.
+func (p *Prompts) render(name string, data interface{}) (string, error) {
+	if p == nil {
+		p = defaultPrompts
+	}
+	tmpl, ok := p.templates[name]
+	if !ok {
+		return "", fmt.Errorf("prompt template %s not found", name)
+	}
+
+	var result bytes.Buffer
+	err := tmpl.Execute(&result, data)
+	if err != nil {
+		return "", fmt.Errorf("rendering %s: %w", name, err)
+	}
+
+	return result.String(), nil
+}
@@ -0,0 +1,146 @@
+package llm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mobyvb/pull-pal/llm"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPrompts(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		// files are written to the override directory
+		files  map[string]string
+		prompt string
+		fails  bool
+	}{
+		{
+			"no overrides",
+			nil,
+			"",
+			false,
+		},
+		{
+			"override code change template",
+			map[string]string{"code-change-request.tmpl": "custom: {{ .Subject }}"},
+			"custom: add a file",
+			false,
+		},
+		{
+			"unknown template",
+			map[string]string{"code-change.tmpl": "custom"},
+			"",
+			true,
+		},
+		{
+			"template does not parse",
+			map[string]string{"code-change-request.tmpl": "{{ .Subject "},
+			"",
+			true,
+		},
+		{
+			"template references missing field",
+			map[string]string{"comment-diff-request.tmpl": "{{ .Missing }}"},
+			"",
+			true,
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		dir := t.TempDir()
+		for name, contents := range tt.files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+		}
+
+		prompts, err := llm.LoadPrompts(filepath.Join(dir, "nonexistent"), dir)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		req := llm.CodeChangeRequest{
+			Prompts: prompts,
+			Subject: "add a file",
+		}
+		prompt, err := req.GetPrompt()
+		require.NoError(t, err)
+		if tt.prompt != "" {
+			require.Equal(t, tt.prompt, prompt)
+		} else {
+			require.Contains(t, prompt, "Subject: add a file")
+		}
+	}
+}
+
+func TestRenderPullRequestBody(t *testing.T) {
+	req := llm.CodeChangeRequest{
+		Subject:     "fix the typo",
+		Body:        "the readme says helo, also see fixes #3",
+		IssueNumber: 12,
+		Files: []llm.File{
+			{Path: "README.md", Contents: "helo"},
+			{Path: "main.go", Contents: "package main"},
+			{Path: "big.txt", Contents: "lots of text"},
+		},
+	}
+	res := llm.CodeChangeResponse{
+		Files:        []llm.File{{Path: "README.md", Contents: "hello"}, {Path: "big.txt", Contents: "discarded"}},
+		Summary:      "Fixed the typo in the readme.",
+		Notes:        "There were no other typos.",
+		Changes:      []llm.FileChange{{Path: "README.md", Rationale: "It contained the typo."}},
+		Model:        "gpt-4",
+		OmittedFiles: []string{"big.txt"},
+	}
+
+	data := llm.NewPullRequestBody(req, res)
+	require.Equal(t, []llm.FileChange{{Path: "README.md", Rationale: "It contained the typo."}}, data.Changes)
+	require.Equal(t, []string{"main.go"}, data.ContextFiles)
+	require.Contains(t, data.Prompt, "the readme says helo")
+	require.NotContains(t, data.Prompt, "lots of text")
+
+	data.Usage = llm.UsageSummary{Requests: 2, PromptTokens: 100, CompletionTokens: 50, Cost: 0.5}
+	body, err := llm.DefaultPrompts().RenderPullRequestBody(data)
+	require.NoError(t, err)
+	for _, expected := range []string{
+		"Fixed the typo in the readme.\n\nThere were no other typos.",
+		"- `README.md`: It contained the typo.",
+		"### Files read for context\n\n- `main.go`",
+		"- `big.txt`",
+		"Generated by `gpt-4`. LLM usage for this issue: 2 requests, 100 prompt tokens, 50 completion tokens, $0.50",
+		"<summary>Prompt</summary>",
+	} {
+		require.Contains(t, body, expected)
+	}
+	require.NotContains(t, body, "### Verification")
+	require.True(t, strings.HasSuffix(strings.TrimSpace(body), "</details>\n\nResolves #12"))
+
+	// the issue is still resolved if the template leaves it out
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pull-request-body.tmpl"), []byte("{{ .Summary }}\n"), 0644))
+	prompts, err := llm.LoadPrompts(dir)
+	require.NoError(t, err)
+	body, err = prompts.RenderPullRequestBody(data)
+	require.NoError(t, err)
+	require.Equal(t, "Fixed the typo in the readme.\n\nResolves #12\n", body)
+}
+
+func TestNewPullRequestBodyShortensPrompt(t *testing.T) {
+	// each "é" is two bytes, so an odd length limit would split one if the prompt was cut at a byte offset
+	req := llm.CodeChangeRequest{
+		Subject: "accents",
+		Body:    strings.Repeat("é", 20000),
+	}
+
+	data := llm.NewPullRequestBody(req, llm.CodeChangeResponse{})
+	require.True(t, utf8.ValidString(data.Prompt))
+	require.True(t, strings.HasSuffix(data.Prompt, "é\n[prompt shortened]"))
+	require.LessOrEqual(t, len(data.Prompt), 30000+len("\n[prompt shortened]"))
+}
@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPromptLength is the maximum number of bytes of the prompt included in a pull request body. Github limits
+// the length of pull request bodies, and prompts containing large files can easily exceed it.
+const maxPromptLength = 30000
+
+// PullRequestBody contains the data used to render the body of a pull request resolving an issue.
+type PullRequestBody struct {
+	IssueNumber int
+	// Summary is a short description of the changes.
+	Summary string
+	// Notes contains additional context about the changes.
+	Notes string
+	// Changes contains every changed file, with the reason it was changed if the model provided one.
+	Changes []FileChange
+	// ContextFiles contains the paths of files that were included in the prompt, but not changed.
+	ContextFiles []string
+	// OmittedFiles contains the paths of files that were removed from the prompt to fit the model's context window.
+	OmittedFiles []string
+	// Verification contains the results of verifying the changes, if they were verified.
+	Verification string
+	// Model is the model that generated the changes.
+	Model string
+	// Usage contains the total LLM usage for the issue.
+	Usage UsageSummary
+	// Prompt is the prompt the changes were generated from, shortened if it is too long to include.
+	Prompt string
+}
+
+// UsageSummary contains LLM usage totals.
+type UsageSummary struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is in US dollars.
+	Cost float64
+}
+
+// NewPullRequestBody returns the data for the body of a pull request made from the response to req.
+// Files omitted from the prompt are not listed as changed, since changes to them are discarded.
+func NewPullRequestBody(req CodeChangeRequest, res CodeChangeResponse) PullRequestBody {
+	omitted := make(map[string]bool)
+	for _, path := range res.OmittedFiles {
+		omitted[path] = true
+	}
+	rationales := make(map[string]string)
+	for _, c := range res.Changes {
+		rationales[c.Path] = c.Rationale
+	}
+
+	body := PullRequestBody{
+		IssueNumber:  req.IssueNumber,
+		Summary:      res.Summary,
+		Notes:        res.Notes,
+		OmittedFiles: res.OmittedFiles,
+		Model:        res.Model,
+	}
+	changed := make(map[string]bool)
+	for _, f := range res.Files {
+		if omitted[f.Path] || changed[f.Path] {
+			continue
+		}
+		changed[f.Path] = true
+		body.Changes = append(body.Changes, FileChange{Path: f.Path, Rationale: rationales[f.Path]})
+	}
+
+	// the prompt only contained the files that were not omitted
+	prompted := req
+	prompted.Files = []File{}
+	for _, f := range req.Files {
+		if omitted[f.Path] {
+			continue
+		}
+		prompted.Files = append(prompted.Files, f)
+		if !changed[f.Path] {
+			body.ContextFiles = append(body.ContextFiles, f.Path)
+		}
+	}
+	prompted.OmittedFiles = res.OmittedFiles
+	prompt, err := prompted.GetPrompt()
+	if err != nil {
+		prompt = "invalid prompt: " + err.Error()
+	}
+	if len(prompt) > maxPromptLength {
+		// cut at the start of a character, so that a multi-byte character is not split
+		end := maxPromptLength
+		for end > 0 && !utf8.RuneStart(prompt[end]) {
+			end--
+		}
+		prompt = prompt[:end] + "\n[prompt shortened]"
+	}
+	body.Prompt = prompt
+
+	return body
+}
+
+// RenderPullRequestBody renders the body of a pull request resolving an issue. If p is nil, the default template is used.
+// The body always ends with "Resolves #" and the issue number, which is added here rather than in the template, so
+// that pull pal can find the issue a pull request resolves even if the template is overridden.
+func (p *Prompts) RenderPullRequestBody(body PullRequestBody) (string, error) {
+	rendered, err := p.render(pullRequestBodyTemplate, body)
+	if err != nil || body.IssueNumber == 0 {
+		return rendered, err
+	}
+	return fmt.Sprintf("%s\n\nResolves #%d\n", strings.TrimRight(rendered, "\n"), body.IssueNumber), nil
+}
@@ -0,0 +1,50 @@
+package llm
+
+import "fmt"
+
+func (req ReviewRequest) String() string {
+	prompt, err := req.GetPrompt()
+	if err != nil {
+		return fmt.Sprintf("invalid prompt: %s", err)
+	}
+	return prompt
+}
+
+// GetPrompt converts the information in the request to a prompt for an LLM.
+// The prompt asks for a response in YAML format.
+func (req ReviewRequest) GetPrompt() (string, error) {
+	return req.getPrompt(false)
+}
+
+// getPrompt converts the information in the request to a prompt for an LLM. If structured is true, the prompt does not
+// describe the response format, since it is provided to the LLM as a schema.
+func (req ReviewRequest) getPrompt(structured bool) (string, error) {
+	data := struct {
+		ReviewRequest
+		Structured bool
+	}{req, structured}
+
+	return req.Prompts.render(reviewTemplate, data)
+}
+
+// String is a string representation of ReviewResponse.
+func (res ReviewResponse) String() string {
+	out := "Responses:\n"
+	for _, r := range res.Responses {
+		out += fmt.Sprintf("%d: %s\n", r.Comment, r.Response)
+	}
+	out += "\nFiles:\n"
+	for _, f := range res.Files {
+		out += f.Path + ":\n```\n"
+		out += f.Contents + "\n```\n"
+	}
+
+	return out
+}
+
+// ParseReviewResponse parses the LLM's response to ReviewRequest (string) into a ReviewResponse.
+func ParseReviewResponse(llmResponse string) (ReviewResponse, error) {
+	var response ReviewResponse
+	err := parseYAML(llmResponse, &response)
+	return response, err
+}
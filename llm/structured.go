@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// OutputFormat defines how a model is asked to structure its response.
+type OutputFormat string
+
+const (
+	// OutputAuto uses OutputTools for the OpenAI API, and OutputYAML for other APIs.
+	OutputAuto OutputFormat = ""
+	// OutputYAML describes a YAML template in the prompt. This works with any model, but the response may need repairing.
+	OutputYAML OutputFormat = "yaml"
+	// OutputTools asks the model to respond by calling a function whose parameters are the response.
+	OutputTools OutputFormat = "tools"
+	// OutputJSONSchema asks the model to respond with JSON matching a schema.
+	OutputJSONSchema OutputFormat = "json-schema"
+)
+
+// outputFormat returns the output format to use for the model.
+func (m Model) outputFormat() OutputFormat {
+	if m.Output != OutputAuto {
+		return m.Output
+	}
+	if m.BaseURL == "" {
+		return OutputTools
+	}
+	return OutputYAML
+}
+
+// responseSchema describes a structured response that can be requested from a model.
+type responseSchema struct {
+	name        string
+	description string
+	schema      jsonschema.Definition
+}
+
+var fileSchema = jsonschema.Definition{
+	Type: jsonschema.Object,
+	Properties: map[string]jsonschema.Definition{
+		"path": {
+			Type:        jsonschema.String,
+			Description: "the path of the file, relative to the root of the repository",
+		},
+		"contents": {
+			Type:        jsonschema.String,
+			Description: "the full contents of the file",
+		},
+	},
+	Required:             []string{"path", "contents"},
+	AdditionalProperties: false,
+}
+
+var codeChangeSchema = responseSchema{
+	name:        "submit_code_change",
+	description: "Submit the files that were modified or added to accomplish the task.",
+	schema: jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"files": {
+				Type:        jsonschema.Array,
+				Description: "every file that was modified or added",
+				Items:       &fileSchema,
+			},
+			"notes": {
+				Type:        jsonschema.String,
+				Description: "additional context about the changes",
+			},
+			"summary": {
+				Type:        jsonschema.String,
+				Description: "a short summary of the changes, for the pull request description",
+			},
+			"changes": {
+				Type:        jsonschema.Array,
+				Description: "why each modified or added file was changed",
+				Items: &jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"path": {
+							Type:        jsonschema.String,
+							Description: "the path of the file, relative to the root of the repository",
+						},
+						"rationale": {
+							Type:        jsonschema.String,
+							Description: "a single sentence explaining why the file was changed",
+						},
+					},
+					Required:             []string{"path", "rationale"},
+					AdditionalProperties: false,
+				},
+			},
+		},
+		Required:             []string{"files", "notes", "summary", "changes"},
+		AdditionalProperties: false,
+	},
+}
+
+var diffCommentSchema = responseSchema{
+	name:        "respond_to_comment",
+	description: "Respond to a comment, either with an answer or with a modified file.",
+	schema: jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"responseType": {
+				Type:        jsonschema.Integer,
+				Description: "0 if the comment is a question, 1 if the comment is a request for changes",
+			},
+			"response": {
+				Type:        jsonschema.String,
+				Description: "the answer to the question, or additional context about the changes",
+			},
+			"files": {
+				Type:        jsonschema.Array,
+				Description: "every file that was modified, if the comment is a request for changes",
+				Items:       &fileSchema,
+			},
+		},
+		Required:             []string{"responseType", "response", "files"},
+		AdditionalProperties: false,
+	},
+}
+
+var issueCommentSchema = responseSchema{
+	name:        "respond_to_comment",
+	description: "Respond to the latest comment in the conversation.",
+	schema: jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"response": {
+				Type:        jsonschema.String,
+				Description: "your reply to the comment",
+			},
+			"changeRequested": {
+				Type:        jsonschema.Boolean,
+				Description: "true if the comment asks for changes to the code of the pull request",
+			},
+		},
+		Required:             []string{"response", "changeRequested"},
+		AdditionalProperties: false,
+	},
+}
+
+var reviewSchema = responseSchema{
+	name:        "address_review",
+	description: "Submit the files that were modified to address a review, and a response to every comment in the review.",
+	schema: jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"files": {
+				Type:        jsonschema.Array,
+				Description: "every file that was modified",
+				Items:       &fileSchema,
+			},
+			"responses": {
+				Type:        jsonschema.Array,
+				Description: "a response to every comment in the review",
+				Items: &jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"comment": {
+							Type:        jsonschema.Integer,
+							Description: "the number of the comment",
+						},
+						"response": {
+							Type:        jsonschema.String,
+							Description: "the answer to a question, or additional context about the changes made for a request",
+						},
+					},
+					Required:             []string{"comment", "response"},
+					AdditionalProperties: false,
+				},
+			},
+		},
+		Required:             []string{"files", "responses"},
+		AdditionalProperties: false,
+	},
+}
+
+// parseJSONResponse parses structured output from a model into out.
+// Models that do not support strict schemas may still wrap JSON in prose or code fences, so these are removed first.
+func parseJSONResponse(llmResponse string, out interface{}) error {
+	err := json.Unmarshal([]byte(llmResponse), out)
+	if err == nil {
+		return nil
+	}
+
+	start := -1
+	for i, c := range llmResponse {
+		if c == '{' {
+			start = i
+			break
+		}
+	}
+	end := -1
+	for i := len(llmResponse) - 1; i >= 0; i-- {
+		if llmResponse[i] == '}' {
+			end = i
+			break
+		}
+	}
+	if start == -1 || end < start || json.Unmarshal([]byte(llmResponse[start:end+1]), out) != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONResponse(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		response string
+		expected IssueCommentResponse
+		fails    bool
+	}{
+		{"plain JSON", `{"response": "done"}`, IssueCommentResponse{Response: "done"}, false},
+		{"code fence", "```json\n{\"response\": \"done\"}\n```", IssueCommentResponse{Response: "done"}, false},
+		{"surrounding prose", "Here you go:\n{\"response\": \"a {nested} brace\"}\nLet me know!", IssueCommentResponse{Response: "a {nested} brace"}, false},
+		{"no JSON", "I could not do that.", IssueCommentResponse{}, true},
+		{"invalid JSON", `{"response": "done"`, IssueCommentResponse{}, true},
+		{"wrong type", `{"response": 1}`, IssueCommentResponse{}, true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		var res IssueCommentResponse
+		err := parseJSONResponse(tt.response, &res)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, res)
+	}
+}
+
+func TestIsStructuredOutputUnsupported(t *testing.T) {
+	var testCases = []struct {
+		testcase    string
+		err         error
+		unsupported bool
+	}{
+		{"tools not supported", &openai.APIError{HTTPStatusCode: 400, Message: "model does not support tools"}, true},
+		{"unknown response format", &openai.APIError{HTTPStatusCode: 422, Message: "Extra inputs are not permitted: response_format"}, true},
+		{"json schema not supported", &openai.APIError{HTTPStatusCode: 400, Message: "Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model."}, true},
+		{"context length exceeded", &openai.APIError{HTTPStatusCode: 400, Message: "This model's maximum context length is 8192 tokens."}, false},
+		{"invalid message", &openai.APIError{HTTPStatusCode: 400, Message: "Invalid value for 'content': expected a string."}, false},
+		{"tool message rejected for another reason", &openai.APIError{HTTPStatusCode: 400, Message: "messages with role 'tool' must be a response to a preceding message with 'tool_calls'"}, false},
+		{"server error mentioning tools", &openai.APIError{HTTPStatusCode: 500, Message: "tools are not supported right now"}, false},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		require.Equal(t, tt.unsupported, isStructuredOutputUnsupported(tt.err))
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	var testCases = []struct {
+		testcase  string
+		output    OutputFormat
+		responses []fakeResponse
+		// formats contains the format each request to the first model was made with
+		formats []OutputFormat
+		model   string
+	}{
+		{"tools", OutputTools, []fakeResponse{toolReply(issueCommentSchema.name, `{"response": "done"}`)}, []OutputFormat{OutputTools}, "first"},
+		{"json schema", OutputJSONSchema, []fakeResponse{reply("```json\n{\"response\": \"done\"}\n```")}, []OutputFormat{OutputJSONSchema}, "first"},
+		{
+			"tools repaired",
+			OutputTools,
+			[]fakeResponse{toolReply(issueCommentSchema.name, `{"response": 1}`), toolReply(issueCommentSchema.name, `{"response": "done"}`)},
+			[]OutputFormat{OutputTools, OutputTools},
+			"first",
+		},
+		{
+			"tools unsupported",
+			OutputTools,
+			[]fakeResponse{failure(400, "this model does not support tools"), reply("response: done")},
+			[]OutputFormat{OutputTools, OutputYAML},
+			"first",
+		},
+		{
+			"json schema unsupported",
+			OutputJSONSchema,
+			[]fakeResponse{failure(422, "unrecognized parameter: response_format"), reply("response: done")},
+			[]OutputFormat{OutputJSONSchema, OutputYAML},
+			"first",
+		},
+		{
+			"prompt too long",
+			OutputTools,
+			[]fakeResponse{failure(400, "This model's maximum context length is 8192 tokens."), reply("response: done")},
+			[]OutputFormat{OutputTools},
+			"second",
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		api := newFakeAPI(t, map[string][]fakeResponse{
+			"first":  tt.responses,
+			"second": {reply("response: done")},
+		})
+		client := newTestClient([]Model{api.model("first", tt.output), api.model("second", OutputYAML)}, 0)
+
+		res, err := client.EvaluateIssueComment(context.Background(), nil, IssueCommentRequest{Prompts: DefaultPrompts(), Number: 1, Contents: "hi"})
+		require.NoError(t, err)
+		require.Equal(t, "done", res.Response)
+		require.Equal(t, tt.model, res.Model)
+
+		var formats []OutputFormat
+		for _, req := range api.requests["first"] {
+			switch {
+			case len(req.Tools) > 0:
+				require.Equal(t, issueCommentSchema.name, req.Tools[0].Function.Name)
+				formats = append(formats, OutputTools)
+			case req.ResponseFormat != nil:
+				require.Equal(t, openai.ChatCompletionResponseFormatTypeJSONSchema, req.ResponseFormat.Type)
+				require.Equal(t, issueCommentSchema.name, req.ResponseFormat.JSONSchema.Name)
+				formats = append(formats, OutputJSONSchema)
+			default:
+				formats = append(formats, OutputYAML)
+			}
+		}
+		require.Equal(t, tt.formats, formats)
+	}
+}
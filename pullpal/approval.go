@@ -0,0 +1,469 @@
+package pullpal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// DefaultApprovalExpiry is how long a proposal waits for approval when no expiry is configured.
+const DefaultApprovalExpiry = 7 * 24 * time.Hour
+
+// maxCommentDiff is the maximum number of characters of a proposed diff included in a comment. Github limits the
+// length of comments to 65536 characters.
+const maxCommentDiff = 60000
+
+// ApprovalSettings configures whether changes for issues must be approved before they are pushed.
+type ApprovalSettings struct {
+	// Required makes pull pal propose changes and wait for "/pullpal approve" before pushing them.
+	Required *bool `mapstructure:"required"`
+	// PatchDir is a directory to save proposed changes in as patches. If empty, the diff is posted on the issue.
+	PatchDir string `mapstructure:"patch-dir"`
+	// Expiry is how long a proposal waits for approval. DefaultApprovalExpiry is used if zero.
+	Expiry time.Duration `mapstructure:"expiry"`
+}
+
+// Merge returns the settings with every setting in override that is set replacing the corresponding setting.
+func (s ApprovalSettings) Merge(override ApprovalSettings) ApprovalSettings {
+	if override.Required != nil {
+		s.Required = override.Required
+	}
+	if override.PatchDir != "" {
+		s.PatchDir = override.PatchDir
+	}
+	if override.Expiry != 0 {
+		s.Expiry = override.Expiry
+	}
+	return s
+}
+
+func (s ApprovalSettings) required() bool {
+	return s.Required != nil && *s.Required
+}
+
+func (s ApprovalSettings) expiry() time.Duration {
+	if s.Expiry == 0 {
+		return DefaultApprovalExpiry
+	}
+	return s.Expiry
+}
+
+// ProposalStatus is the state of a proposal.
+type ProposalStatus string
+
+const (
+	ProposalPending  ProposalStatus = "pending"
+	ProposalApproved ProposalStatus = "approved"
+	ProposalRejected ProposalStatus = "rejected"
+	ProposalExpired  ProposalStatus = "expired"
+	// ProposalReplaced is the status of a proposal that was pending when new changes were proposed for the issue.
+	ProposalReplaced ProposalStatus = "replaced"
+)
+
+// Proposal contains changes generated for an issue that are waiting for, or were waiting for, approval.
+type Proposal struct {
+	Status    ProposalStatus `json:"status"`
+	CreatedAt time.Time      `json:"createdAt"`
+	// ResolvedAt and ResolvedBy record when, and by whom, the proposal was approved or rejected, or when it expired.
+	ResolvedAt time.Time `json:"resolvedAt,omitempty"`
+	ResolvedBy string    `json:"resolvedBy,omitempty"`
+	// PatchPath is the path the proposal was saved to as a patch, if it was saved.
+	PatchPath string `json:"patchPath,omitempty"`
+	// BaseCommit is the commit the changes were made on top of. The changes are only pushed if none of the files they
+	// change have changed since.
+	BaseCommit string `json:"baseCommit,omitempty"`
+	// Branch and PRNumber are set for changes to a pull request that is already open, which are pushed to its branch
+	// with Message as the commit message.
+	Branch   string `json:"branch,omitempty"`
+	PRNumber int    `json:"prNumber,omitempty"`
+	Message  string `json:"message,omitempty"`
+	// Command is set for a command that changes the pull requests PRNumbers, which is carried out when the proposal is
+	// approved instead of pushing changes.
+	Command   CommandName `json:"command,omitempty"`
+	PRNumbers []int       `json:"prNumbers,omitempty"`
+
+	Model        string           `json:"model"`
+	Files        []llm.File       `json:"files"`
+	Notes        string           `json:"notes,omitempty"`
+	Summary      string           `json:"summary,omitempty"`
+	Changes      []llm.FileChange `json:"changes,omitempty"`
+	OmittedFiles []string         `json:"omittedFiles,omitempty"`
+}
+
+// response returns the proposed changes as the response they were generated from.
+func (pr Proposal) response() llm.CodeChangeResponse {
+	return llm.CodeChangeResponse{
+		Files:        pr.Files,
+		Notes:        pr.Notes,
+		Summary:      pr.Summary,
+		Changes:      pr.Changes,
+		Model:        pr.Model,
+		OmittedFiles: pr.OmittedFiles,
+	}
+}
+
+// proposeChange commits a generated change locally without pushing it, records it as a proposal, and shows its diff
+// on the issue (or saves it as a patch) so that it can be approved with "/pullpal approve".
+func (p *pullPalRepo) proposeChange(issue vc.Issue, progress *issueProgress, req llm.CodeChangeRequest, res llm.CodeChangeResponse) error {
+	progress.start("Preparing the changes for approval")
+	base, err := p.localGitClient.HeadCommit()
+	if err != nil {
+		return err
+	}
+	err = p.commitChange(req, res)
+	if err != nil {
+		return err
+	}
+
+	proposal := Proposal{
+		Status:       ProposalPending,
+		CreatedAt:    time.Now().UTC(),
+		BaseCommit:   base,
+		Model:        res.Model,
+		Files:        res.Files,
+		Notes:        res.Notes,
+		Summary:      res.Summary,
+		Changes:      res.Changes,
+		OmittedFiles: res.OmittedFiles,
+	}
+	details, err := p.saveProposal(issue.Number, proposal)
+	if err != nil {
+		return err
+	}
+
+	progress.wait(fmt.Sprintf("✋ I prepared changes for this issue with `%s`. Comment `%s %s` to push them and open a pull request, or `%s %s` to discard them. This proposal expires on %s.", res.Model, CommandPrefix, CommandApprove, CommandPrefix, CommandReject, p.proposalExpiry(proposal)), details)
+	p.log.Info("proposed change", zap.Int("issue", issue.Number), zap.String("patch", proposal.PatchPath))
+	return nil
+}
+
+// publishBranchChange commits the files that were written for a change to the branch of pull request prNumber, and
+// pushes the commit. If changes must be approved, the commit is proposed on issueNumber instead, and a note saying so
+// is returned.
+func (p *pullPalRepo) publishBranchChange(issueNumber, prNumber int, branch, message, model string, files []llm.File) (string, error) {
+	if !p.approval.required() {
+		p.log.Info("about to create commit", zap.String("message", message))
+		err := p.localGitClient.FinishCommit(message)
+		if err != nil {
+			return "", err
+		}
+		return "", p.pushAndVerify(branch)
+	}
+	if issueNumber == 0 {
+		return "", errors.New("changes must be approved, but this pull request does not resolve an issue to approve them on")
+	}
+
+	base, err := p.localGitClient.HeadCommit()
+	if err != nil {
+		return "", err
+	}
+	err = p.localGitClient.FinishCommit(message)
+	if err != nil {
+		return "", err
+	}
+
+	proposal := Proposal{
+		Status:     ProposalPending,
+		CreatedAt:  time.Now().UTC(),
+		BaseCommit: base,
+		Branch:     branch,
+		PRNumber:   prNumber,
+		Message:    message,
+		Model:      model,
+		Files:      files,
+	}
+	details, err := p.saveProposal(issueNumber, proposal)
+	if err != nil {
+		return "", err
+	}
+	err = p.ghClient.CommentOnIssue(issueNumber, fmt.Sprintf("✋ I prepared changes for #%d with `%s`. Comment `%s %s` here to push them, or `%s %s` to discard them. This proposal expires on %s.\n\n%s", prNumber, model, CommandPrefix, CommandApprove, CommandPrefix, CommandReject, p.proposalExpiry(proposal), details))
+	if err != nil {
+		return "", err
+	}
+	p.log.Info("proposed change", zap.Int("issue", issueNumber), zap.Int("pr", prNumber), zap.String("patch", proposal.PatchPath))
+	return fmt.Sprintf("The changes are waiting for approval on #%d.", issueNumber), nil
+}
+
+// saveProposal records the change committed locally as the pending proposal of an issue, replacing any proposal that
+// was pending, and saves its diff as a patch if configured. A description of the changes is returned, which includes
+// the diff if it was not saved as a patch.
+func (p *pullPalRepo) saveProposal(issueNumber int, proposal Proposal) (string, error) {
+	diff, err := p.localGitClient.HeadPatch()
+	if err != nil {
+		return "", err
+	}
+
+	details := ""
+	if p.approval.PatchDir != "" {
+		name := fmt.Sprintf("%s-issue-%d-%d.patch", strings.ReplaceAll(p.fullName, "/", "-"), issueNumber, proposal.CreatedAt.Unix())
+		proposal.PatchPath = filepath.Join(p.approval.PatchDir, name)
+		err = os.MkdirAll(p.approval.PatchDir, 0755)
+		if err != nil {
+			return "", err
+		}
+		err = os.WriteFile(proposal.PatchPath, []byte(diff), 0644)
+		if err != nil {
+			return "", err
+		}
+		details = fmt.Sprintf("The proposed changes were saved as `%s`.", name)
+	} else {
+		if len(diff) > maxCommentDiff {
+			diff = diff[:maxCommentDiff] + "\n[diff shortened]"
+		}
+		details = fmt.Sprintf("<details>\n<summary>Proposed changes</summary>\n\n```diff\n%s\n```\n</details>", diff)
+	}
+	if proposal.Summary != "" {
+		details = proposal.Summary + "\n\n" + details
+	}
+
+	return details, p.addProposal(issueNumber, proposal)
+}
+
+// addProposal records proposal as the pending proposal of an issue, replacing any proposal that was pending.
+func (p *pullPalRepo) addProposal(issueNumber int, proposal Proposal) error {
+	return p.state.UpdateIssue(p.fullName, issueNumber, func(s *IssueState) {
+		for i := range s.Proposals {
+			if s.Proposals[i].Status == ProposalPending {
+				s.Proposals[i].Status = ProposalReplaced
+				s.Proposals[i].ResolvedAt = proposal.CreatedAt
+			}
+		}
+		s.Proposals = append(s.Proposals, proposal)
+	})
+}
+
+// proposeCommand records a command that changes the pull requests prNumbers as the pending proposal of an issue, so that it is only carried
+// out once it is approved with "/pullpal approve".
+func (p *pullPalRepo) proposeCommand(name CommandName, issueNumber int, prNumbers []int) (string, error) {
+	if issueNumber == 0 {
+		return "", errors.New("changes must be approved, but this pull request does not resolve an issue to approve them on")
+	}
+	proposal := Proposal{
+		Status:    ProposalPending,
+		CreatedAt: time.Now().UTC(),
+		Command:   name,
+		PRNumbers: prNumbers,
+	}
+	refs := []string{}
+	for _, number := range prNumbers {
+		refs = append(refs, fmt.Sprintf("#%d", number))
+	}
+	err := p.addProposal(issueNumber, proposal)
+	if err != nil {
+		return "", err
+	}
+	p.log.Info("proposed command", zap.Int("issue", issueNumber), zap.String("command", string(name)))
+	return fmt.Sprintf("✋ Comment `%s %s` on #%d to %s %s, or `%s %s` to discard this. This proposal expires on %s.", CommandPrefix, CommandApprove, issueNumber, commandAction(name), strings.Join(refs, ", "), CommandPrefix, CommandReject, p.proposalExpiry(proposal)), nil
+}
+
+// proposalExpiry returns when a proposal expires, formatted for comments.
+func (p *pullPalRepo) proposalExpiry(proposal Proposal) string {
+	return proposal.CreatedAt.Add(p.approval.expiry()).Format("January 2, 2006 15:04 MST")
+}
+
+// approveProposal pushes the changes waiting for approval on an issue, and opens a pull request for them.
+func (p *pullPalRepo) approveProposal(comment vc.Comment, issueNumber int) (string, error) {
+	i := p.state.Issue(p.fullName, issueNumber).pendingProposal()
+	if i < 0 {
+		return "", errors.New("there are no changes waiting for approval")
+	}
+	proposal := p.state.Issue(p.fullName, issueNumber).Proposals[i]
+	if time.Since(proposal.CreatedAt) > p.approval.expiry() {
+		err := p.resolveProposal(issueNumber, i, ProposalExpired, "")
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("the proposed changes expired; comment `%s %s` to generate new changes", CommandPrefix, CommandRetry)
+	}
+
+	if proposal.Command != "" {
+		result, err := p.applyCommand(proposal.Command, proposal.PRNumbers)
+		if err != nil {
+			return "", err
+		}
+		err = p.resolveProposal(issueNumber, i, ProposalApproved, comment.Author.Handle)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	}
+
+	if proposal.Branch != "" {
+		err := p.publishBranchProposal(proposal)
+		if err != nil {
+			return "", err
+		}
+		err = p.resolveProposal(issueNumber, i, ProposalApproved, comment.Author.Handle)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("I pushed the approved changes to #%d.", proposal.PRNumber), nil
+	}
+
+	issue, err := p.ghClient.GetIssue(issueNumber)
+	if err != nil {
+		return "", err
+	}
+	progress := p.startProgress(issue)
+	err = p.publishProposal(issue, progress, proposal)
+	if err != nil {
+		progress.fail(err)
+		return "", err
+	}
+
+	err = p.resolveProposal(issueNumber, i, ProposalApproved, comment.Author.Handle)
+	if err != nil {
+		return "", err
+	}
+	return "I pushed the approved changes.", nil
+}
+
+// publishProposal checks out the base branch of issue, and publishes the proposed changes on top of it.
+func (p *pullPalRepo) publishProposal(issue vc.Issue, progress *issueProgress, proposal Proposal) error {
+	progress.start("Reading the issue")
+	req, err := p.localGitClient.ParseIssueAndStartCommit(issue)
+	if err != nil {
+		return err
+	}
+	defer p.localGitClient.AbortCommit()
+	err = p.checkProposalApplies(proposal)
+	if err != nil {
+		return err
+	}
+	err = p.loadPrompts()
+	if err != nil {
+		return err
+	}
+	req.Prompts = p.prompts
+	req.Files, err = p.appendLocalFiles(req.Files, p.state.Issue(p.fullName, issue.Number).Files)
+	if err != nil {
+		return err
+	}
+	return p.publishChange(issue, progress, req, proposal.response())
+}
+
+// publishBranchProposal checks out the branch of the pull request the proposed changes were made for, and pushes the
+// changes to it.
+func (p *pullPalRepo) publishBranchProposal(proposal Proposal) error {
+	err := p.startBranchCommit(proposal.Branch)
+	if err != nil {
+		return err
+	}
+	defer p.localGitClient.AbortCommit()
+	err = p.checkProposalApplies(proposal)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range proposal.Files {
+		err = p.localGitClient.ReplaceOrAddLocalFile(f)
+		if err != nil {
+			return err
+		}
+	}
+	err = p.localGitClient.FinishCommit(proposal.Message)
+	if err != nil {
+		return err
+	}
+	return p.pushAndVerify(proposal.Branch)
+}
+
+// checkProposalApplies returns an error if any of the files changed by a proposal have changed since the commit the
+// proposal was based on, compared to the commit that is checked out. Since proposals contain the full contents of the
+// files they change, pushing the proposal would undo those changes.
+func (p *pullPalRepo) checkProposalApplies(proposal Proposal) error {
+	regenerate := fmt.Sprintf("comment `%s %s` to generate new changes", CommandPrefix, CommandRetry)
+	if proposal.Branch != "" {
+		regenerate = fmt.Sprintf("comment on #%d again to generate new changes", proposal.PRNumber)
+	}
+	if proposal.BaseCommit == "" {
+		return fmt.Errorf("the proposed changes do not record the commit they were made on; %s", regenerate)
+	}
+	for _, f := range proposal.Files {
+		base, err := p.localGitClient.FileAtCommit(proposal.BaseCommit, f.Path)
+		if err != nil {
+			return err
+		}
+		current, err := p.localGitClient.GetLocalFile(f.Path)
+		if err != nil {
+			return err
+		}
+		if base.Contents != current.Contents {
+			return fmt.Errorf("%s changed since the changes were proposed; %s", f.Path, regenerate)
+		}
+	}
+	return nil
+}
+
+// rejectProposal discards the changes waiting for approval on an issue.
+func (p *pullPalRepo) rejectProposal(comment vc.Comment, issueNumber int) (string, error) {
+	rejected, err := p.rejectPendingProposal(issueNumber, comment.Author.Handle)
+	if err != nil {
+		return "", err
+	}
+	if !rejected {
+		return "", errors.New("there are no changes waiting for approval")
+	}
+	return fmt.Sprintf("I discarded the proposed changes. Comment `%s %s` to generate new changes.", CommandPrefix, CommandRetry), nil
+}
+
+// rejectPendingProposal rejects the proposal waiting for approval on an issue, and returns true if there was one.
+func (p *pullPalRepo) rejectPendingProposal(issueNumber int, by string) (bool, error) {
+	i := p.state.Issue(p.fullName, issueNumber).pendingProposal()
+	if i < 0 {
+		return false, nil
+	}
+	err := p.resolveProposal(issueNumber, i, ProposalRejected, by)
+	if err != nil {
+		return false, err
+	}
+	p.removeInProgressLabel(issueNumber)
+	return true, nil
+}
+
+// expireProposals marks proposals that have waited for approval for too long as expired, and says so on their issues.
+func (p *pullPalRepo) expireProposals() error {
+	for number, state := range p.state.Issues(p.fullName) {
+		i := state.pendingProposal()
+		if i < 0 || time.Since(state.Proposals[i].CreatedAt) <= p.approval.expiry() {
+			continue
+		}
+		err := p.resolveProposal(number, i, ProposalExpired, "")
+		if err != nil {
+			return err
+		}
+		p.removeInProgressLabel(number)
+		p.log.Info("proposal expired", zap.Int("issue", number))
+		err = p.ghClient.CommentOnIssue(number, fmt.Sprintf("The proposed changes expired without being approved. Comment `%s %s` to generate new changes.", CommandPrefix, CommandRetry))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveProposal records that the proposal at index i of an issue's proposals was approved, rejected, or expired.
+func (p *pullPalRepo) resolveProposal(issueNumber, i int, status ProposalStatus, by string) error {
+	return p.state.UpdateIssue(p.fullName, issueNumber, func(s *IssueState) {
+		s.Proposals[i].Status = status
+		s.Proposals[i].ResolvedAt = time.Now().UTC()
+		s.Proposals[i].ResolvedBy = by
+	})
+}
+
+func (p *pullPalRepo) removeInProgressLabel(issueNumber int) {
+	if p.lifecycleLabels.InProgress == "" {
+		return
+	}
+	err := p.ghClient.RemoveLabelFromIssue(issueNumber, p.lifecycleLabels.InProgress)
+	if err != nil {
+		p.log.Error("error removing label", zap.Int("issue", issueNumber), zap.Error(err))
+	}
+}
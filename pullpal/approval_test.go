@@ -0,0 +1,288 @@
+package pullpal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+// proposedChange is a code change response adding B to a.go.
+const proposedChange = "files:\n  -\n    path: a.go\n    contents: |\n      package a\n\n      var B = 1\nnotes: Added B.\nsummary: Add B.\n"
+
+// newApprovalRepo returns a repository that requires approval, and a local clone in which main contains a.go. Issue 1
+// asks for a change to a.go, and the model responds to every request with responses, in order.
+func newApprovalRepo(t *testing.T, responses ...string) (*pullPalRepo, *fakeGithubClient, *testGitRepo) {
+	gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+	gh := newFakeGithubClient()
+	gh.issues[1] = vc.Issue{Number: 1, Subject: "Add B", Body: "Add B to a.go.\n---\nfiles: a.go", Author: vc.Author{Handle: "someone"}}
+	_, m := newFakeModel(t, responses...)
+	p := newTestRepo(t, gh)
+	p.localGitClient = gitRepo.client()
+	useModel(p, m)
+	required := true
+	p.approval = ApprovalSettings{Required: &required}
+	return p, gh, gitRepo
+}
+
+// command returns a comment from "someone" on issue 1 with body.
+func command(body string) vc.Comment {
+	return vc.Comment{ID: 1000, Type: vc.CommentIssue, Author: vc.Author{Handle: "someone"}, Body: body, IssueNumber: 1}
+}
+
+func TestApproval(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		// act is done after changes are proposed for issue 1, and returns the error of the last command, if any
+		act func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error
+		// statuses are the statuses of the proposals of issue 1 afterwards
+		statuses []ProposalStatus
+		// contents is the contents of a.go on the pull request branch, or empty if no pull request was opened
+		contents string
+		err      string
+	}{
+		{
+			"proposed",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error { return nil },
+			[]ProposalStatus{ProposalPending},
+			"",
+			"",
+		},
+		{
+			"approved",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalApproved},
+			"package a\n\nvar B = 1\n",
+			"",
+		},
+		{
+			"rejected",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				err := p.handleCommands(command("/pullpal reject"))
+				if err != nil {
+					return err
+				}
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalRejected},
+			"",
+			"there are no changes waiting for approval",
+		},
+		{
+			"expired",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				p.approval.Expiry = time.Nanosecond
+				require.NoError(t, p.expireProposals())
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalExpired},
+			"",
+			"there are no changes waiting for approval",
+		},
+		{
+			"expired when approved",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				p.approval.Expiry = time.Nanosecond
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalExpired},
+			"",
+			"the proposed changes expired",
+		},
+		{
+			"replaced",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				return p.handleCommands(command("/pullpal retry"))
+			},
+			[]ProposalStatus{ProposalReplaced, ProposalPending},
+			"",
+			"",
+		},
+		{
+			"approved after the proposed file changed",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				gitRepo.commitOn("main", map[string]string{"a.go": "package a\n\nvar A = 1\n"})
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalPending},
+			"",
+			"a.go changed since the changes were proposed",
+		},
+		{
+			"approved after another file changed",
+			func(t *testing.T, p *pullPalRepo, gitRepo *testGitRepo) error {
+				gitRepo.commitOn("main", map[string]string{"c.go": "package c\n"})
+				return p.handleCommands(command("/pullpal approve"))
+			},
+			[]ProposalStatus{ProposalApproved},
+			"package a\n\nvar B = 1\n",
+			"",
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		p, gh, gitRepo := newApprovalRepo(t, proposedChange, proposedChange)
+		base, err := gitRepo.repo.Reference("refs/heads/main", true)
+		require.NoError(t, err)
+
+		require.NoError(t, p.handleIssue(gh.issues[1]))
+		require.Empty(t, gh.opened)
+		require.Contains(t, gh.lastComment(1), "+var B = 1")
+		proposals := p.state.Issue(p.fullName, 1).Proposals
+		require.Len(t, proposals, 1)
+		require.Equal(t, base.Hash().String(), proposals[0].BaseCommit)
+
+		err = tt.act(t, p, gitRepo)
+		if tt.err != "" {
+			require.ErrorContains(t, err, tt.err)
+		} else {
+			require.NoError(t, err)
+		}
+
+		statuses := []ProposalStatus{}
+		for _, proposal := range p.state.Issue(p.fullName, 1).Proposals {
+			statuses = append(statuses, proposal.Status)
+		}
+		require.Equal(t, tt.statuses, statuses)
+		if tt.contents == "" {
+			require.Empty(t, gh.opened)
+			continue
+		}
+		require.Len(t, gh.opened, 1)
+		contents, _ := gitRepo.branchFile(gh.opened[0].Branch, "a.go")
+		require.Equal(t, tt.contents, contents)
+		// the approved changes are pushed on top of the latest commit of the base branch
+		if c, ok := gitRepo.branchFile("main", "c.go"); ok {
+			pushed, _ := gitRepo.branchFile(gh.opened[0].Branch, "c.go")
+			require.Equal(t, c, pushed)
+		}
+		require.Equal(t, "someone", p.state.Issue(p.fullName, 1).Proposals[0].ResolvedBy)
+	}
+}
+
+func TestApprovalOfPullRequestChanges(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		// prBody is the body of the pull request the review comment is left on
+		prBody string
+		// change is a commit made to the pull request branch after the changes are proposed
+		change   map[string]string
+		contents string
+		err      string
+	}{
+		{"approved", "Resolves #1", nil, "package a\n\nvar B = 1\n", ""},
+		{"approved after another file changed", "Resolves #1", map[string]string{"c.go": "package c\n"}, "package a\n\nvar B = 1\n", ""},
+		{"approved after the proposed file changed", "Resolves #1", map[string]string{"a.go": "package a\n\nvar C = 1\n"}, "package a\n\nvar C = 1\n", "a.go changed since the changes were proposed"},
+		{"pull request without an issue", "Adds B.", nil, "package a\n\nvar A = 1\n", "does not resolve an issue"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		p, gh, gitRepo := newApprovalRepo(t, "responseType: 1\nresponse: Renamed it.\nfiles:\n  -\n    path: a.go\n    contents: |\n      package a\n\n      var B = 1\n")
+		gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+		pr := vc.PullRequest{Number: 3, Subject: "Add B", Body: tt.prBody, Branch: "pullpal/issue-1", BaseBranch: "main"}
+		gh.pullRequests = []vc.PullRequest{pr}
+		comment := vc.Comment{
+			ID:       1,
+			Type:     vc.CommentReview,
+			Author:   vc.Author{Handle: "someone"},
+			Body:     "rename A to B",
+			FilePath: "a.go",
+			Branch:   pr.Branch,
+			PRNumber: pr.Number,
+			Issue:    vc.Issue{Number: pr.Number, Body: pr.Body},
+		}
+
+		err := p.handleReviewComment(comment)
+		if tt.prBody == "Adds B." {
+			require.ErrorContains(t, err, tt.err)
+			require.Empty(t, p.state.Issue(p.fullName, 1).Proposals)
+			contents, _ := gitRepo.branchFile(pr.Branch, "a.go")
+			require.Equal(t, tt.contents, contents)
+			continue
+		}
+		require.NoError(t, err)
+
+		// nothing is pushed until the changes are approved on the issue
+		contents, _ := gitRepo.branchFile(pr.Branch, "a.go")
+		require.Equal(t, "package a\n\nvar A = 1\n", contents)
+		require.Equal(t, []string{"Renamed it.\n\nThe changes are waiting for approval on #1."}, gh.replies[1])
+		require.Contains(t, gh.lastComment(1), "I prepared changes for #3")
+		require.Contains(t, gh.lastComment(1), "+var B = 1")
+		proposals := p.state.Issue(p.fullName, 1).Proposals
+		require.Len(t, proposals, 1)
+		require.Equal(t, pr.Branch, proposals[0].Branch)
+		require.Equal(t, pr.Number, proposals[0].PRNumber)
+
+		if tt.change != nil {
+			gitRepo.commitOn(pr.Branch, tt.change)
+		}
+		err = p.handleCommands(command("/pullpal approve"))
+		contents, _ = gitRepo.branchFile(pr.Branch, "a.go")
+		require.Equal(t, tt.contents, contents)
+		if tt.err != "" {
+			require.ErrorContains(t, err, tt.err)
+			require.Equal(t, ProposalPending, p.state.Issue(p.fullName, 1).Proposals[0].Status)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, "I pushed the approved changes to #3.", gh.lastComment(1))
+		require.Equal(t, ProposalApproved, p.state.Issue(p.fullName, 1).Proposals[0].Status)
+		for path, expected := range tt.change {
+			contents, _ := gitRepo.branchFile(pr.Branch, path)
+			require.Equal(t, expected, contents)
+		}
+	}
+}
+
+func TestApprovalOfCommands(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		required bool
+		// pending adds a proposal with changes for issue 1 before the commands are given
+		pending  bool
+		commands []string
+		// statuses are the statuses of the proposals of issue 1 afterwards
+		statuses []ProposalStatus
+		closed   []int
+		updated  []int
+	}{
+		{"rebase", true, false, []string{"/pullpal rebase"}, []ProposalStatus{ProposalPending}, nil, nil},
+		{"rebase approved", true, false, []string{"/pullpal rebase", "/pullpal approve"}, []ProposalStatus{ProposalApproved}, nil, []int{5}},
+		{"rebase rejected", true, false, []string{"/pullpal rebase", "/pullpal reject"}, []ProposalStatus{ProposalRejected}, nil, nil},
+		{"rebase without approval", false, false, []string{"/pullpal rebase"}, []ProposalStatus{}, nil, []int{5}},
+		{"cancel", true, false, []string{"/pullpal cancel"}, []ProposalStatus{ProposalPending}, nil, nil},
+		{"cancel approved", true, false, []string{"/pullpal cancel", "/pullpal approve"}, []ProposalStatus{ProposalApproved}, []int{5}, nil},
+		{"cancel rejects pending changes", true, true, []string{"/pullpal cancel"}, []ProposalStatus{ProposalRejected, ProposalPending}, nil, nil},
+		{"cancel without approval rejects pending changes", false, true, []string{"/pullpal cancel"}, []ProposalStatus{ProposalRejected}, []int{5}, nil},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gh := newFakeGithubClient()
+		gh.pullRequests = []vc.PullRequest{{Number: 5, Body: "Resolves #1", Branch: "pullpal/issue-1", BaseBranch: "main"}}
+		p := newTestRepo(t, gh)
+		p.approval = ApprovalSettings{Required: &tt.required}
+		if tt.pending {
+			require.NoError(t, p.addProposal(1, Proposal{Status: ProposalPending, CreatedAt: time.Now().UTC(), Files: []llm.File{{Path: "a.go"}}}))
+		}
+
+		for _, body := range tt.commands {
+			require.NoError(t, p.handleCommands(command(body)))
+		}
+
+		statuses := []ProposalStatus{}
+		for _, proposal := range p.state.Issue(p.fullName, 1).Proposals {
+			statuses = append(statuses, proposal.Status)
+		}
+		require.Equal(t, tt.statuses, statuses)
+		require.Equal(t, tt.closed, gh.closed)
+		require.Equal(t, tt.updated, gh.updated)
+	}
+}
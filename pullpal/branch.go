@@ -0,0 +1,159 @@
+package pullpal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mobyvb/pull-pal/vc"
+)
+
+// DefaultBranchTemplate is the template used to name branches when none is configured.
+const DefaultBranchTemplate = "pullpal/issue-{{ .Number }}-{{ .Slug }}"
+
+// maxSlugLength is the maximum length of the slug generated from an issue's subject.
+const maxSlugLength = 40
+
+// maxAttempts is the maximum number of branches created for a single issue.
+const maxAttempts = 100
+
+// ExistingBranchMode determines what happens when pull pal works on an issue that already has a branch.
+type ExistingBranchMode string
+
+const (
+	// ExistingBranchUpdate replaces the contents of the branch of the open pull request for the issue, and updates the
+	// pull request. If there is none, a branch that does not exist yet is used, as with ExistingBranchNew.
+	ExistingBranchUpdate ExistingBranchMode = "update"
+	// ExistingBranchNew creates a new branch, numbered with the attempt (e.g. "pullpal/issue-123-fix-typo-2").
+	ExistingBranchNew ExistingBranchMode = "new"
+)
+
+// BranchData is the data available to branch name templates.
+type BranchData struct {
+	// Number is the number of the issue.
+	Number int
+	// Slug is a short version of the issue's subject that is safe to use in branch names (e.g. "fix-the-typo").
+	Slug string
+	// Attempt is 1 for the first branch created for the issue, and increases for each new branch.
+	Attempt int
+}
+
+// BranchNamer names branches for issues using a template.
+type BranchNamer struct {
+	tmpl *template.Template
+}
+
+// NewBranchNamer parses a branch name template, such as DefaultBranchTemplate. If text is empty, DefaultBranchTemplate is used.
+func NewBranchNamer(text string) (*BranchNamer, error) {
+	if text == "" {
+		text = DefaultBranchTemplate
+	}
+	tmpl, err := template.New("branch").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing branch template: %w", err)
+	}
+	namer := &BranchNamer{tmpl: tmpl}
+
+	// render a sample so that invalid templates are caught at startup rather than when an issue is picked up
+	_, err = namer.Name(vc.Issue{Number: 1, Subject: "sample"}, 2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branch template: %w", err)
+	}
+	return namer, nil
+}
+
+// Name returns the name of the branch for an attempt at an issue. If the template does not use the attempt, attempts
+// after the first are suffixed with the attempt number so that every attempt gets its own branch.
+func (b *BranchNamer) Name(issue vc.Issue, attempt int) (string, error) {
+	data := BranchData{Number: issue.Number, Slug: Slugify(issue.Subject), Attempt: attempt}
+	name, err := b.render(data)
+	if err != nil {
+		return "", err
+	}
+	if attempt > 1 {
+		data.Attempt = 1
+		first, err := b.render(data)
+		if err != nil {
+			return "", err
+		}
+		if name == first {
+			name = fmt.Sprintf("%s-%d", name, attempt)
+		}
+	}
+	return name, nil
+}
+
+func (b *BranchNamer) render(data BranchData) (string, error) {
+	var buf bytes.Buffer
+	err := b.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	// an empty slug should not leave a trailing dash
+	name := strings.TrimRight(strings.TrimSpace(buf.String()), "-")
+	if name == "" {
+		return "", errors.New("branch name is empty")
+	}
+	if strings.ContainsAny(name, " ~^:?*[\\") || strings.Contains(name, "..") || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return "", fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return name, nil
+}
+
+// Slugify converts text to lowercase words separated by dashes, keeping only letters and digits, so that it can be used
+// in a branch name. The result is shortened to at most 40 characters, on a word boundary where possible.
+func Slugify(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	slug := ""
+	for _, w := range words {
+		next := w
+		if slug != "" {
+			next = slug + "-" + w
+		}
+		if len(next) > maxSlugLength {
+			if slug == "" {
+				slug = w[:maxSlugLength]
+			}
+			break
+		}
+		slug = next
+	}
+	return slug
+}
+
+// issueBranch chooses the branch to push an attempt at issue to. In update mode, the branch of an open pull request
+// resolving the issue is returned along with the pull request, so that it can be updated instead of opening another one.
+// Otherwise, the first attempt whose branch does not exist yet is used, so that a branch without an open pull request
+// (e.g. one whose pull request was closed) is never overwritten.
+func (p *pullPalRepo) issueBranch(issue vc.Issue) (string, *vc.PullRequest, error) {
+	if p.existingBranch == ExistingBranchUpdate {
+		prs, err := p.ghClient.ListOwnPullRequests()
+		if err != nil {
+			return "", nil, err
+		}
+		for _, pr := range prs {
+			if resolvedIssue(pr.Body) == issue.Number {
+				return pr.Branch, &pr, nil
+			}
+		}
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		name, err := p.branchNamer.Name(issue, attempt)
+		if err != nil {
+			return "", nil, err
+		}
+		exists, err := p.ghClient.BranchExists(name)
+		if err != nil {
+			return "", nil, err
+		}
+		if !exists {
+			return name, nil, nil
+		}
+	}
+	return "", nil, fmt.Errorf("issue #%d already has %d branches", issue.Number, maxAttempts)
+}
@@ -0,0 +1,42 @@
+package pullpal
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueBranch(t *testing.T) {
+	issue := vc.Issue{Number: 123, Subject: "Fix the typo"}
+	open := vc.PullRequest{Number: 3, Body: "Resolves #123", Branch: "pullpal/issue-123-fix-the-typo"}
+
+	var testCases = []struct {
+		testcase     string
+		mode         ExistingBranchMode
+		pullRequests []vc.PullRequest
+		branches     []string
+		name         string
+		pr           *vc.PullRequest
+	}{
+		{"first attempt", ExistingBranchUpdate, nil, nil, "pullpal/issue-123-fix-the-typo", nil},
+		{"update open pull request", ExistingBranchUpdate, []vc.PullRequest{open}, nil, "pullpal/issue-123-fix-the-typo", &open},
+		{"update without a pull request", ExistingBranchUpdate, nil, []string{"pullpal/issue-123-fix-the-typo"}, "pullpal/issue-123-fix-the-typo-2", nil},
+		{"new branch", ExistingBranchNew, []vc.PullRequest{open}, []string{"pullpal/issue-123-fix-the-typo-2"}, "pullpal/issue-123-fix-the-typo-3", nil},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gh := newFakeGithubClient()
+		gh.pullRequests = tt.pullRequests
+		gh.branches = tt.branches
+		p := newTestRepo(t, gh)
+		p.existingBranch = tt.mode
+
+		name, pr, err := p.issueBranch(issue)
+		require.NoError(t, err)
+		require.Equal(t, tt.name, name)
+		require.Equal(t, tt.pr, pr)
+	}
+}
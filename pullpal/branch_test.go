@@ -0,0 +1,62 @@
+package pullpal_test
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/pullpal"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlugify(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		text     string
+		slug     string
+	}{
+		{"simple", "Fix the typo", "fix-the-typo"},
+		{"punctuation", "Don't crash on `nil` input!", "don-t-crash-on-nil-input"},
+		{"empty", "???", ""},
+		{"shortened on word boundary", "Add support for custom key bindings in the settings menu", "add-support-for-custom-key-bindings-in"},
+		{"long word", "Supercalifragilisticexpialidocious-and-then-some-more", "supercalifragilisticexpialidocious-and"},
+		{"single long word", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		require.Equal(t, tt.slug, pullpal.Slugify(tt.text))
+	}
+}
+
+func TestBranchNamer(t *testing.T) {
+	issue := vc.Issue{Number: 123, Subject: "Fix the typo"}
+
+	var testCases = []struct {
+		testcase string
+		template string
+		issue    vc.Issue
+		attempt  int
+		name     string
+		fails    bool
+	}{
+		{"default template", "", issue, 1, "pullpal/issue-123-fix-the-typo", false},
+		{"default template, second attempt", "", issue, 2, "pullpal/issue-123-fix-the-typo-2", false},
+		{"empty slug", "", vc.Issue{Number: 5, Subject: "???"}, 1, "pullpal/issue-5", false},
+		{"template with attempt", "fix-{{ .Number }}-try-{{ .Attempt }}", issue, 3, "fix-123-try-3", false},
+		{"template does not parse", "fix-{{ .Number ", issue, 1, "", true},
+		{"unknown field", "fix-{{ .Missing }}", issue, 1, "", true},
+		{"invalid branch name", "fix {{ .Number }}", issue, 1, "", true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		namer, err := pullpal.NewBranchNamer(tt.template)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		name, err := namer.Name(tt.issue, tt.attempt)
+		require.NoError(t, err)
+		require.Equal(t, tt.name, name)
+	}
+}
@@ -0,0 +1,60 @@
+package pullpal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetNotifications(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	gh := newFakeGithubClient()
+	gh.issues[1] = vc.Issue{Number: 1, Subject: "first", Body: "Do the first thing."}
+	gh.issues[2] = vc.Issue{Number: 2, Subject: "second", Body: "Do the second thing."}
+
+	newRepo := func() *pullPalRepo {
+		p := newTestRepo(t, gh)
+		state, err := NewStateStore(statePath)
+		require.NoError(t, err)
+		p.state = state
+		p.budget = Budget{Daily: 1}
+		return p
+	}
+	p := newRepo()
+	require.NoError(t, p.usage.Record(p.fullName, 1, 0, []llm.Usage{{Model: "gpt-4o", PromptTokens: 1000000}}))
+
+	// every waiting issue is told that work is paused, not only the one that would be worked on next
+	require.NoError(t, p.checkIssues())
+	for _, number := range []int{1, 2} {
+		require.Len(t, gh.issueBodies[number], 1)
+		require.True(t, strings.HasPrefix(gh.lastComment(number), "I'm pausing work on this because the owner/repo daily budget"))
+	}
+
+	// notifications are not repeated, including after a restart
+	require.NoError(t, p.checkIssues())
+	require.NoError(t, p.checkIssue(2))
+	restarted := newRepo()
+	restarted.usage = p.usage
+	require.NoError(t, restarted.checkIssues())
+	require.Len(t, gh.issueBodies[1], 1)
+	require.Len(t, gh.issueBodies[2], 1)
+
+	// once spending is under budget, every waiting issue is notified again if the budget is reached later
+	gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+	restarted.localGitClient = gitRepo.client()
+	_, m := newFakeModel(t)
+	useModel(restarted, m)
+	restarted.budget = Budget{}
+	require.NoError(t, restarted.checkIssues())
+	require.False(t, restarted.state.Issue(restarted.fullName, 1).BudgetNotified)
+	require.False(t, restarted.state.Issue(restarted.fullName, 2).BudgetNotified)
+	restarted.budget = Budget{Daily: 1}
+	require.NoError(t, restarted.checkIssues())
+	require.Len(t, gh.issueBodies[2], 2)
+	require.True(t, strings.HasPrefix(gh.lastComment(2), "I'm pausing work on this because the owner/repo daily budget"))
+}
@@ -0,0 +1,386 @@
+package pullpal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// CommandPrefix starts every command that can be given to pull pal in a comment.
+const CommandPrefix = "/pullpal"
+
+// CommandName is the name of a command that can be given to pull pal in a comment.
+type CommandName string
+
+const (
+	// CommandRetry works on the issue again.
+	CommandRetry CommandName = "retry"
+	// CommandCancel stops work on the issue, and closes pull requests opened for it.
+	CommandCancel CommandName = "cancel"
+	// CommandModel sets the models used for the issue, e.g. "/pullpal model gpt-4".
+	CommandModel CommandName = "model"
+	// CommandFiles sets additional files to include when working on the issue, e.g. "/pullpal files a.go,b.go".
+	CommandFiles CommandName = "files"
+	// CommandRebase brings a pull request up to date with its base branch.
+	CommandRebase CommandName = "rebase"
+	// CommandExplain explains the changes in a pull request, or the approach to an issue.
+	CommandExplain CommandName = "explain"
+	// CommandApprove pushes the changes proposed for an issue, when approval is required.
+	CommandApprove CommandName = "approve"
+	// CommandReject discards the changes proposed for an issue, when approval is required.
+	CommandReject CommandName = "reject"
+	// CommandUpdate changes a pull request based on the rest of the comment, e.g. "/pullpal update\nUse a map instead."
+	CommandUpdate CommandName = "update"
+)
+
+var knownCommands = []CommandName{CommandRetry, CommandCancel, CommandModel, CommandFiles, CommandRebase, CommandExplain, CommandApprove, CommandReject, CommandUpdate}
+
+// Command is a command given to pull pal in a comment.
+type Command struct {
+	Name CommandName
+	Args []string
+}
+
+func (c Command) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", CommandPrefix, c.Name, strings.Join(c.Args, ",")))
+}
+
+// ParseCommands returns the commands in a comment. Every line that starts with CommandPrefix is a command, with the
+// command name and its arguments separated by spaces or commas.
+func ParseCommands(body string) ([]Command, error) {
+	commands := []Command{}
+	for _, line := range strings.Split(body, "\n") {
+		rest, ok := commandLine(line)
+		if !ok {
+			continue
+		}
+		fields := strings.FieldsFunc(rest, func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t'
+		})
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("no command provided; available commands are: %s", commandList())
+		}
+
+		cmd := Command{Name: CommandName(strings.ToLower(fields[0])), Args: fields[1:]}
+		known := false
+		for _, name := range knownCommands {
+			if cmd.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown command %q; available commands are: %s", cmd.Name, commandList())
+		}
+		if (cmd.Name == CommandModel || cmd.Name == CommandFiles) && len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("%s requires at least one argument", cmd.Name)
+		}
+		if cmd.Name == CommandFiles {
+			for _, path := range cmd.Args {
+				if err := vc.CheckRepoPath(path); err != nil {
+					return nil, err
+				}
+			}
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// HasCommand returns true if the comment contains a command.
+func HasCommand(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if _, ok := commandLine(line); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// commandLine returns the rest of a line that starts with CommandPrefix, followed by whitespace or the end of the line
+// (so that e.g. "/pullpalx" is not a command), and true. Otherwise, it returns false.
+func commandLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, CommandPrefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(line, CommandPrefix)
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return rest, true
+}
+
+func commandList() string {
+	names := []string{}
+	for _, name := range knownCommands {
+		names = append(names, string(name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// handleCommands executes the commands in a comment in order, and replies with the result of each command.
+func (p *pullPalRepo) handleCommands(comment vc.Comment) error {
+	commands, err := ParseCommands(comment.Body)
+	if err != nil {
+		return err
+	}
+
+	issueNumber := p.commentIssue(comment)
+	results := []string{}
+	for _, cmd := range commands {
+		p.log.Info("executing command", zap.String("command", cmd.String()), zap.Int("issue", issueNumber), zap.Int("pr", comment.PRNumber))
+		result, err := p.executeCommand(cmd, comment, issueNumber)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		results = append(results, result)
+	}
+
+	return p.respondToComment(comment, strings.Join(results, "\n\n"))
+}
+
+// executeCommand executes a single command given in comment, and returns a description of the result.
+func (p *pullPalRepo) executeCommand(cmd Command, comment vc.Comment, issueNumber int) (string, error) {
+	if issueNumber == 0 && cmd.Name != CommandExplain && cmd.Name != CommandRebase && cmd.Name != CommandCancel {
+		return "", errors.New("could not find the issue this pull request resolves")
+	}
+
+	switch cmd.Name {
+	case CommandModel:
+		err := p.state.UpdateIssue(p.fullName, issueNumber, func(s *IssueState) {
+			s.Models = cmd.Args
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("I'll use %s for #%d.", strings.Join(cmd.Args, ", "), issueNumber), nil
+
+	case CommandFiles:
+		err := p.state.UpdateIssue(p.fullName, issueNumber, func(s *IssueState) {
+			s.Files = cmd.Args
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("I'll include %s when working on #%d.", strings.Join(cmd.Args, ", "), issueNumber), nil
+
+	case CommandRetry:
+		issue, err := p.ghClient.GetIssue(issueNumber)
+		if err != nil {
+			return "", err
+		}
+		// the outcome, including any error, is reported in the status comment on the issue, so it is not repeated here
+		err = p.handleIssue(issue)
+		if err != nil {
+			p.log.Error("error retrying issue", zap.Int("issue", issueNumber), zap.Error(err))
+		}
+		return fmt.Sprintf("I worked on #%d again; see the status comment on the issue for the outcome.", issueNumber), nil
+
+	case CommandCancel:
+		prs, err := p.commandPullRequests(comment, issueNumber)
+		if err != nil {
+			return "", err
+		}
+		if comment.Type == vc.CommentIssue {
+			// remove labels so that the issue is not picked up again until labels are reapplied
+			for _, label := range p.listIssueOptions.Labels {
+				err = p.ghClient.RemoveLabelFromIssue(issueNumber, label)
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+		if issueNumber != 0 {
+			_, err = p.rejectPendingProposal(issueNumber, comment.Author.Handle)
+			if err != nil {
+				return "", err
+			}
+		}
+		if len(prs) > 0 && p.approval.required() {
+			return p.proposeCommand(cmd.Name, issueNumber, pullRequestNumbers(prs))
+		}
+		return p.applyCommand(cmd.Name, pullRequestNumbers(prs))
+
+	case CommandRebase:
+		prs, err := p.commandPullRequests(comment, issueNumber)
+		if err != nil {
+			return "", err
+		}
+		if len(prs) == 0 {
+			return "", errors.New("no open pull requests to update")
+		}
+		if p.approval.required() {
+			return p.proposeCommand(cmd.Name, issueNumber, pullRequestNumbers(prs))
+		}
+		return p.applyCommand(cmd.Name, pullRequestNumbers(prs))
+
+	case CommandExplain:
+		return p.explain(comment, issueNumber)
+
+	case CommandUpdate:
+		prs, err := p.commandPullRequests(comment, issueNumber)
+		if err != nil {
+			return "", err
+		}
+		if len(prs) == 0 {
+			return "", errors.New("no open pull requests to update")
+		}
+		return p.refinePullRequest(comment, prs[0])
+
+	case CommandApprove:
+		return p.approveProposal(comment, issueNumber)
+
+	case CommandReject:
+		return p.rejectProposal(comment, issueNumber)
+	}
+
+	return "", fmt.Errorf("unknown command %q", cmd.Name)
+}
+
+// commandPullRequests returns the pull requests a command applies to: the pull request the command was given on, or
+// the pull requests resolving the issue the command was given on.
+func (p *pullPalRepo) commandPullRequests(comment vc.Comment, issueNumber int) ([]vc.PullRequest, error) {
+	prs, err := p.ghClient.ListOwnPullRequests()
+	if err != nil {
+		return nil, err
+	}
+	toReturn := []vc.PullRequest{}
+	for _, pr := range prs {
+		if comment.PRNumber != 0 && pr.Number == comment.PRNumber {
+			return []vc.PullRequest{pr}, nil
+		}
+		if comment.PRNumber == 0 && issueNumber != 0 && resolvedIssue(pr.Body) == issueNumber {
+			toReturn = append(toReturn, pr)
+		}
+	}
+	return toReturn, nil
+}
+
+// applyCommand carries out a command that changes the pull requests prNumbers, i.e. "cancel" or "rebase", and returns a
+// description of the result.
+func (p *pullPalRepo) applyCommand(name CommandName, prNumbers []int) (string, error) {
+	for _, number := range prNumbers {
+		var err error
+		if name == CommandRebase {
+			err = p.ghClient.UpdatePullRequestBranch(number)
+		} else {
+			err = p.ghClient.ClosePullRequest(number)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if name == CommandRebase {
+		return "I merged the latest changes from the base branch.", nil
+	}
+	return fmt.Sprintf("I stopped working on this, and closed %d pull request(s).", len(prNumbers)), nil
+}
+
+// commandAction describes what a command that changes pull requests does to them.
+func commandAction(name CommandName) string {
+	if name == CommandRebase {
+		return "merge the latest changes from the base branch into"
+	}
+	return "close"
+}
+
+func pullRequestNumbers(prs []vc.PullRequest) []int {
+	numbers := []int{}
+	for _, pr := range prs {
+		numbers = append(numbers, pr.Number)
+	}
+	return numbers
+}
+
+// explain asks the LLM to explain the changes in a pull request, or its approach to an issue.
+func (p *pullPalRepo) explain(comment vc.Comment, issueNumber int) (string, error) {
+	req := llm.IssueCommentRequest{
+		Prompts:  p.prompts,
+		Number:   issueNumber,
+		Contents: "Explain how you would approach this issue, and which files you would change.",
+	}
+	if comment.PRNumber != 0 {
+		diff, err := p.ghClient.GetPullRequestDiff(comment.PRNumber)
+		if err != nil {
+			return "", err
+		}
+		req.Number = comment.PRNumber
+		req.PullRequest = true
+		req.Subject = comment.Issue.Subject
+		req.Body = comment.Issue.Body
+		req.Diff = diff
+		req.Contents = "Explain the changes in this pull request, and why they were made."
+	} else {
+		issue, err := p.ghClient.GetIssue(issueNumber)
+		if err != nil {
+			return "", err
+		}
+		req.Subject = issue.Subject
+		req.Body = issue.Body
+	}
+
+	res, err := p.openAIClient.EvaluateIssueComment(p.ctx, p.modelsFor(issueNumber), req)
+	p.recordUsage(issueNumber, comment.PRNumber, res.Usage)
+	if err != nil {
+		return "", err
+	}
+	return res.Response, nil
+}
+
+var resolvesPattern = regexp.MustCompile(`(?i)\b(?:resolves|fixes|closes) #(\d+)`)
+
+// resolvedIssue returns the number of the issue a pull request body says it resolves, or 0 if there is none. The last
+// mention is used, since pull pal ends its pull request bodies with it, and the prompt included earlier in the body may
+// mention other issues.
+func resolvedIssue(body string) int {
+	matches := resolvesPattern.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	number, _ := strconv.Atoi(matches[len(matches)-1][1])
+	return number
+}
+
+// commentIssue returns the number of the issue a comment relates to: the issue it was left on, or the issue resolved
+// by the pull request it was left on. If there is none, 0 is returned.
+func (p *pullPalRepo) commentIssue(comment vc.Comment) int {
+	if comment.Type == vc.CommentIssue {
+		return comment.IssueNumber
+	}
+	return resolvedIssue(comment.Issue.Body)
+}
+
+// modelsFor returns the chain of models to use for an issue. The model override, and otherwise models set with
+// "/pullpal model", take precedence over the repository's chain, and are looked up in the configured chains so that
+// their settings (e.g. base URL) are kept.
+func (p *pullPalRepo) modelsFor(issueNumber int) []llm.Model {
+	names := p.modelOverride
+	if len(names) == 0 && issueNumber != 0 {
+		names = p.state.Issue(p.fullName, issueNumber).Models
+	}
+	if len(names) == 0 {
+		return p.models
+	}
+
+	configured := append(append([]llm.Model{}, p.models...), p.defaultModels...)
+	models := []llm.Model{}
+	for _, name := range names {
+		model := llm.Model{Name: name}
+		for _, m := range configured {
+			if m.Name == name {
+				model = m
+				break
+			}
+		}
+		models = append(models, model)
+	}
+	return models
+}
@@ -0,0 +1,94 @@
+package pullpal_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/pullpal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommands(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		body     string
+		commands []pullpal.Command
+		fails    bool
+	}{
+		{"no commands", "looks good, but fix the typo", []pullpal.Command{}, false},
+		{"retry", "/pullpal retry", []pullpal.Command{{Name: pullpal.CommandRetry, Args: []string{}}}, false},
+		{
+			"several commands with arguments",
+			"Let's try again.\n/pullpal model gpt-4\n  /pullpal files a.go, b.go,c.go\n/pullpal retry",
+			[]pullpal.Command{
+				{Name: pullpal.CommandModel, Args: []string{"gpt-4"}},
+				{Name: pullpal.CommandFiles, Args: []string{"a.go", "b.go", "c.go"}},
+				{Name: pullpal.CommandRetry, Args: []string{}},
+			},
+			false,
+		},
+		{"case insensitive", "/pullpal Explain", []pullpal.Command{{Name: pullpal.CommandExplain, Args: []string{}}}, false},
+		{"approve", "Looks good to me.\n/pullpal approve", []pullpal.Command{{Name: pullpal.CommandApprove, Args: []string{}}}, false},
+		{"longer word", "/pullpalx retry\n/pullpal-foo retry", []pullpal.Command{}, false},
+		{"unknown command", "/pullpal deploy", nil, true},
+		{"missing command", "/pullpal", nil, true},
+		{"missing argument", "/pullpal model", nil, true},
+		{"absolute file path", "/pullpal files a.go,/etc/passwd", nil, true},
+		{"file path outside the repository", "/pullpal files docs/../../secrets.txt", nil, true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		commands, err := pullpal.ParseCommands(tt.body)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.commands, commands)
+		require.Equal(t, len(tt.commands) > 0, pullpal.HasCommand(tt.body))
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	store, err := pullpal.NewStateStore(path)
+	require.NoError(t, err)
+	require.Equal(t, pullpal.IssueState{}, store.Issue("owner/repo", 1))
+
+	require.NoError(t, store.UpdateIssue("owner/repo", 1, func(s *pullpal.IssueState) {
+		s.Models = []string{"gpt-4"}
+	}))
+	require.NoError(t, store.UpdateIssue("owner/repo", 1, func(s *pullpal.IssueState) {
+		s.Files = []string{"a.go"}
+	}))
+
+	// state should persist across restarts, and be kept separate for each issue
+	store, err = pullpal.NewStateStore(path)
+	require.NoError(t, err)
+	require.Equal(t, pullpal.IssueState{Models: []string{"gpt-4"}, Files: []string{"a.go"}}, store.Issue("owner/repo", 1))
+	require.Equal(t, pullpal.IssueState{}, store.Issue("owner/repo", 2))
+	require.Equal(t, pullpal.IssueState{}, store.Issue("owner/other", 1))
+
+	// proposals are persisted, and every issue with state can be listed
+	proposal := pullpal.Proposal{
+		Status:    pullpal.ProposalRejected,
+		CreatedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Model:     "gpt-4",
+		Files:     []llm.File{{Path: "a.go", Contents: "package a"}},
+	}
+	require.NoError(t, store.UpdateIssue("owner/repo", 3, func(s *pullpal.IssueState) {
+		s.Proposals = append(s.Proposals, proposal)
+	}))
+	require.NoError(t, store.UpdateIssue("owner/repo2", 4, func(s *pullpal.IssueState) {
+		s.Files = []string{"b.go"}
+	}))
+	store, err = pullpal.NewStateStore(path)
+	require.NoError(t, err)
+	issues := store.Issues("owner/repo")
+	require.Len(t, issues, 2)
+	require.Equal(t, []string{"a.go"}, issues[1].Files)
+	require.Equal(t, []pullpal.Proposal{proposal}, issues[3].Proposals)
+}
@@ -0,0 +1,141 @@
+package pullpal
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueCommentRouting(t *testing.T) {
+	change := "files:\n  -\n    path: a.go\n    contents: |\n      package a\n\n      var B = 1\nnotes: Renamed A to B.\n"
+	var testCases = []struct {
+		testcase string
+		body     string
+		// openPR opens a pull request for the issue before the comment is handled
+		openPR    bool
+		responses []string
+		reply     string
+		// contents is the contents of a.go on the pull request branch afterwards, if there is one
+		contents string
+	}{
+		{
+			"question without a pull request",
+			"Which file will you change?",
+			false,
+			[]string{"response: a.go\n"},
+			"a.go",
+			"",
+		},
+		{
+			"question about a pull request",
+			"Why is A a var?",
+			true,
+			[]string{"response: So that it can be changed.\nchangeRequested: false\n"},
+			"So that it can be changed.",
+			"package a\n\nvar A = 1\n",
+		},
+		{
+			"change requested",
+			"Please rename A to B.",
+			true,
+			[]string{"response: I'll rename it.\nchangeRequested: true\n", change},
+			"I'll rename it.\n\nI updated https://example.com/pull/3.\n\nRenamed A to B.",
+			"package a\n\nvar B = 1\n",
+		},
+		{
+			"update command",
+			"/pullpal update\nRename A to B.",
+			true,
+			[]string{change},
+			"I updated https://example.com/pull/3.\n\nRenamed A to B.",
+			"package a\n\nvar B = 1\n",
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+		gh := newFakeGithubClient()
+		gh.issues[1] = vc.Issue{Number: 1, Subject: "Add A", Body: "Add A to a.go."}
+		if tt.openPR {
+			gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+			gh.pullRequests = []vc.PullRequest{{Number: 3, Subject: "Add A", Body: "Resolves #1", URL: "https://example.com/pull/3", Branch: "pullpal/issue-1", BaseBranch: "main"}}
+			gh.prFiles[3] = []string{"a.go"}
+			gh.diffs[3] = "+var A = 1"
+		}
+		model, m := newFakeModel(t, tt.responses...)
+		p := newTestRepo(t, gh)
+		p.localGitClient = gitRepo.client()
+		useModel(p, m)
+
+		comment := vc.Comment{
+			ID:          1,
+			Type:        vc.CommentIssue,
+			Author:      vc.Author{Handle: "someone"},
+			Body:        tt.body,
+			IssueNumber: 1,
+			Issue:       gh.issues[1],
+		}
+		if HasCommand(tt.body) {
+			require.NoError(t, p.handleCommands(comment))
+		} else {
+			require.NoError(t, p.handleComment(comment))
+		}
+
+		require.Equal(t, len(tt.responses), model.requests())
+		require.Equal(t, tt.reply, gh.lastComment(1))
+		if !tt.openPR {
+			// the model is only asked whether to change the pull request if there is one
+			require.NotContains(t, model.prompts[0], "changeRequested")
+			continue
+		}
+		contents, _ := gitRepo.branchFile("pullpal/issue-1", "a.go")
+		require.Equal(t, tt.contents, contents)
+		if tt.contents != "package a\n\nvar A = 1\n" {
+			// the pull request was changed based on the comment
+			require.Contains(t, model.prompts[len(model.prompts)-1], "A to B")
+		}
+	}
+}
+
+func TestRefinePullRequest(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		prBody   string
+		// prompt contains text that must be in the prompt, and notPrompt text that must not be
+		prompt    string
+		notPrompt string
+	}{
+		{"pull request resolving an issue", "Adds A.\n\nResolves #1", "Add A to a.go.", "Adds A."},
+		{"pull request without an issue", "Adds A.\n\n<details>\n<summary>Prompt</summary>\n\nthe old prompt\n</details>", "Adds A.", "the old prompt"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n", "main.go": "package main\n"})
+		gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+		gh := newFakeGithubClient()
+		gh.issues[1] = vc.Issue{Number: 1, Subject: "Add A", Body: "Add A to a.go."}
+		pr := vc.PullRequest{Number: 3, Subject: "Add A", Body: tt.prBody, URL: "https://example.com/pull/3", Branch: "pullpal/issue-1", BaseBranch: "main"}
+		gh.prFiles[3] = []string{"a.go"}
+		// the model also changes main.go, which was not in the prompt
+		model, m := newFakeModel(t, "files:\n  -\n    path: a.go\n    contents: |\n      package a\n\n      var B = 1\n  -\n    path: main.go\n    contents: |\n      package main\n\n      func main() {}\nnotes: Renamed A to B.\n")
+		p := newTestRepo(t, gh)
+		p.localGitClient = gitRepo.client()
+		useModel(p, m)
+
+		comment := vc.Comment{ID: 1, Type: vc.CommentPullRequest, Author: vc.Author{Handle: "someone"}, Body: "Rename A to B.", PRNumber: 3, Issue: vc.Issue{Number: 3, Body: pr.Body}}
+		reply, err := p.refinePullRequest(comment, pr)
+		require.NoError(t, err)
+		require.Equal(t, "Renamed A to B.", reply)
+		require.Contains(t, model.prompts[0], tt.prompt)
+		require.NotContains(t, model.prompts[0], tt.notPrompt)
+
+		contents, _ := gitRepo.branchFile("pullpal/issue-1", "a.go")
+		require.Equal(t, "package a\n\nvar B = 1\n", contents)
+		contents, _ = gitRepo.branchFile("pullpal/issue-1", "main.go")
+		require.Equal(t, "package main\n", contents)
+	}
+}
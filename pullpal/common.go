@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mobyvb/pull-pal/internal/timeutil"
 	"github.com/mobyvb/pull-pal/llm"
 	"github.com/mobyvb/pull-pal/vc"
 
@@ -18,15 +22,94 @@ import (
 // IssueNotFound is returned when no issue can be found to generate a prompt for.
 var IssueNotFound = errors.New("no issue found")
 
+// ErrWebhookSecretRequired is returned when webhooks are enabled without a secret to verify them with.
+var ErrWebhookSecretRequired = errors.New("a webhook secret is required to listen for webhooks")
+
 type Config struct {
 	WaitDuration     time.Duration
 	LocalRepoPath    string
 	Repos            []string
 	Self             vc.Author
 	ListIssueOptions vc.ListIssueOptions
-	Model            string
-	OpenAIToken      string
-	DebugDir         string
+	// Models is the default chain of models to use. If a model fails, the next one is tried.
+	Models      []llm.Model
+	LLMRetry    llm.RetryConfig
+	OpenAIToken string
+	DebugDir    string
+	// PromptsDir is a directory containing prompt templates that override the default templates.
+	PromptsDir string
+	// RepoSettings overrides settings for specific repositories.
+	RepoSettings []RepoSettings
+	// WebhookAddr is the address to listen for Github webhooks on (e.g. ":8080"). Webhooks are disabled if empty.
+	WebhookAddr string
+	// WebhookSecret is the secret used to verify Github webhook signatures. It is required if WebhookAddr is set.
+	WebhookSecret string
+	// StateDir is the directory pull pal persists state in, such as LLM usage. State is not persisted if empty.
+	StateDir string
+	// Prices overrides the prices used to compute the cost of LLM usage.
+	Prices []Price
+	// Budget limits LLM spending across all repositories.
+	Budget Budget
+	// BranchTemplate is the template used to name branches for issues. DefaultBranchTemplate is used if empty.
+	BranchTemplate string
+	// ExistingBranch determines what happens when an issue already has a branch. ExistingBranchUpdate is used if empty.
+	ExistingBranch ExistingBranchMode
+	// PullRequests configures the pull requests opened for issues.
+	PullRequests vc.PullRequestSettings
+	// VerifyCommand is a shell command run in the repository to verify changes, e.g. "go build ./... && go test ./...".
+	// Changes are not verified if empty.
+	VerifyCommand string
+	// StatusType determines how progress is reported on branches. StatusTypeStatuses is used if empty.
+	StatusType StatusType
+	// LifecycleLabels are applied to issues as they are worked on. DefaultLifecycleLabels are used for labels not set.
+	LifecycleLabels LifecycleLabels
+	// Approval configures whether changes must be approved before they are pushed.
+	Approval ApprovalSettings
+	// DryRun makes pull pal generate and commit changes locally, but record what it would have done on Github, such as
+	// pushes, pull requests, comments and labels, instead of doing it. State is not persisted in a dry run.
+	DryRun bool
+	// DryRunDir is the directory dry runs are recorded in. If empty, they are written to stdout.
+	DryRunDir string
+	// ModelOverride contains the names of models to use for every request, in order of preference, instead of the
+	// configured chains and models set with commands, e.g. to try a model on a single issue.
+	ModelOverride []string
+}
+
+// RepoSettings defines settings that apply to a single repository.
+type RepoSettings struct {
+	// Repo is the repository the settings apply to, in the same format as Config.Repos (e.g. "github.com/owner/name").
+	Repo string `mapstructure:"repo"`
+	// Models is the chain of models to use for this repository, instead of the default chain.
+	Models []llm.Model `mapstructure:"models"`
+	// PromptsDir is a directory containing prompt templates for this repository.
+	// Templates committed to the repository itself, in .pullpal/prompts, take precedence over these.
+	PromptsDir string `mapstructure:"prompts-dir"`
+	// Budget limits LLM spending for this repository, in addition to the global budget.
+	Budget Budget `mapstructure:"budget"`
+	// BranchTemplate is the template used to name branches for this repository, instead of the default template.
+	BranchTemplate string `mapstructure:"branch-template"`
+	// ExistingBranch determines what happens when an issue in this repository already has a branch.
+	ExistingBranch ExistingBranchMode `mapstructure:"existing-branch"`
+	// PullRequests overrides the global pull request settings for this repository.
+	PullRequests vc.PullRequestSettings `mapstructure:"pull-requests"`
+	// VerifyCommand is the command used to verify changes in this repository, instead of the default command.
+	VerifyCommand string `mapstructure:"verify-command"`
+	// StatusType determines how progress is reported on branches in this repository.
+	StatusType StatusType `mapstructure:"status-type"`
+	// LifecycleLabels overrides the labels applied to issues in this repository as they are worked on.
+	LifecycleLabels LifecycleLabels `mapstructure:"lifecycle-labels"`
+	// Approval overrides the approval settings for this repository.
+	Approval ApprovalSettings `mapstructure:"approval"`
+}
+
+// repoSettings returns the settings for the provided repository, or empty settings if there are none.
+func (cfg Config) repoSettings(repo string) RepoSettings {
+	for _, s := range cfg.RepoSettings {
+		if strings.EqualFold(s.Repo, repo) {
+			return s
+		}
+	}
+	return RepoSettings{Repo: repo}
 }
 
 // PullPal is the service responsible for:
@@ -41,21 +124,117 @@ type PullPal struct {
 
 	repos        []pullPalRepo
 	openAIClient *llm.OpenAIClient
+	githubQuota  *vc.RateLimitTransport
+	usage        *UsageTracker
+	jobs         chan vc.WebhookEvent
+}
+
+// githubClient is the part of vc.GithubClient used to work on a repository, so that it can be replaced in tests.
+type githubClient interface {
+	ListOpenIssues(options vc.ListIssueOptions) ([]vc.Issue, error)
+	GetIssue(number int) (vc.Issue, error)
+	CommentOnIssue(issueNumber int, comment string) error
+	CreateIssueComment(issueNumber int, body string) (int64, error)
+	EditIssueComment(commentID int64, body string) error
+	AddReactionToIssue(issueNumber int, content string) error
+	AddLabelsToIssue(issueNumber int, labels []string) error
+	RemoveLabelFromIssue(issueNumber int, label string) error
+
+	ListOpenComments(options vc.ListCommentOptions) ([]vc.Comment, error)
+	ListOpenIssueComments(options vc.ListCommentOptions) ([]vc.Comment, error)
+	ListOpenReviewComments(prNumber int, reviewID, commentID int64, options vc.ListCommentOptions) ([]vc.Comment, error)
+	GetOpenIssueComment(number int, options vc.ListCommentOptions) (vc.Comment, bool, error)
+	GetReviewComment(id int64, options vc.ListCommentOptions) (vc.Comment, error)
+	GetReview(prNumber int, reviewID int64) (vc.Review, error)
+	RespondToComment(prNumber int, commentID int64, comment string) error
+
+	OpenCodeChangeRequest(req llm.CodeChangeRequest, body, fromBranch string, options vc.PullRequestOptions) (id, url string, err error)
+	ListOwnPullRequests() ([]vc.PullRequest, error)
+	EditPullRequest(number int, title, body string) error
+	ClosePullRequest(number int) error
+	UpdatePullRequestBranch(number int) error
+	GetPullRequestDiff(number int) (string, error)
+	ListPullRequestFiles(prNumber int) ([]string, error)
+	BranchExists(name string) (bool, error)
+	SetStatus(sha string, status vc.Status, checks bool) error
 }
 
 type pullPalRepo struct {
 	ctx context.Context
 	log *zap.Logger
+	// mu is held while working on the repository, since webhook events are handled alongside polling, and work shares
+	// the local clone.
+	mu *sync.Mutex
+
+	// fullName is the name of the repository including its owner (e.g. "owner/name").
+	fullName string
+	// self is the account pull pal acts as.
+	self vc.Author
+	// models is the chain of models used for this repository. If empty, the default chain is used.
+	models []llm.Model
+	// defaultModels is the default chain of models, used to look up the settings of models chosen with commands.
+	defaultModels []llm.Model
+	// modelOverride contains the names of models used instead of any chain, if set.
+	modelOverride []string
+	// prompts contains the prompt templates used for this repository.
+	prompts *llm.Prompts
+	// promptDirs are the directories prompts are loaded from, in order of precedence. The last is in the local clone, so
+	// prompts are loaded again whenever a branch is checked out.
+	promptDirs []string
+	// usage records LLM usage, and is shared by all repositories.
+	usage        *UsageTracker
+	budget       Budget
+	globalBudget Budget
+	// state contains the persisted state of issues, and is shared by all repositories.
+	state *StateStore
+	// branchNamer names the branches created for issues.
+	branchNamer    *BranchNamer
+	existingBranch ExistingBranchMode
+	// pullRequests configures the pull requests opened for issues, and can be overridden by each issue.
+	pullRequests vc.PullRequestSettings
+	// verifyCommand is run to verify changes, and statusType determines how progress and results are reported.
+	verifyCommand string
+	statusType    StatusType
+	// lifecycleLabels are applied to issues as they are worked on.
+	lifecycleLabels LifecycleLabels
+	// approval configures whether changes must be approved before they are pushed.
+	approval ApprovalSettings
 
 	listIssueOptions vc.ListIssueOptions
-	ghClient         *vc.GithubClient
+	ghClient         githubClient
 	localGitClient   *vc.LocalGitClient
 	openAIClient     *llm.OpenAIClient
 }
 
 // NewPullPal creates a new "pull pal service", including setting up local version control and LLM integrations.
 func NewPullPal(ctx context.Context, log *zap.Logger, cfg Config) (*PullPal, error) {
-	openAIClient := llm.NewOpenAIClient(log.Named("openaiClient"), cfg.Model, cfg.OpenAIToken, cfg.DebugDir)
+	// without a secret, anyone who can reach the listener could trigger work
+	if cfg.WebhookAddr != "" && cfg.WebhookSecret == "" {
+		return nil, ErrWebhookSecretRequired
+	}
+	openAIClient := llm.NewOpenAIClient(log.Named("openaiClient"), cfg.Models, cfg.OpenAIToken, cfg.DebugDir, cfg.LLMRetry)
+	// all repos are accessed with the same token, so they share a rate limit
+	githubQuota := vc.NewRateLimitTransport(log.Named("github-ratelimit"), nil)
+	usagePath, statePath := "", ""
+	if cfg.StateDir != "" {
+		usagePath = filepath.Join(cfg.StateDir, "usage.jsonl")
+		// a dry run should not affect how issues are handled afterwards, e.g. by leaving proposals waiting for approval
+		if !cfg.DryRun {
+			statePath = filepath.Join(cfg.StateDir, "state.json")
+		}
+	}
+	var dryRun *vc.DryRun
+	if cfg.DryRun {
+		dryRun = vc.NewDryRun(cfg.DryRunDir, os.Stdout)
+	}
+	usage, err := NewUsageTracker(usagePath, cfg.Prices)
+	if err != nil {
+		return nil, fmt.Errorf("loading usage: %w", err)
+	}
+	state, err := NewStateStore(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("loading state: %w", err)
+	}
 
 	ppRepos := []pullPalRepo{}
 	for _, r := range cfg.Repos {
@@ -74,7 +253,7 @@ func NewPullPal(ctx context.Context, log *zap.Logger, cfg Config) (*PullPal, err
 				Handle: owner,
 			},
 		}
-		ghClient, err := vc.NewGithubClient(ctx, log.Named("ghclient-"+r), cfg.Self, newRepo)
+		ghClient, err := vc.NewGithubClient(ctx, log.Named("ghclient-"+r), cfg.Self, newRepo, githubQuota)
 		if err != nil {
 			return nil, err
 		}
@@ -82,9 +261,74 @@ func NewPullPal(ctx context.Context, log *zap.Logger, cfg Config) (*PullPal, err
 		if err != nil {
 			return nil, err
 		}
+		if dryRun != nil {
+			ghClient.SetDryRun(dryRun)
+			localGitClient.SetDryRun(dryRun)
+		}
+		settings := cfg.repoSettings(r)
+		promptDirs := []string{cfg.PromptsDir, settings.PromptsDir, filepath.Join(newRepo.LocalPath, ".pullpal", "prompts")}
+		prompts, err := llm.LoadPrompts(promptDirs...)
+		if err != nil {
+			return nil, fmt.Errorf("loading prompt templates for %s: %w", r, err)
+		}
+		branchTemplate := cfg.BranchTemplate
+		if settings.BranchTemplate != "" {
+			branchTemplate = settings.BranchTemplate
+		}
+		branchNamer, err := NewBranchNamer(branchTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r, err)
+		}
+		existingBranch := cfg.ExistingBranch
+		if settings.ExistingBranch != "" {
+			existingBranch = settings.ExistingBranch
+		}
+		if existingBranch == "" {
+			existingBranch = ExistingBranchUpdate
+		}
+		if existingBranch != ExistingBranchUpdate && existingBranch != ExistingBranchNew {
+			return nil, fmt.Errorf("%s: unknown existing branch mode %q, expected %q or %q", r, existingBranch, ExistingBranchUpdate, ExistingBranchNew)
+		}
+		verifyCommand := cfg.VerifyCommand
+		if settings.VerifyCommand != "" {
+			verifyCommand = settings.VerifyCommand
+		}
+		statusType := cfg.StatusType
+		if settings.StatusType != "" {
+			statusType = settings.StatusType
+		}
+		if statusType == "" {
+			statusType = StatusTypeStatuses
+		}
+		if statusType != StatusTypeStatuses && statusType != StatusTypeChecks && statusType != StatusTypeNone {
+			return nil, fmt.Errorf("%s: unknown status type %q, expected %q, %q or %q", r, statusType, StatusTypeStatuses, StatusTypeChecks, StatusTypeNone)
+		}
 		ppRepos = append(ppRepos, pullPalRepo{
-			ctx: ctx,
-			log: log,
+			ctx:        ctx,
+			log:        log,
+			mu:         &sync.Mutex{},
+			fullName:   owner + "/" + name,
+			self:       cfg.Self,
+			models:     settings.Models,
+			prompts:    prompts,
+			promptDirs: promptDirs,
+
+			defaultModels: cfg.Models,
+			modelOverride: cfg.ModelOverride,
+			state:         state,
+
+			branchNamer:    branchNamer,
+			existingBranch: existingBranch,
+			pullRequests:   cfg.PullRequests.Merge(settings.PullRequests),
+			verifyCommand:  verifyCommand,
+			statusType:     statusType,
+
+			lifecycleLabels: DefaultLifecycleLabels.Merge(cfg.LifecycleLabels).Merge(settings.LifecycleLabels),
+			approval:        cfg.Approval.Merge(settings.Approval),
+
+			usage:        usage,
+			budget:       settings.Budget,
+			globalBudget: cfg.Budget,
 
 			ghClient:       ghClient,
 			localGitClient: localGitClient,
@@ -103,30 +347,121 @@ func NewPullPal(ctx context.Context, log *zap.Logger, cfg Config) (*PullPal, err
 
 		repos:        ppRepos,
 		openAIClient: openAIClient,
+		githubQuota:  githubQuota,
+		usage:        usage,
+		jobs:         make(chan vc.WebhookEvent, 100),
 		cfg:          cfg,
 	}, nil
 }
 
 // Run starts pull pal as a fully automated service that periodically requests changes and creates pull requests based on them.
+// If a webhook address is configured, events received from Github are handled immediately, and polling acts as a fallback
+// to reconcile any events that were missed.
+// In a dry run, issues keep their labels and comments are not answered, so every repository is checked once instead.
 func (p *PullPal) Run() error {
 	p.log.Info("Starting Pull Pal")
-	// TODO gracefully handle context cancelation
+
+	if p.cfg.DryRun {
+		for _, r := range p.repos {
+			err := r.checkIssuesAndComments()
+			if err != nil {
+				p.log.Error("issue checking repo for issues and comments", zap.Error(err))
+			}
+		}
+		return nil
+	}
+
+	if p.cfg.WebhookAddr != "" {
+		server := &http.Server{
+			Addr:    p.cfg.WebhookAddr,
+			Handler: NewWebhookHandler(p.log.Named("webhook"), []byte(p.cfg.WebhookSecret), p.jobs),
+		}
+		go func() {
+			p.log.Info("listening for webhooks", zap.String("addr", p.cfg.WebhookAddr))
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				p.log.Error("webhook server stopped", zap.Error(err))
+			}
+		}()
+		defer server.Close()
+		go p.handleWebhookEvents()
+	}
+
 	for {
 		for _, r := range p.repos {
+			r.mu.Lock()
 			err := r.checkIssuesAndComments()
+			r.mu.Unlock()
 			if err != nil {
 				p.log.Error("issue checking repo for issues and comments", zap.Error(err))
 			}
 		}
 
-		// TODO remove sleep
-		p.log.Info("sleeping", zap.Duration("wait duration", p.cfg.WaitDuration))
-		time.Sleep(p.cfg.WaitDuration)
+		quota := p.githubQuota.Quota()
+		p.log.Info("github quota", zap.Int("limit", quota.Limit), zap.Int("remaining", quota.Remaining), zap.Time("reset", quota.Reset))
+
+		p.log.Info("waiting for next check", zap.Duration("wait duration", p.cfg.WaitDuration))
+		err := timeutil.SleepContext(p.ctx, p.cfg.WaitDuration)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleWebhookEvents handles webhook events as they are received, until the context is canceled. A repository that
+// is being polled handles its events once the poll is done.
+func (p *PullPal) handleWebhookEvents() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case event := <-p.jobs:
+			p.handleWebhookEvent(event)
+		}
+	}
+}
+
+// handleWebhookEvent handles a webhook event in the repository it occurred in. Only the issue, comment or review the
+// event refers to is fetched.
+func (p *PullPal) handleWebhookEvent(event vc.WebhookEvent) {
+	for _, r := range p.repos {
+		if !strings.EqualFold(r.fullName, event.Repo) {
+			continue
+		}
+		r.mu.Lock()
+		var err error
+		switch event.Type {
+		case vc.WebhookIssues:
+			err = r.checkIssue(event.IssueNumber)
+		case vc.WebhookIssueComment:
+			err = r.checkIssueComment(event.IssueNumber)
+		default:
+			err = r.checkReview(event.IssueNumber, event.ReviewID, event.CommentID)
+		}
+		r.mu.Unlock()
+		if err != nil {
+			p.log.Error("error handling webhook event", zap.String("repo", r.fullName), zap.String("type", string(event.Type)), zap.Error(err))
+		}
+		return
 	}
+	p.log.Warn("received webhook event for unknown repo", zap.String("repo", event.Repo))
 }
 
 // checkIssuesAndComments will attempt to find and solve one issue and one comment, and then return.
 func (p pullPalRepo) checkIssuesAndComments() error {
+	err := p.expireProposals()
+	if err != nil {
+		return err
+	}
+	err = p.checkIssues()
+	if err != nil {
+		return err
+	}
+	return p.checkComments()
+}
+
+// checkIssues will attempt to find and solve one issue.
+func (p pullPalRepo) checkIssues() error {
 	p.log.Debug("checking github issues...")
 	issues, err := p.ghClient.ListOpenIssues(p.listIssueOptions)
 	if err != nil {
@@ -136,20 +471,97 @@ func (p pullPalRepo) checkIssuesAndComments() error {
 
 	if len(issues) == 0 {
 		p.log.Debug("no issues found")
-	} else {
-		p.log.Info("picked issue to process")
+		return nil
+	}
 
-		issue := issues[0]
-		err = p.handleIssue(issue)
-		if err != nil {
-			p.log.Error("error handling issue", zap.Error(err))
-			commentText := fmt.Sprintf("I ran into a problem working on this:\n```\n%s\n```", err.Error())
-			err = p.ghClient.CommentOnIssue(issue.Number, commentText)
+	// while spending is over budget no issue can be worked on, so every waiting issue is told why
+	paused, err := p.pauseOverBudget(issues)
+	if paused || err != nil {
+		return err
+	}
+
+	p.log.Info("picked issue to process")
+	return p.processIssue(issues[0])
+}
+
+// checkIssue will attempt to solve the issue with the provided number, if it meets the criteria for listing issues.
+func (p pullPalRepo) checkIssue(number int) error {
+	issues, err := p.ghClient.ListOpenIssues(p.listIssueOptions)
+	if err != nil {
+		p.log.Error("error listing issues", zap.Error(err))
+		return err
+	}
+
+	for _, issue := range issues {
+		if issue.Number == number {
+			p.log.Info("picked issue to process", zap.Int("number", number))
+			return p.processIssue(issue)
+		}
+	}
+
+	p.log.Debug("issue not eligible for processing", zap.Int("number", number))
+	return nil
+}
+
+// pauseOverBudget returns true if spending has reached a budget, and comments on each of issues that has not been told
+// yet that work on it is paused. Notifications are kept in the state store, so that they are not repeated after a
+// restart, and are forgotten once spending is back under budget, so that every issue is told again if a budget is
+// reached later.
+func (p pullPalRepo) pauseOverBudget(issues []vc.Issue) (bool, error) {
+	budgetErr := p.usage.CheckBudget(p.fullName, p.globalBudget, p.budget)
+	if budgetErr == nil {
+		for number, state := range p.state.Issues(p.fullName) {
+			if !state.BudgetNotified {
+				continue
+			}
+			err := p.state.UpdateIssue(p.fullName, number, func(s *IssueState) {
+				s.BudgetNotified = false
+			})
 			if err != nil {
-				p.log.Error("error commenting on issue with error", zap.Error(err))
-				return err
+				return false, err
 			}
 		}
+		return false, nil
+	}
+
+	p.log.Warn("pausing work on issues", zap.Error(budgetErr))
+	for _, issue := range issues {
+		if p.state.Issue(p.fullName, issue.Number).BudgetNotified {
+			continue
+		}
+		commentText := fmt.Sprintf("I'm pausing work on this because the %s. I'll pick it up again once spending is back under budget.", budgetErr.Error())
+		err := p.ghClient.CommentOnIssue(issue.Number, commentText)
+		if err != nil {
+			return true, err
+		}
+		err = p.state.UpdateIssue(p.fullName, issue.Number, func(s *IssueState) {
+			s.BudgetNotified = true
+		})
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// processIssue handles an issue. Progress and errors are reported on the issue by handleIssue.
+func (p pullPalRepo) processIssue(issue vc.Issue) error {
+	paused, err := p.pauseOverBudget([]vc.Issue{issue})
+	if paused || err != nil {
+		return err
+	}
+
+	err = p.handleIssue(issue)
+	if err != nil {
+		p.log.Error("error handling issue", zap.Error(err))
+	}
+	return nil
+}
+
+// checkComments will attempt to find and address one comment.
+func (p pullPalRepo) checkComments() error {
+	if p.commentsPaused() {
+		return nil
 	}
 
 	p.log.Debug("checking pr comments...")
@@ -160,18 +572,97 @@ func (p pullPalRepo) checkIssuesAndComments() error {
 		p.log.Error("error listing comments", zap.Error(err))
 		return err
 	}
+	issueComments, err := p.ghClient.ListOpenIssueComments(p.issueCommentOptions())
+	if err != nil {
+		p.log.Error("error listing issue comments", zap.Error(err))
+		return err
+	}
+	return p.addressComments(append(comments, issueComments...))
+}
+
+// checkIssueComment will attempt to address the latest comment in the conversation of an issue or pull request.
+func (p pullPalRepo) checkIssueComment(number int) error {
+	if p.commentsPaused() {
+		return nil
+	}
+
+	comment, ok, err := p.ghClient.GetOpenIssueComment(number, p.issueCommentOptions())
+	if err != nil {
+		p.log.Error("error getting issue comment", zap.Error(err))
+		return err
+	}
+	if !ok {
+		p.log.Debug("no comment to address", zap.Int("number", number))
+		return nil
+	}
+	return p.addressComments([]vc.Comment{comment})
+}
+
+// checkReview will attempt to address the comments submitted in a review of a pull request. If reviewID is 0, only the
+// comment with commentID is addressed.
+func (p pullPalRepo) checkReview(prNumber int, reviewID, commentID int64) error {
+	if p.commentsPaused() {
+		return nil
+	}
+
+	comments, err := p.ghClient.ListOpenReviewComments(prNumber, reviewID, commentID, vc.ListCommentOptions{
+		Handles: p.listIssueOptions.Handles,
+	})
+	if err != nil {
+		p.log.Error("error listing review comments", zap.Error(err))
+		return err
+	}
+	return p.addressComments(comments)
+}
+
+// commentsPaused returns true if spending has reached a budget, so comments cannot be addressed.
+func (p pullPalRepo) commentsPaused() bool {
+	err := p.usage.CheckBudget(p.fullName, p.globalBudget, p.budget)
+	if err != nil {
+		p.log.Warn("skipping pr comments", zap.Error(err))
+		return true
+	}
+	return false
+}
+
+// issueCommentOptions returns the options issue comments are listed with. Commands are addressed even in
+// conversations pull pal is not part of yet.
+func (p pullPalRepo) issueCommentOptions() vc.ListCommentOptions {
+	return vc.ListCommentOptions{
+		Handles:       p.listIssueOptions.Handles,
+		AlwaysInclude: HasCommand,
+	}
+}
 
+// addressComments addresses the first of comments, together with the comments submitted in the same review. Errors
+// are reported in reply to the comments.
+func (p pullPalRepo) addressComments(comments []vc.Comment) error {
 	if len(comments) == 0 {
 		p.log.Debug("no comments found")
-	} else {
-		p.log.Info("picked comment to process")
+		return nil
+	}
+
+	p.log.Info("picked comment to process")
 
-		comment := comments[0]
+	// comments submitted in the same review are addressed together
+	comment := comments[0]
+	handled := reviewComments(comments, comment)
+	var err error
+	if HasCommand(comment.Body) {
+		handled = []vc.Comment{comment}
+		err = p.handleCommands(comment)
+	} else if len(handled) > 1 {
+		p.log.Info("handling review", zap.Int("pr", comment.PRNumber), zap.Int64("review", comment.ReviewID), zap.Int("comments", len(handled)))
+		err = p.handleReview(handled)
+	} else {
+		handled = []vc.Comment{comment}
 		err = p.handleComment(comment)
-		if err != nil {
-			p.log.Error("error handling comment", zap.Error(err))
-			commentText := fmt.Sprintf("I ran into a problem working on this:\n```\n%s\n```", err.Error())
-			err = p.ghClient.RespondToComment(comment.PRNumber, comment.ID, commentText)
+	}
+	if err != nil {
+		p.log.Error("error handling comment", zap.Error(err))
+		commentText := fmt.Sprintf("I ran into a problem working on this:\n```\n%s\n```", err.Error())
+		for _, c := range handled {
+			err = p.respondToComment(c, commentText)
 			if err != nil {
 				p.log.Error("error commenting on thread with error", zap.Error(err))
 				return err
@@ -181,115 +672,482 @@ func (p pullPalRepo) checkIssuesAndComments() error {
 	return nil
 }
 
+// reviewComments returns the comments that were submitted in the same review as comment, including comment itself.
+// If comment is not a review comment, nil is returned.
+func reviewComments(comments []vc.Comment, comment vc.Comment) []vc.Comment {
+	if comment.Type != vc.CommentReview || comment.ReviewID == 0 {
+		return nil
+	}
+	review := []vc.Comment{}
+	for _, c := range comments {
+		if c.Type == vc.CommentReview && c.PRNumber == comment.PRNumber && c.ReviewID == comment.ReviewID {
+			review = append(review, c)
+		}
+	}
+	return review
+}
+
+// handleIssue works on an issue and opens or updates a pull request resolving it. Progress, including any error, is
+// reported on the issue.
 func (p *pullPalRepo) handleIssue(issue vc.Issue) (err error) {
+	progress := p.startProgress(issue)
+	defer func() {
+		if err != nil {
+			progress.fail(err)
+		}
+	}()
+
+	progress.start("Reading the issue")
 	// remove labels from issue so that it is not picked up again until labels are reapplied
 	for _, label := range p.listIssueOptions.Labels {
 		err = p.ghClient.RemoveLabelFromIssue(issue.Number, label)
 		if err != nil {
 			p.log.Error("error removing labels from issue", zap.Error(err))
-			return err
+			return fmt.Errorf("removing label %q: %w", label, err)
 		}
 	}
-
 	changeRequest, err := p.localGitClient.ParseIssueAndStartCommit(issue)
 	if err != nil {
 		p.log.Error("error parsing issue and starting commit", zap.Error(err))
 		return err
 	}
+	defer p.localGitClient.AbortCommit()
+	err = p.loadPrompts()
+	if err != nil {
+		return err
+	}
+	changeRequest.Prompts = p.prompts
+
+	// include files set with "/pullpal files"
+	changeRequest.Files, err = p.appendLocalFiles(changeRequest.Files, p.state.Issue(p.fullName, issue.Number).Files)
+	if err != nil {
+		return err
+	}
 
-	changeResponse, err := p.openAIClient.EvaluateCCR(p.ctx, "", changeRequest)
+	progress.start("Generating changes")
+	changeResponse, err := p.openAIClient.EvaluateCCR(p.ctx, p.modelsFor(issue.Number), changeRequest)
+	p.recordUsage(issue.Number, 0, changeResponse.Usage)
 	if err != nil {
 		return err
 	}
+	p.log.Info("generated code change", zap.String("repo", p.fullName), zap.Int("issue", issue.Number), zap.String("model", changeResponse.Model))
 
-	randomNumber := rand.Intn(100) + 1
-	newBranchName := fmt.Sprintf("fix-%d-%d", issue.Number, randomNumber)
-	for _, f := range changeResponse.Files {
-		p.log.Info("replacing or adding file", zap.String("path", f.Path), zap.String("contents", f.Contents))
-		err = p.localGitClient.ReplaceOrAddLocalFile(f)
+	if p.approval.required() {
+		return p.proposeChange(issue, progress, changeRequest, changeResponse)
+	}
+	return p.publishChange(issue, progress, changeRequest, changeResponse)
+}
+
+// startBranchCommit checks out branch from the remote, and starts a commit on it. AbortCommit should be deferred by the
+// caller once it succeeds.
+func (p *pullPalRepo) startBranchCommit(branch string) error {
+	p.log.Info("checking out branch", zap.String("name", branch))
+	err := p.localGitClient.StartCommit()
+	if err != nil {
+		return err
+	}
+	err = p.localGitClient.CheckoutRemoteBranch(branch)
+	if err != nil {
+		p.localGitClient.AbortCommit()
+		return err
+	}
+	return nil
+}
+
+// commitChange writes the files in a generated change to the local repository, and commits them.
+func (p *pullPalRepo) commitChange(req llm.CodeChangeRequest, res llm.CodeChangeResponse) error {
+	return commitResponse(p.log, p.localGitClient, req, res)
+}
+
+// commitResponse writes the files in the response to req to a local repository, and commits them.
+func commitResponse(log *zap.Logger, localGitClient *vc.LocalGitClient, req llm.CodeChangeRequest, res llm.CodeChangeResponse) error {
+	_, err := writeResponseFiles(log, localGitClient, req.Files, res.OmittedFiles, res.Files)
+	if err != nil {
+		return err
+	}
+
+	commitMessage := fmt.Sprintf("%s\n\n%s", req.Subject, res.Notes)
+	// local tasks have no issue to resolve
+	if req.IssueNumber != 0 {
+		commitMessage += fmt.Sprintf("\n\nResolves #%d", req.IssueNumber)
+	}
+	log.Info("about to create commit", zap.String("message", commitMessage))
+	return localGitClient.FinishCommit(commitMessage)
+}
+
+// writeResponseFiles writes the files changed by an llm response to the local repository, and returns the ones that
+// were written. The llm only saw the files in prompted, so changes to other existing files, and to files omitted from
+// the prompt to fit the context window, are skipped.
+func writeResponseFiles(log *zap.Logger, localGitClient *vc.LocalGitClient, prompted []llm.File, omitted []string, files []llm.File) ([]llm.File, error) {
+	seen := make(map[string]bool)
+	for _, f := range prompted {
+		seen[f.Path] = true
+	}
+	for _, path := range omitted {
+		delete(seen, path)
+	}
+	written := []llm.File{}
+	for _, f := range files {
+		if !seen[f.Path] {
+			existing, err := localGitClient.GetLocalFile(f.Path)
+			if err != nil {
+				return nil, err
+			}
+			if existing.Contents != "" {
+				log.Warn("skipping change to file omitted from prompt", zap.String("path", f.Path))
+				continue
+			}
+		}
+		log.Info("replacing or adding file", zap.String("path", f.Path), zap.String("contents", f.Contents))
+		err := localGitClient.ReplaceOrAddLocalFile(f)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		written = append(written, f)
 	}
+	return written, nil
+}
 
-	commitMessage := fmt.Sprintf("%s\n\n%s\n\nResolves #%d", changeRequest.Subject, changeResponse.Notes, changeRequest.IssueNumber)
-	p.log.Info("about to create commit", zap.String("message", commitMessage))
-	err = p.localGitClient.FinishCommit(commitMessage)
+// publishChange commits a generated change, pushes it, and opens or updates the pull request resolving issue. The
+// commit must already have been started, with the issue's base branch checked out.
+func (p *pullPalRepo) publishChange(issue vc.Issue, progress *issueProgress, changeRequest llm.CodeChangeRequest, changeResponse llm.CodeChangeResponse) (err error) {
+	branchName, existingPR, err := p.issueBranch(issue)
 	if err != nil {
 		return err
 	}
+	progress.start(fmt.Sprintf("Pushing the changes generated by `%s` to `%s`", changeResponse.Model, branchName))
 
-	p.log.Info("pushing to branch", zap.String("branchname", newBranchName))
-	err = p.localGitClient.PushBranch(newBranchName)
+	err = p.commitChange(changeRequest, changeResponse)
+	if err != nil {
+		return err
+	}
+
+	sha := p.headCommit()
+	p.log.Info("pushing to branch", zap.String("branchname", branchName))
+	err = p.localGitClient.PushBranch(branchName)
 	if err != nil {
 		p.log.Info("error pushing to branch", zap.Error(err))
 		return err
 	}
+	defer func() {
+		p.reportFailure(sha, err)
+	}()
 
-	// open code change request
-	_, url, err := p.ghClient.OpenCodeChangeRequest(changeRequest, changeResponse, newBranchName)
+	if p.verifyCommand != "" {
+		progress.start("Verifying the changes")
+	}
+	result, err := p.verify(sha)
 	if err != nil {
 		return err
 	}
-	p.log.Info("successfully created PR", zap.String("URL", url))
+
+	progress.start("Opening a pull request")
+	body, err := p.codeChangeBody(changeRequest, changeResponse, result)
+	if err != nil {
+		return err
+	}
+	message := "I opened %s to resolve this issue. Comment here or on the pull request if you would like me to change anything."
+	url := ""
+	if existingPR != nil {
+		// the branch was replaced with the new attempt, so the existing pull request only needs its description updated
+		err = p.ghClient.EditPullRequest(existingPR.Number, changeRequest.Subject, body)
+		if err != nil {
+			return err
+		}
+		url = existingPR.URL
+		message = "I worked on this issue again, and updated %s. Comment here or on the pull request if you would like me to change anything."
+		p.log.Info("successfully updated PR", zap.String("URL", url))
+	} else {
+		options := p.pullRequests.Merge(vc.ParseIssueBody(issue.Body).PullRequest).Options(issue)
+		_, url, err = p.ghClient.OpenCodeChangeRequest(changeRequest, body, branchName, options)
+		if err != nil {
+			return err
+		}
+		p.log.Info("successfully created PR", zap.String("URL", url))
+	}
+
+	// the status comment also makes pull pal follow the issue conversation, so the pull request can be refined from there
+	header := "✅ " + fmt.Sprintf(message, url)
+	if result != nil && !result.passed {
+		header += " Verification failed; see the pull request for details."
+	}
+	progress.finish(header)
 
 	return nil
 }
 
+// appendLocalFiles reads the files at paths from the local repository, and appends the ones not already in files.
+func (p *pullPalRepo) appendLocalFiles(files []llm.File, paths []string) ([]llm.File, error) {
+	for _, path := range paths {
+		included := false
+		for _, f := range files {
+			included = included || f.Path == path
+		}
+		if included {
+			continue
+		}
+		f, err := p.localGitClient.GetLocalFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// handleComment responds to a review comment, or a comment in the conversation of an issue or pull request.
 func (p *pullPalRepo) handleComment(comment vc.Comment) error {
-	if comment.Branch == "" {
-		return errors.New("no branch provided in comment")
+	if comment.Type != vc.CommentReview {
+		return p.handleIssueComment(comment)
+	}
+	return p.handleReviewComment(comment)
+}
+
+// respondToComment replies to a comment - in its review thread for review comments, or in the conversation otherwise.
+func (p *pullPalRepo) respondToComment(comment vc.Comment, text string) error {
+	if comment.Type == vc.CommentReview {
+		return p.ghClient.RespondToComment(comment.PRNumber, comment.ID, text)
 	}
+	return p.ghClient.CommentOnIssue(comment.IssueNumber, text)
+}
 
-	file, err := p.localGitClient.GetLocalFile(comment.FilePath)
+// threadComments converts comments to the format used in LLM requests.
+func (p *pullPalRepo) threadComments(comments []vc.Comment) []llm.ThreadComment {
+	thread := []llm.ThreadComment{}
+	for _, c := range comments {
+		thread = append(thread, llm.ThreadComment{
+			Author: c.Author.Handle,
+			Body:   c.Body,
+			Self:   c.Author.Handle == p.self.Handle,
+		})
+	}
+	return thread
+}
+
+// handleIssueComment replies to a comment in the conversation of an issue or pull request. If pull pal already opened
+// a pull request for the issue, and the comment asks for changes, the pull request is refined based on the comment.
+func (p *pullPalRepo) handleIssueComment(comment vc.Comment) error {
+	prs, err := p.commandPullRequests(comment, p.commentIssue(comment))
 	if err != nil {
 		return err
 	}
 
-	diffCommentRequest := llm.DiffCommentRequest{
-		File:     file,
-		Contents: comment.Body,
-		Diff:     comment.DiffHunk,
-		PRNumber: comment.PRNumber,
+	req := llm.IssueCommentRequest{
+		Prompts:     p.prompts,
+		Number:      comment.IssueNumber,
+		PullRequest: comment.Type == vc.CommentPullRequest,
+		CanChange:   len(prs) > 0,
+		Subject:     comment.Issue.Subject,
+		Body:        comment.Issue.Body,
+		Contents:    comment.Body,
+		Thread:      p.threadComments(comment.Thread),
 	}
-	p.log.Info("diff comment request", zap.String("req", diffCommentRequest.String()))
 
-	diffCommentResponse, err := p.openAIClient.EvaluateDiffComment(p.ctx, "", diffCommentRequest)
+	res, err := p.openAIClient.EvaluateIssueComment(p.ctx, p.modelsFor(p.commentIssue(comment)), req)
+	if comment.Type == vc.CommentIssue {
+		p.recordUsage(comment.IssueNumber, 0, res.Usage)
+	} else {
+		p.recordUsage(0, comment.PRNumber, res.Usage)
+	}
 	if err != nil {
 		return err
 	}
+	p.log.Info("generated issue comment response", zap.String("repo", p.fullName), zap.Int("issue", comment.IssueNumber), zap.String("model", res.Model), zap.Bool("change requested", res.ChangeRequested))
 
-	if diffCommentResponse.Type == llm.ResponseCodeChange {
-		p.log.Info("about to start commit")
-		err = p.localGitClient.StartCommit()
+	response := res.Response
+	if res.ChangeRequested && len(prs) > 0 {
+		refinement, err := p.refinePullRequest(comment, prs[0])
 		if err != nil {
 			return err
 		}
-		p.log.Info("checking out branch", zap.String("name", comment.Branch))
-		err = p.localGitClient.CheckoutRemoteBranch(comment.Branch)
+		response = strings.TrimSpace(response + "\n\n" + refinement)
+	}
+
+	err = p.respondToComment(comment, response)
+	if err != nil {
+		p.log.Error("error commenting on issue", zap.Error(err))
+		return err
+	}
+
+	p.log.Info("responded to issue comment")
+
+	return nil
+}
+
+// refinePullRequest runs the code change flow again for the issue resolved by pr, with the changes already made and
+// the conversation so far in the prompt. Any new changes are pushed to the existing pull request branch, and a reply
+// describing them is returned.
+func (p *pullPalRepo) refinePullRequest(comment vc.Comment, pr vc.PullRequest) (string, error) {
+	var err error
+	issueNumber := resolvedIssue(pr.Body)
+	issue := vc.Issue{Number: issueNumber, Subject: pr.Subject, Body: pullRequestDescription(pr.Body)}
+	if issueNumber != 0 {
+		issue, err = p.ghClient.GetIssue(issueNumber)
 		if err != nil {
-			return err
+			return "", err
 		}
-		p.log.Info("replacing or adding file", zap.String("path", diffCommentResponse.File.Path), zap.String("contents", diffCommentResponse.File.Contents))
-		err = p.localGitClient.ReplaceOrAddLocalFile(diffCommentResponse.File)
+	}
+	issueBody := vc.ParseIssueBody(issue.Body)
+
+	diff, err := p.ghClient.GetPullRequestDiff(pr.Number)
+	if err != nil {
+		return "", err
+	}
+	prFiles, err := p.ghClient.ListPullRequestFiles(pr.Number)
+	if err != nil {
+		return "", err
+	}
+
+	err = p.startBranchCommit(pr.Branch)
+	if err != nil {
+		return "", err
+	}
+	defer p.localGitClient.AbortCommit()
+	err = p.loadPrompts()
+	if err != nil {
+		return "", err
+	}
+
+	// the files listed in the issue and the comment, files set with "/pullpal files", and the files already changed
+	commentBody := vc.ParseIssueBody(comment.Body)
+	paths := append(append(append([]string{}, issueBody.FilePaths...), commentBody.FilePaths...), p.state.Issue(p.fullName, issueNumber).Files...)
+	files, err := p.appendLocalFiles(nil, append(paths, prFiles...))
+	if err != nil {
+		return "", err
+	}
+
+	req := llm.CodeChangeRequest{
+		Prompts:      p.prompts,
+		Subject:      issue.Subject,
+		Body:         issueBody.PromptBody,
+		IssueNumber:  issueNumber,
+		Files:        files,
+		BaseBranch:   pr.BaseBranch,
+		PreviousDiff: diff,
+		Feedback:     p.threadComments(append(append([]vc.Comment{}, comment.Thread...), comment)),
+	}
+
+	res, err := p.openAIClient.EvaluateCCR(p.ctx, p.modelsFor(issueNumber), req)
+	p.recordUsage(issueNumber, pr.Number, res.Usage)
+	if err != nil {
+		return "", err
+	}
+	p.log.Info("generated pull request refinement", zap.String("repo", p.fullName), zap.Int("pr", pr.Number), zap.String("model", res.Model))
+
+	changed, err := writeResponseFiles(p.log, p.localGitClient, req.Files, res.OmittedFiles, res.Files)
+	if err != nil {
+		return "", err
+	}
+
+	response := res.Notes
+	if len(changed) > 0 {
+		commitMessage := strings.TrimSpace(fmt.Sprintf("update based on feedback\n\n%s", res.Notes))
+		note, err := p.publishBranchChange(issueNumber, pr.Number, pr.Branch, commitMessage, res.Model, changed)
 		if err != nil {
-			return err
+			return "", err
+		}
+		if note != "" {
+			response = note + "\n\n" + res.Notes
+		} else if comment.Type == vc.CommentIssue {
+			response = fmt.Sprintf("I updated %s.\n\n%s", pr.URL, res.Notes)
 		}
+	}
+
+	p.log.Info("refined pull request", zap.Int("pr", pr.Number))
+
+	return strings.TrimSpace(response), nil
+}
 
-		commitMessage := "update based on comment"
-		p.log.Info("about to create commit", zap.String("message", commitMessage))
-		err = p.localGitClient.FinishCommit(commitMessage)
+// detailsPattern matches collapsed sections of a pull request body, such as the prompt the changes were generated from.
+var detailsPattern = regexp.MustCompile(`(?s)<details>.*?</details>`)
+
+// pullRequestDescription returns the body of a pull request without its collapsed sections, so that a pull request
+// that does not resolve an issue can be described to the llm without the prompt it was generated from.
+func pullRequestDescription(body string) string {
+	return strings.TrimSpace(detailsPattern.ReplaceAllString(body, ""))
+}
+
+// handleReviewComment addresses a review comment, either by answering it or by changing the commented file.
+func (p *pullPalRepo) handleReviewComment(comment vc.Comment) (err error) {
+	if comment.Branch == "" {
+		return errors.New("no branch provided in comment")
+	}
+
+	err = p.startBranchCommit(comment.Branch)
+	if err != nil {
+		return err
+	}
+	defer p.localGitClient.AbortCommit()
+	err = p.loadPrompts()
+	if err != nil {
+		return err
+	}
+
+	file, err := p.localGitClient.GetLocalFile(comment.FilePath)
+	if err != nil {
+		return err
+	}
+
+	// files listed in the comment, and the other files changed by the pull request, may also need to change. Only the
+	// files are taken from the comment's settings, since "---" may also be part of the comment itself (e.g. a markdown
+	// rule), and the whole comment is the prompt.
+	commentFiles := vc.ParseIssueBody(comment.Body).FilePaths
+	prFiles, err := p.ghClient.ListPullRequestFiles(comment.PRNumber)
+	if err != nil {
+		return err
+	}
+	contextFiles := []llm.File{}
+	seen := map[string]bool{comment.FilePath: true}
+	for _, path := range append(commentFiles, prFiles...) {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		f, err := p.localGitClient.GetLocalFile(path)
 		if err != nil {
 			return err
 		}
+		contextFiles = append(contextFiles, f)
+	}
+
+	diffCommentRequest := llm.DiffCommentRequest{
+		Prompts:      p.prompts,
+		File:         file,
+		Contents:     strings.TrimSpace(comment.Body),
+		Diff:         comment.DiffHunk,
+		PRNumber:     comment.PRNumber,
+		ContextFiles: contextFiles,
+		Thread:       p.threadComments(comment.Thread),
+	}
+	p.log.Info("diff comment request", zap.String("req", diffCommentRequest.String()))
+
+	diffCommentResponse, err := p.openAIClient.EvaluateDiffComment(p.ctx, p.modelsFor(p.commentIssue(comment)), diffCommentRequest)
+	p.recordUsage(0, comment.PRNumber, diffCommentResponse.Usage)
+	if err != nil {
+		return err
+	}
+	p.log.Info("generated comment response", zap.String("repo", p.fullName), zap.Int("pr", comment.PRNumber), zap.String("model", diffCommentResponse.Model))
 
-		err = p.localGitClient.PushBranch(comment.Branch)
+	response := diffCommentResponse.Response
+	changedFiles := []llm.File{}
+	if diffCommentResponse.Type == llm.ResponseCodeChange {
+		prompted := append([]llm.File{file}, contextFiles...)
+		changedFiles, err = writeResponseFiles(p.log, p.localGitClient, prompted, nil, diffCommentResponse.ChangedFiles())
+		if err != nil {
+			return err
+		}
+	}
+	if len(changedFiles) > 0 {
+		note, err := p.publishBranchChange(p.commentIssue(comment), comment.PRNumber, comment.Branch, "update based on comment", diffCommentResponse.Model, changedFiles)
 		if err != nil {
 			return err
 		}
+		if note != "" {
+			response = strings.TrimSpace(response + "\n\n" + note)
+		}
 	}
 
-	err = p.ghClient.RespondToComment(comment.PRNumber, comment.ID, diffCommentResponse.Response)
+	err = p.respondToComment(comment, response)
 	if err != nil {
 		p.log.Error("error commenting on issue", zap.Error(err))
 		return err
@@ -299,3 +1157,135 @@ func (p *pullPalRepo) handleComment(comment vc.Comment) error {
 
 	return nil
 }
+
+// loadPrompts loads the prompt templates again, so that templates committed to the branch that was just checked out are
+// used.
+func (p *pullPalRepo) loadPrompts() error {
+	prompts, err := llm.LoadPrompts(p.promptDirs...)
+	if err != nil {
+		return fmt.Errorf("loading prompt templates: %w", err)
+	}
+	p.prompts = prompts
+	return nil
+}
+
+// recordUsage records LLM usage for an issue or pull request, and logs any errors.
+func (p *pullPalRepo) recordUsage(issue, pr int, usage []llm.Usage) {
+	err := p.usage.Record(p.fullName, issue, pr, usage)
+	if err != nil {
+		p.log.Error("error recording llm usage", zap.Error(err))
+	}
+}
+
+// codeChangeBody renders the body of a pull request resolving an issue with the repository's pull request template.
+func (p *pullPalRepo) codeChangeBody(req llm.CodeChangeRequest, res llm.CodeChangeResponse, result *verification) (string, error) {
+	body := llm.NewPullRequestBody(req, res)
+	if result != nil {
+		body.Verification = result.String()
+	}
+
+	totals := p.usage.Totals(func(r UsageRecord) bool {
+		return strings.EqualFold(r.Repo, p.fullName) && r.Issue == req.IssueNumber
+	})
+	body.Usage = llm.UsageSummary{
+		Requests:         totals.Requests,
+		PromptTokens:     totals.PromptTokens,
+		CompletionTokens: totals.CompletionTokens,
+		Cost:             totals.Cost,
+	}
+
+	return p.prompts.RenderPullRequestBody(body)
+}
+
+// handleReview addresses every comment in a pull request review with a single LLM request. Any changes are made in a
+// single commit, and every comment is replied to in its thread.
+func (p *pullPalRepo) handleReview(comments []vc.Comment) (err error) {
+	first := comments[0]
+	if first.Branch == "" {
+		return errors.New("no branch provided in comment")
+	}
+
+	review, err := p.ghClient.GetReview(first.PRNumber, first.ReviewID)
+	if err != nil {
+		return err
+	}
+
+	err = p.startBranchCommit(first.Branch)
+	if err != nil {
+		return err
+	}
+	defer p.localGitClient.AbortCommit()
+	err = p.loadPrompts()
+	if err != nil {
+		return err
+	}
+
+	req := llm.ReviewRequest{
+		Prompts:  p.prompts,
+		PRNumber: first.PRNumber,
+		Body:     review.Body,
+	}
+	read := make(map[string]bool)
+	for i, c := range comments {
+		if !read[c.FilePath] {
+			read[c.FilePath] = true
+			file, err := p.localGitClient.GetLocalFile(c.FilePath)
+			if err != nil {
+				return err
+			}
+			req.Files = append(req.Files, file)
+		}
+		req.Comments = append(req.Comments, llm.ReviewComment{
+			Number:   i + 1,
+			Author:   c.Author.Handle,
+			FilePath: c.FilePath,
+			Diff:     c.DiffHunk,
+			Contents: c.Body,
+			Thread:   p.threadComments(c.Thread),
+		})
+	}
+
+	res, err := p.openAIClient.EvaluateReview(p.ctx, p.modelsFor(p.commentIssue(first)), req)
+	p.recordUsage(0, first.PRNumber, res.Usage)
+	if err != nil {
+		return err
+	}
+	p.log.Info("generated review response", zap.String("repo", p.fullName), zap.Int("pr", first.PRNumber), zap.String("model", res.Model))
+
+	changed, err := writeResponseFiles(p.log, p.localGitClient, req.Files, nil, res.Files)
+	if err != nil {
+		return err
+	}
+
+	note := ""
+	if len(changed) > 0 {
+		commitMessage := strings.TrimSpace(fmt.Sprintf("address review comments\n\n%s", review.Body))
+		note, err = p.publishBranchChange(p.commentIssue(first), first.PRNumber, first.Branch, commitMessage, res.Model, changed)
+		if err != nil {
+			return err
+		}
+	}
+
+	responses := make(map[int]string)
+	for _, r := range res.Responses {
+		responses[r.Comment] = r.Response
+	}
+	for i, c := range comments {
+		response, ok := responses[i+1]
+		if !ok {
+			response = "I addressed the comments in this review together, but did not have a specific response to this one."
+		}
+		if i == 0 && note != "" {
+			response = strings.TrimSpace(response + "\n\n" + note)
+		}
+		err = p.respondToComment(c, response)
+		if err != nil {
+			p.log.Error("error responding to review comment", zap.Error(err))
+			return err
+		}
+	}
+
+	p.log.Info("responded to review")
+
+	return nil
+}
@@ -110,7 +110,8 @@ func (p *PullPal) DebugLLM() error {
 		p.log.Info("testing with openai api", zap.String("MODEL", m))
 
 		p.log.Info("testing code change request")
-		res, err := p.openAIClient.EvaluateCCR(p.ctx, m, codeChangeRequest)
+		models := []llm.Model{{Name: m}}
+		res, err := p.openAIClient.EvaluateCCR(p.ctx, models, codeChangeRequest)
 		if err != nil {
 			p.log.Error("error evaluating code change request for model", zap.Error(err))
 			continue
@@ -118,7 +119,7 @@ func (p *PullPal) DebugLLM() error {
 		p.log.Info("openai api response", zap.String("model", m), zap.String("response", res.String()))
 
 		p.log.Info("testing diff comment code change request")
-		diffRes, err := p.openAIClient.EvaluateDiffComment(p.ctx, m, diffCommentRequestChange)
+		diffRes, err := p.openAIClient.EvaluateDiffComment(p.ctx, models, diffCommentRequestChange)
 		if err != nil {
 			p.log.Error("error evaluating diff comment request for model", zap.Error(err))
 			continue
@@ -126,7 +127,7 @@ func (p *PullPal) DebugLLM() error {
 		p.log.Info("openai api response", zap.String("model", m), zap.String("response", diffRes.String()))
 
 		p.log.Info("testing diff comment question request")
-		diffRes, err = p.openAIClient.EvaluateDiffComment(p.ctx, m, diffCommentRequestQuestion)
+		diffRes, err = p.openAIClient.EvaluateDiffComment(p.ctx, models, diffCommentRequestQuestion)
 		if err != nil {
 			p.log.Error("error evaluating diff comment request for model", zap.Error(err))
 			continue
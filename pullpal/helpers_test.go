@@ -0,0 +1,416 @@
+package pullpal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeGithubClient is an in-memory githubClient. It returns the issues, pull requests and comments it is set up with,
+// and records the comments, labels and pull requests pull pal creates.
+type fakeGithubClient struct {
+	issues        map[int]vc.Issue
+	pullRequests  []vc.PullRequest
+	reviews       map[int64]vc.Review
+	diffs         map[int]string
+	prFiles       map[int][]string
+	comments      []vc.Comment
+	issueComments []vc.Comment
+	// branches contains the branches that exist without an open pull request.
+	branches []string
+
+	// removeLabelErrs contains errors returned when removing specific labels.
+	removeLabelErrs map[string]error
+
+	lastID int64
+	// issueBodies contains the body of every comment created on each issue, including edits to status comments.
+	issueBodies map[int][]string
+	// commentIssues maps the ID of each created comment to the issue it was created on.
+	commentIssues map[int64]int
+	labels        map[int]map[string]bool
+	reactions     map[int][]string
+	replies       map[int64][]string
+	opened        []vc.PullRequest
+	statuses      map[string][]vc.Status
+	// closed and updated contain the numbers of the pull requests closed, and brought up to date with their base.
+	closed  []int
+	updated []int
+}
+
+func newFakeGithubClient() *fakeGithubClient {
+	return &fakeGithubClient{
+		issues:          make(map[int]vc.Issue),
+		reviews:         make(map[int64]vc.Review),
+		diffs:           make(map[int]string),
+		prFiles:         make(map[int][]string),
+		removeLabelErrs: make(map[string]error),
+		issueBodies:     make(map[int][]string),
+		commentIssues:   make(map[int64]int),
+		labels:          make(map[int]map[string]bool),
+		reactions:       make(map[int][]string),
+		replies:         make(map[int64][]string),
+		statuses:        make(map[string][]vc.Status),
+	}
+}
+
+// lastComment returns the latest body of the last comment created on an issue, or an empty string if there is none.
+func (f *fakeGithubClient) lastComment(issue int) string {
+	bodies := f.issueBodies[issue]
+	if len(bodies) == 0 {
+		return ""
+	}
+	return bodies[len(bodies)-1]
+}
+
+// issueLabels returns the labels applied to an issue, sorted.
+func (f *fakeGithubClient) issueLabels(issue int) []string {
+	labels := []string{}
+	for label := range f.labels[issue] {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (f *fakeGithubClient) ListOpenIssues(options vc.ListIssueOptions) ([]vc.Issue, error) {
+	issues := []vc.Issue{}
+	for _, issue := range f.issues {
+		issues = append(issues, issue)
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Number < issues[j].Number })
+	return issues, nil
+}
+
+func (f *fakeGithubClient) GetIssue(number int) (vc.Issue, error) {
+	issue, ok := f.issues[number]
+	if !ok {
+		return vc.Issue{}, fmt.Errorf("issue %d not found", number)
+	}
+	return issue, nil
+}
+
+func (f *fakeGithubClient) CommentOnIssue(issueNumber int, comment string) error {
+	_, err := f.CreateIssueComment(issueNumber, comment)
+	return err
+}
+
+func (f *fakeGithubClient) CreateIssueComment(issueNumber int, body string) (int64, error) {
+	f.lastID++
+	f.commentIssues[f.lastID] = issueNumber
+	f.issueBodies[issueNumber] = append(f.issueBodies[issueNumber], body)
+	return f.lastID, nil
+}
+
+func (f *fakeGithubClient) EditIssueComment(commentID int64, body string) error {
+	issue, ok := f.commentIssues[commentID]
+	if !ok {
+		return fmt.Errorf("comment %d not found", commentID)
+	}
+	// edits are only made to the latest status comment in these tests
+	f.issueBodies[issue][len(f.issueBodies[issue])-1] = body
+	return nil
+}
+
+func (f *fakeGithubClient) AddReactionToIssue(issueNumber int, content string) error {
+	f.reactions[issueNumber] = append(f.reactions[issueNumber], content)
+	return nil
+}
+
+func (f *fakeGithubClient) AddLabelsToIssue(issueNumber int, labels []string) error {
+	if f.labels[issueNumber] == nil {
+		f.labels[issueNumber] = make(map[string]bool)
+	}
+	for _, label := range labels {
+		f.labels[issueNumber][label] = true
+	}
+	return nil
+}
+
+func (f *fakeGithubClient) RemoveLabelFromIssue(issueNumber int, label string) error {
+	if err := f.removeLabelErrs[label]; err != nil {
+		return err
+	}
+	delete(f.labels[issueNumber], label)
+	return nil
+}
+
+func (f *fakeGithubClient) ListOpenComments(options vc.ListCommentOptions) ([]vc.Comment, error) {
+	return f.comments, nil
+}
+
+func (f *fakeGithubClient) ListOpenIssueComments(options vc.ListCommentOptions) ([]vc.Comment, error) {
+	return f.issueComments, nil
+}
+
+func (f *fakeGithubClient) ListOpenReviewComments(prNumber int, reviewID, commentID int64, options vc.ListCommentOptions) ([]vc.Comment, error) {
+	comments := []vc.Comment{}
+	for _, c := range f.comments {
+		if c.PRNumber == prNumber && ((reviewID != 0 && c.ReviewID == reviewID) || (reviewID == 0 && c.ID == commentID)) {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (f *fakeGithubClient) GetOpenIssueComment(number int, options vc.ListCommentOptions) (vc.Comment, bool, error) {
+	for i := len(f.issueComments) - 1; i >= 0; i-- {
+		if f.issueComments[i].IssueNumber == number {
+			return f.issueComments[i], true, nil
+		}
+	}
+	return vc.Comment{}, false, nil
+}
+
+func (f *fakeGithubClient) GetReviewComment(id int64, options vc.ListCommentOptions) (vc.Comment, error) {
+	return vc.Comment{}, fmt.Errorf("comment %d not found", id)
+}
+
+func (f *fakeGithubClient) GetReview(prNumber int, reviewID int64) (vc.Review, error) {
+	review, ok := f.reviews[reviewID]
+	if !ok {
+		return vc.Review{}, fmt.Errorf("review %d not found", reviewID)
+	}
+	return review, nil
+}
+
+func (f *fakeGithubClient) RespondToComment(prNumber int, commentID int64, comment string) error {
+	f.replies[commentID] = append(f.replies[commentID], comment)
+	return nil
+}
+
+func (f *fakeGithubClient) OpenCodeChangeRequest(req llm.CodeChangeRequest, body, fromBranch string, options vc.PullRequestOptions) (id, url string, err error) {
+	number := 100 + len(f.opened)
+	pr := vc.PullRequest{
+		Number:     number,
+		Subject:    req.Subject,
+		Body:       body,
+		URL:        fmt.Sprintf("https://github.com/owner/repo/pull/%d", number),
+		Branch:     fromBranch,
+		BaseBranch: req.BaseBranch,
+	}
+	f.opened = append(f.opened, pr)
+	f.pullRequests = append(f.pullRequests, pr)
+	return fmt.Sprint(number), pr.URL, nil
+}
+
+func (f *fakeGithubClient) ListOwnPullRequests() ([]vc.PullRequest, error) {
+	return f.pullRequests, nil
+}
+
+func (f *fakeGithubClient) EditPullRequest(number int, title, body string) error {
+	return nil
+}
+
+func (f *fakeGithubClient) ClosePullRequest(number int) error {
+	f.closed = append(f.closed, number)
+	return nil
+}
+
+func (f *fakeGithubClient) UpdatePullRequestBranch(number int) error {
+	f.updated = append(f.updated, number)
+	return nil
+}
+
+func (f *fakeGithubClient) GetPullRequestDiff(number int) (string, error) {
+	return f.diffs[number], nil
+}
+
+func (f *fakeGithubClient) ListPullRequestFiles(prNumber int) ([]string, error) {
+	return f.prFiles[prNumber], nil
+}
+
+func (f *fakeGithubClient) BranchExists(name string) (bool, error) {
+	for _, pr := range f.pullRequests {
+		if pr.Branch == name {
+			return true, nil
+		}
+	}
+	for _, branch := range f.branches {
+		if branch == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeGithubClient) SetStatus(sha string, status vc.Status, checks bool) error {
+	f.statuses[sha] = append(f.statuses[sha], status)
+	return nil
+}
+
+// newTestRepo returns a repository acting as "bot" that uses gh, keeps state in memory, and uses the default prompts
+// and lifecycle labels.
+func newTestRepo(t *testing.T, gh *fakeGithubClient) *pullPalRepo {
+	state, err := NewStateStore("")
+	require.NoError(t, err)
+	usage, err := NewUsageTracker("", nil)
+	require.NoError(t, err)
+	branchNamer, err := NewBranchNamer("")
+	require.NoError(t, err)
+	return &pullPalRepo{
+		ctx:             context.Background(),
+		log:             zap.NewNop(),
+		fullName:        "owner/repo",
+		self:            vc.Author{Handle: "bot"},
+		prompts:         llm.DefaultPrompts(),
+		usage:           usage,
+		state:           state,
+		existingBranch:  ExistingBranchUpdate,
+		statusType:      StatusTypeStatuses,
+		lifecycleLabels: DefaultLifecycleLabels,
+		branchNamer:     branchNamer,
+		ghClient:        gh,
+	}
+}
+
+// fakeModel is a model served by a fake OpenAI-compatible API, which responds to each request with the next of its
+// responses, and records the contents of the messages it receives.
+type fakeModel struct {
+	mu        sync.Mutex
+	responses []string
+	prompts   []string
+}
+
+// newFakeModel starts a fake API that responds with responses in order, and returns the model it serves, which is
+// asked to respond in YAML.
+func newFakeModel(t *testing.T, responses ...string) (*fakeModel, llm.Model) {
+	f := &fakeModel{responses: responses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		prompt := ""
+		for _, m := range req.Messages {
+			prompt += m.Content + "\n"
+		}
+		f.prompts = append(f.prompts, prompt)
+		if len(f.responses) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"unexpected request"}}`)
+			return
+		}
+		content := f.responses[0]
+		f.responses = f.responses[1:]
+		require.NoError(t, json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}}},
+			Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 5},
+		}))
+	}))
+	t.Cleanup(server.Close)
+
+	return f, llm.Model{Name: "test-model", BaseURL: server.URL, Output: llm.OutputYAML}
+}
+
+// requests returns the number of requests the model received.
+func (f *fakeModel) requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.prompts)
+}
+
+// useModel makes p send every LLM request to model.
+func useModel(p *pullPalRepo, model llm.Model) {
+	p.models = []llm.Model{model}
+	p.openAIClient = llm.NewOpenAIClient(zap.NewNop(), nil, "token", "", llm.RetryConfig{})
+}
+
+// testGitRepo is a local repository whose "origin" branches are set directly, instead of being fetched.
+type testGitRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+// newTestGitRepo creates a repository with files committed on main, and with main also fetched from origin.
+func newTestGitRepo(t *testing.T, files map[string]string) *testGitRepo {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+
+	r := &testGitRepo{t: t, dir: dir, repo: repo}
+	r.setRemoteBranch("main", r.commit(files))
+	return r
+}
+
+// commit writes files to the checked out branch and commits them, and returns the commit.
+func (r *testGitRepo) commit(files map[string]string) string {
+	worktree, err := r.repo.Worktree()
+	require.NoError(r.t, err)
+	for path, contents := range files {
+		fullPath := filepath.Join(r.dir, path)
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(r.t, os.WriteFile(fullPath, []byte(contents), 0644))
+		_, err = worktree.Add(path)
+		require.NoError(r.t, err)
+	}
+	hash, err := worktree.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "someone", Email: "someone@example.com", When: time.Now()},
+	})
+	require.NoError(r.t, err)
+	return hash.String()
+}
+
+// commitOn checks out a local branch, commits files to it, and points the branch of origin at the new commit.
+func (r *testGitRepo) commitOn(branch string, files map[string]string) {
+	worktree, err := r.repo.Worktree()
+	require.NoError(r.t, err)
+	require.NoError(r.t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true}))
+	r.setRemoteBranch(branch, r.commit(files))
+}
+
+// setRemoteBranch points a branch of origin, and the local branch with the same name, at a commit.
+func (r *testGitRepo) setRemoteBranch(name, sha string) {
+	hash := plumbing.NewHash(sha)
+	require.NoError(r.t, r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", name), hash)))
+	require.NoError(r.t, r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash)))
+}
+
+// branchFile returns the contents of a file on a local branch, e.g. a branch pull pal pushed, or false if the branch
+// or file does not exist.
+func (r *testGitRepo) branchFile(branch, path string) (string, bool) {
+	ref, err := r.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", false
+	}
+	commit, err := r.repo.CommitObject(ref.Hash())
+	require.NoError(r.t, err)
+	file, err := commit.File(path)
+	if err != nil {
+		return "", false
+	}
+	contents, err := file.Contents()
+	require.NoError(r.t, err)
+	return contents, true
+}
+
+// client returns a client that works on the repository as a clone, checking out base branches from origin, and
+// records pushes instead of making them. Pushed branches are still set locally.
+func (r *testGitRepo) client() *vc.LocalGitClient {
+	repo := vc.Repository{LocalPath: r.dir, Name: "repo", Owner: vc.Author{Handle: "owner"}}
+	client, err := vc.OpenClonedGitClient(zap.NewNop(), vc.Author{Handle: "bot", Email: "bot@example.com"}, repo, "")
+	require.NoError(r.t, err)
+	client.SetDryRun(vc.NewDryRun("", io.Discard))
+	return client
+}
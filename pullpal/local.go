@@ -0,0 +1,209 @@
+package pullpal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// LocalConfig configures working on a task described in a local markdown file, in a repository that is already checked
+// out locally. No Github client is used.
+type LocalConfig struct {
+	// RepoPath is the path of the local repository to change.
+	RepoPath string
+	// Self is the author of the commit.
+	Self vc.Author
+	// Models is the chain of models to use. If a model fails, the next one is tried.
+	Models      []llm.Model
+	LLMRetry    llm.RetryConfig
+	OpenAIToken string
+	DebugDir    string
+	// PromptsDir is a directory containing prompt templates that override the default templates. Templates committed to
+	// the repository itself, in .pullpal/prompts, take precedence over these.
+	PromptsDir string
+	// Branch is the name of the branch the change is committed to. If empty, a name is generated from the task's subject.
+	Branch string
+	// PatchDir is a directory to save the change in as a patch. If set, the branch is deleted after the patch is saved.
+	PatchDir string
+	// StateDir is the directory LLM usage is recorded in. Usage is not recorded if empty.
+	StateDir string
+	// Prices overrides the prices used to compute the cost of LLM usage.
+	Prices []Price
+}
+
+// LocalResult describes the change made for a local task.
+type LocalResult struct {
+	// Branch is the branch the change was committed to. It is empty if the change was saved as a patch.
+	Branch string
+	// PatchPath is the path the change was saved to as a patch, if it was saved.
+	PatchPath string
+	Model     string
+	Summary   string
+	Notes     string
+}
+
+// ParseLocalTask returns the task in a markdown file as an issue. If the file starts with a "# " heading, the heading is
+// the subject of the issue, and the rest of the file is its body. Otherwise, the subject is derived from the file name.
+// The body uses the same syntax as issue bodies, including the "---" separated settings such as files and base.
+func ParseLocalTask(name, contents string) vc.Issue {
+	contents = strings.TrimSpace(contents)
+	first, rest, _ := strings.Cut(contents, "\n")
+	if strings.HasPrefix(first, "# ") {
+		return vc.Issue{
+			Subject: strings.TrimSpace(strings.TrimPrefix(first, "# ")),
+			Body:    strings.TrimSpace(rest),
+		}
+	}
+	subject := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	subject = strings.NewReplacer("-", " ", "_", " ").Replace(subject)
+	return vc.Issue{
+		Subject: subject,
+		Body:    contents,
+	}
+}
+
+// LocalRequest returns the code change request for the task in the markdown file at taskPath, with the files it
+// mentions read from the repository at repoPath as it is, including uncommitted changes. Files that do not exist are
+// included as empty files, as they are when working on issues.
+func LocalRequest(taskPath, repoPath string) (llm.CodeChangeRequest, error) {
+	contents, err := os.ReadFile(taskPath)
+	if err != nil {
+		return llm.CodeChangeRequest{}, err
+	}
+	issue := ParseLocalTask(taskPath, string(contents))
+	issueBody := vc.ParseIssueBody(issue.Body)
+
+	req := llm.CodeChangeRequest{
+		Subject:    issue.Subject,
+		Body:       issueBody.PromptBody,
+		BaseBranch: issueBody.BaseBranch,
+	}
+	for _, path := range issueBody.FilePaths {
+		data, err := os.ReadFile(filepath.Join(repoPath, path))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return llm.CodeChangeRequest{}, err
+		}
+		req.Files = append(req.Files, llm.File{Path: path, Contents: string(data)})
+	}
+	return req, nil
+}
+
+// RunLocalTask generates a change for the task in the markdown file at taskPath, and commits it to a new branch in the
+// local repository, or saves it as a patch. The change is based on the branch that is checked out, unless the task sets
+// a base branch. If the change is saved as a patch, the branch that was checked out is checked out again afterwards.
+func RunLocalTask(ctx context.Context, log *zap.Logger, cfg LocalConfig, taskPath string) (LocalResult, error) {
+	contents, err := os.ReadFile(taskPath)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	issue := ParseLocalTask(taskPath, string(contents))
+
+	localGitClient, err := vc.OpenLocalGitClient(log.Named("gitclient"), cfg.Self, cfg.RepoPath, cfg.DebugDir)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	usagePath := ""
+	if cfg.StateDir != "" {
+		usagePath = filepath.Join(cfg.StateDir, "usage.jsonl")
+	}
+	usage, err := NewUsageTracker(usagePath, cfg.Prices)
+	if err != nil {
+		return LocalResult{}, fmt.Errorf("loading usage: %w", err)
+	}
+
+	// the branch the user had checked out is checked out again if the change is saved as a patch
+	original, err := localGitClient.CurrentBranch()
+	if err != nil {
+		log.Warn("could not determine the current branch", zap.Error(err))
+	}
+	req, err := localGitClient.ParseIssueAndStartCommit(issue)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	defer localGitClient.AbortCommit()
+	// templates committed to the repository are read from the base branch
+	req.Prompts, err = llm.LoadPrompts(cfg.PromptsDir, filepath.Join(cfg.RepoPath, ".pullpal", "prompts"))
+	if err != nil {
+		return LocalResult{}, fmt.Errorf("loading prompt templates: %w", err)
+	}
+
+	openAIClient := llm.NewOpenAIClient(log.Named("openaiClient"), cfg.Models, cfg.OpenAIToken, cfg.DebugDir, cfg.LLMRetry)
+	res, err := openAIClient.EvaluateCCR(ctx, nil, req)
+	// usage is recorded whether or not a change was generated, since failed requests can cost money too
+	if usageErr := usage.Record("local/"+filepath.Base(cfg.RepoPath), 0, 0, res.Usage); usageErr != nil {
+		log.Error("error recording llm usage", zap.Error(usageErr))
+	}
+	if err != nil {
+		return LocalResult{}, err
+	}
+	log.Info("generated code change", zap.String("task", taskPath), zap.String("model", res.Model))
+
+	// the branch is only created once there is a change to commit to it
+	branch := cfg.Branch
+	if branch == "" {
+		branch = localBranch(localGitClient, issue.Subject)
+	}
+	err = localGitClient.CreateBranch(branch)
+	if err != nil {
+		return LocalResult{}, fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	err = commitResponse(log, localGitClient, req, res)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	result := LocalResult{
+		Branch:  branch,
+		Model:   res.Model,
+		Summary: res.Summary,
+		Notes:   res.Notes,
+	}
+	if cfg.PatchDir == "" {
+		return result, nil
+	}
+
+	patch, err := localGitClient.HeadPatch()
+	if err != nil {
+		return LocalResult{}, err
+	}
+	err = os.MkdirAll(cfg.PatchDir, 0755)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	result.PatchPath = filepath.Join(cfg.PatchDir, strings.ReplaceAll(branch, "/", "-")+".patch")
+	err = os.WriteFile(result.PatchPath, []byte(patch), 0644)
+	if err != nil {
+		return LocalResult{}, err
+	}
+	if original == "" {
+		original = req.BaseBranch
+	}
+	err = localGitClient.DeleteBranch(branch, original)
+	if err != nil {
+		return LocalResult{}, fmt.Errorf("saved %s, but could not delete branch %s: %w", result.PatchPath, branch, err)
+	}
+	result.Branch = ""
+	return result, nil
+}
+
+// localBranch returns a name for the branch a local task is committed to that is not used by an existing branch.
+func localBranch(localGitClient *vc.LocalGitClient, subject string) string {
+	slug := Slugify(subject)
+	if slug == "" {
+		slug = "task"
+	}
+	base := "pullpal/" + slug
+	branch := base
+	for attempt := 2; localGitClient.LocalBranchExists(branch); attempt++ {
+		branch = fmt.Sprintf("%s-%d", base, attempt)
+	}
+	return branch
+}
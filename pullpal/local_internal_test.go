@@ -0,0 +1,81 @@
+package pullpal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRunLocalTaskBranches(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		task     string
+		patch    bool
+		// base is the contents of a.go the change was based on
+		base string
+	}{
+		{"based on the checked out branch", "# Add B\n\nAdd B to a.go.\n---\nfiles: a.go", false, "package a\n\nvar A = 2\n"},
+		{"based on the branch set in the task", "# Add B\n\nAdd B to a.go.\n---\nfiles: a.go\nbase: main", false, "package a\n"},
+		{"saved as a patch", "# Add B\n\nAdd B to a.go.\n---\nfiles: a.go\nbase: main", true, "package a\n"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+		worktree, err := gitRepo.repo.Worktree()
+		require.NoError(t, err)
+		require.NoError(t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}))
+		gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 2\n"})
+
+		taskPath := filepath.Join(t.TempDir(), "task.md")
+		require.NoError(t, os.WriteFile(taskPath, []byte(tt.task), 0644))
+		model, m := newFakeModel(t, "files:\n  -\n    path: a.go\n    contents: |\n      package a\n\n      var B = 1\nnotes: Added B.\n")
+		cfg := LocalConfig{
+			RepoPath: gitRepo.dir,
+			Self:     vc.Author{Handle: "bot", Email: "bot@example.com"},
+			Models:   []llm.Model{m},
+		}
+		if tt.patch {
+			cfg.PatchDir = t.TempDir()
+		}
+
+		result, err := RunLocalTask(context.Background(), zap.NewNop(), cfg, taskPath)
+		require.NoError(t, err)
+		require.Equal(t, 1, model.requests())
+		require.Contains(t, model.prompts[0], tt.base)
+
+		head, err := gitRepo.repo.Head()
+		require.NoError(t, err)
+		if tt.patch {
+			// the patch is made against the base branch, and the branch that was checked out before is checked out again
+			require.Empty(t, result.Branch)
+			patch, err := os.ReadFile(result.PatchPath)
+			require.NoError(t, err)
+			require.Contains(t, string(patch), "+var B = 1")
+			require.Equal(t, "feature", head.Name().Short())
+			continue
+		}
+		require.Equal(t, "pullpal/add-b", result.Branch)
+		require.Equal(t, result.Branch, head.Name().Short())
+		commit, err := gitRepo.repo.CommitObject(head.Hash())
+		require.NoError(t, err)
+		parent, err := commit.Parent(0)
+		require.NoError(t, err)
+		file, err := parent.File("a.go")
+		require.NoError(t, err)
+		contents, err := file.Contents()
+		require.NoError(t, err)
+		require.Equal(t, tt.base, contents)
+		require.True(t, strings.HasPrefix(commit.Message, "Add B"))
+	}
+}
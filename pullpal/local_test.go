@@ -0,0 +1,48 @@
+package pullpal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/pullpal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocalTask(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		name     string
+		contents string
+		subject  string
+		body     string
+	}{
+		{"heading", "task.md", "# Fix the typo\n\nThe readme says teh.\n---\nfiles: README.md", "Fix the typo", "The readme says teh.\n---\nfiles: README.md"},
+		{"no heading", "tasks/add-retry_logic.md", "Retry failed requests.", "add retry logic", "Retry failed requests."},
+		{"heading not on first line", "notes.md", "Intro\n# Heading", "notes", "Intro\n# Heading"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		issue := pullpal.ParseLocalTask(tt.name, tt.contents)
+		require.Equal(t, tt.subject, issue.Subject)
+		require.Equal(t, tt.body, issue.Body)
+	}
+}
+
+func TestLocalRequest(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	taskPath := filepath.Join(dir, "task.md")
+	require.NoError(t, os.WriteFile(taskPath, []byte("# Add a flag\n\nAdd a --verbose flag.\n---\nfiles: main.go, flags.go\nbase: develop\n"), 0644))
+
+	req, err := pullpal.LocalRequest(taskPath, dir)
+	require.NoError(t, err)
+	require.Equal(t, "Add a flag", req.Subject)
+	require.Equal(t, "Add a --verbose flag.", req.Body)
+	require.Equal(t, "develop", req.BaseBranch)
+	require.Len(t, req.Files, 2)
+	require.Equal(t, "package main\n", req.Files[0].Contents)
+	require.Equal(t, "flags.go", req.Files[1].Path)
+	require.Equal(t, "", req.Files[1].Contents)
+}
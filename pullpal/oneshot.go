@@ -0,0 +1,70 @@
+package pullpal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// HandleIssue works on a single issue end-to-end, whether or not it meets the criteria for listing issues. Progress is
+// reported on the issue as usual, and any error is also returned.
+func (p *PullPal) HandleIssue(repo string, number int) error {
+	r, err := p.repo(repo)
+	if err != nil {
+		return err
+	}
+	issue, err := r.ghClient.GetIssue(number)
+	if err != nil {
+		return err
+	}
+	err = r.usage.CheckBudget(r.fullName, r.globalBudget, r.budget)
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("handling issue", zap.Int("issue", number))
+	return r.handleIssue(issue)
+}
+
+// HandleComment responds to a single review comment end-to-end, whether or not it has already been responded to. Any
+// error is returned rather than posted in the comment's thread.
+func (p *PullPal) HandleComment(repo string, id int64) error {
+	r, err := p.repo(repo)
+	if err != nil {
+		return err
+	}
+	comment, err := r.ghClient.GetReviewComment(id, vc.ListCommentOptions{
+		Handles: r.listIssueOptions.Handles,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.usage.CheckBudget(r.fullName, r.globalBudget, r.budget)
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("handling comment", zap.Int("pr", comment.PRNumber), zap.Int64("comment", id))
+	if HasCommand(comment.Body) {
+		return r.handleCommands(comment)
+	}
+	return r.handleComment(comment)
+}
+
+// repo returns the repository with the provided name, which may include the host (e.g. "github.com/owner/name") or
+// not (e.g. "owner/name").
+func (p *PullPal) repo(name string) (*pullPalRepo, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) >= 2 {
+		name = strings.Join(parts[len(parts)-2:], "/")
+	}
+	for i := range p.repos {
+		if strings.EqualFold(p.repos[i].fullName, name) {
+			return &p.repos[i], nil
+		}
+	}
+	return nil, fmt.Errorf("repository %q is not set up", name)
+}
@@ -0,0 +1,171 @@
+package pullpal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// LifecycleLabels are the labels applied to an issue as pull pal works on it. Empty labels are not applied.
+type LifecycleLabels struct {
+	InProgress string `mapstructure:"in-progress"`
+	Done       string `mapstructure:"done"`
+	Failed     string `mapstructure:"failed"`
+}
+
+// DefaultLifecycleLabels are the labels used when none are configured.
+var DefaultLifecycleLabels = LifecycleLabels{
+	InProgress: "pullpal:in-progress",
+	Done:       "pullpal:done",
+	Failed:     "pullpal:failed",
+}
+
+// Merge returns the labels with every label set in override replacing the corresponding label.
+func (l LifecycleLabels) Merge(override LifecycleLabels) LifecycleLabels {
+	if override.InProgress != "" {
+		l.InProgress = override.InProgress
+	}
+	if override.Done != "" {
+		l.Done = override.Done
+	}
+	if override.Failed != "" {
+		l.Failed = override.Failed
+	}
+	return l
+}
+
+type progressStep struct {
+	text string
+	done bool
+}
+
+// issueProgress makes work on an issue visible on the issue itself: pull pal reacts when it picks the issue up, swaps
+// lifecycle labels as work starts and ends, and edits a single status comment as each step completes.
+// Errors reporting progress are logged rather than returned, since they should not prevent the work itself.
+type issueProgress struct {
+	p         *pullPalRepo
+	issue     int
+	commentID int64
+	header    string
+	steps     []progressStep
+	// details is shown below the steps, e.g. the error that caused work to fail.
+	details string
+}
+
+// startProgress reacts to an issue and labels it as in progress, and posts the status comment.
+func (p *pullPalRepo) startProgress(issue vc.Issue) *issueProgress {
+	progress := &issueProgress{
+		p:      p,
+		issue:  issue.Number,
+		header: "👀 I'm working on this.",
+	}
+
+	err := p.ghClient.AddReactionToIssue(issue.Number, "eyes")
+	if err != nil {
+		p.log.Error("error reacting to issue", zap.Int("issue", issue.Number), zap.Error(err))
+	}
+	progress.swapLabels(p.lifecycleLabels.InProgress, p.lifecycleLabels.Done, p.lifecycleLabels.Failed)
+
+	progress.commentID, err = p.ghClient.CreateIssueComment(issue.Number, progress.render())
+	if err != nil {
+		p.log.Error("error creating status comment", zap.Int("issue", issue.Number), zap.Error(err))
+	}
+	return progress
+}
+
+// start marks the previous steps as done, and shows text as the step in progress.
+func (s *issueProgress) start(text string) {
+	for i := range s.steps {
+		s.steps[i].done = true
+	}
+	s.steps = append(s.steps, progressStep{text: text})
+	s.update()
+}
+
+// finish marks every step as done, labels the issue as done, and replaces the header of the status comment.
+func (s *issueProgress) finish(header string) {
+	for i := range s.steps {
+		s.steps[i].done = true
+	}
+	s.header = header
+	s.swapLabels(s.p.lifecycleLabels.Done, s.p.lifecycleLabels.InProgress)
+	s.update()
+}
+
+// wait marks every step as done, and replaces the header and details of the status comment, leaving the issue labeled
+// as in progress while pull pal waits for a response.
+func (s *issueProgress) wait(header, details string) {
+	for i := range s.steps {
+		s.steps[i].done = true
+	}
+	s.header = header
+	s.details = details
+	s.update()
+}
+
+// fail labels the issue as failed, and shows the error in the status comment. The step in progress is left unchecked.
+func (s *issueProgress) fail(err error) {
+	s.header = "❌ I ran into a problem working on this."
+	if len(s.steps) > 0 {
+		current := s.steps[len(s.steps)-1].text
+		s.header = fmt.Sprintf("❌ I ran into a problem while %s.", strings.ToLower(current[:1])+current[1:])
+	}
+	s.details = fmt.Sprintf("<details>\n<summary>Error</summary>\n\n```\n%s\n```\n</details>", err.Error())
+	s.swapLabels(s.p.lifecycleLabels.Failed, s.p.lifecycleLabels.InProgress)
+	s.update()
+}
+
+// render returns the body of the status comment.
+func (s *issueProgress) render() string {
+	body := s.header + "\n"
+	if len(s.steps) > 0 {
+		body += "\n"
+	}
+	for _, step := range s.steps {
+		check := " "
+		if step.done {
+			check = "x"
+		}
+		body += fmt.Sprintf("- [%s] %s\n", check, step.text)
+	}
+	if s.details != "" {
+		body += "\n" + s.details + "\n"
+	}
+	return body
+}
+
+// update edits the status comment, or posts it if it could not be posted before.
+func (s *issueProgress) update() {
+	var err error
+	if s.commentID == 0 {
+		s.commentID, err = s.p.ghClient.CreateIssueComment(s.issue, s.render())
+	} else {
+		err = s.p.ghClient.EditIssueComment(s.commentID, s.render())
+	}
+	if err != nil {
+		s.p.log.Error("error updating status comment", zap.Int("issue", s.issue), zap.Error(err))
+	}
+}
+
+// swapLabels adds the label to the issue, and removes the labels in remove.
+func (s *issueProgress) swapLabels(add string, remove ...string) {
+	for _, label := range remove {
+		if label == "" {
+			continue
+		}
+		err := s.p.ghClient.RemoveLabelFromIssue(s.issue, label)
+		if err != nil {
+			s.p.log.Error("error removing label", zap.Int("issue", s.issue), zap.String("label", label), zap.Error(err))
+		}
+	}
+	if add == "" {
+		return
+	}
+	err := s.p.ghClient.AddLabelsToIssue(s.issue, []string{add})
+	if err != nil {
+		s.p.log.Error("error adding label", zap.Int("issue", s.issue), zap.String("label", add), zap.Error(err))
+	}
+}
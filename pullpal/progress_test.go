@@ -0,0 +1,115 @@
+package pullpal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueProgress(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		// labels are applied to the issue before work starts
+		labels []string
+		run    func(s *issueProgress)
+		body   string
+		after  []string
+	}{
+		{
+			"started",
+			nil,
+			func(s *issueProgress) {
+				s.start("Reading the issue")
+			},
+			"👀 I'm working on this.\n\n- [ ] Reading the issue\n",
+			[]string{"pullpal:in-progress"},
+		},
+		{
+			"earlier steps are checked",
+			[]string{"pullpal:failed"},
+			func(s *issueProgress) {
+				s.start("Reading the issue")
+				s.start("Generating changes")
+			},
+			"👀 I'm working on this.\n\n- [x] Reading the issue\n- [ ] Generating changes\n",
+			[]string{"pullpal:in-progress"},
+		},
+		{
+			"finished",
+			[]string{"pullpal:done"},
+			func(s *issueProgress) {
+				s.start("Reading the issue")
+				s.start("Opening a pull request")
+				s.finish("✅ I opened a pull request.")
+			},
+			"✅ I opened a pull request.\n\n- [x] Reading the issue\n- [x] Opening a pull request\n",
+			[]string{"pullpal:done"},
+		},
+		{
+			"waiting",
+			nil,
+			func(s *issueProgress) {
+				s.start("Preparing the changes for approval")
+				s.wait("✋ I prepared changes.", "the diff")
+			},
+			"✋ I prepared changes.\n\n- [x] Preparing the changes for approval\n\nthe diff\n",
+			[]string{"pullpal:in-progress"},
+		},
+		{
+			"failed during a step",
+			nil,
+			func(s *issueProgress) {
+				s.start("Reading the issue")
+				s.start("Generating changes")
+				s.fail(errors.New("model unavailable"))
+			},
+			"❌ I ran into a problem while generating changes.\n\n- [x] Reading the issue\n- [ ] Generating changes\n\n<details>\n<summary>Error</summary>\n\n```\nmodel unavailable\n```\n</details>\n",
+			[]string{"pullpal:failed"},
+		},
+		{
+			"failed before any step",
+			nil,
+			func(s *issueProgress) {
+				s.fail(errors.New("no access"))
+			},
+			"❌ I ran into a problem working on this.\n\n<details>\n<summary>Error</summary>\n\n```\nno access\n```\n</details>\n",
+			[]string{"pullpal:failed"},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gh := newFakeGithubClient()
+		require.NoError(t, gh.AddLabelsToIssue(1, append([]string{"bug"}, tt.labels...)))
+		p := newTestRepo(t, gh)
+
+		progress := p.startProgress(vc.Issue{Number: 1})
+		tt.run(progress)
+
+		// a single status comment is edited as work progresses
+		require.Len(t, gh.issueBodies[1], 1)
+		require.Equal(t, tt.body, gh.lastComment(1))
+		require.Equal(t, append([]string{"bug"}, tt.after...), gh.issueLabels(1))
+		require.Equal(t, []string{"eyes"}, gh.reactions[1])
+	}
+}
+
+func TestHandleIssueReportsLabelErrors(t *testing.T) {
+	gh := newFakeGithubClient()
+	gh.issues[1] = vc.Issue{Number: 1, Subject: "fix the typo"}
+	require.NoError(t, gh.AddLabelsToIssue(1, []string{"pullpal"}))
+	gh.removeLabelErrs["pullpal"] = errors.New("forbidden")
+	p := newTestRepo(t, gh)
+	p.listIssueOptions.Labels = []string{"pullpal"}
+
+	err := p.handleIssue(gh.issues[1])
+	require.Error(t, err)
+
+	// the error is reported on the issue rather than only logged
+	require.Contains(t, gh.lastComment(1), "❌ I ran into a problem while reading the issue.")
+	require.Contains(t, gh.lastComment(1), "removing label \"pullpal\": forbidden")
+	require.Equal(t, []string{"pullpal", "pullpal:failed"}, gh.issueLabels(1))
+}
@@ -0,0 +1,258 @@
+package pullpal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewComments(t *testing.T) {
+	review := func(id int64, pr int, reviewID int64) vc.Comment {
+		return vc.Comment{ID: id, Type: vc.CommentReview, PRNumber: pr, ReviewID: reviewID}
+	}
+	comments := []vc.Comment{
+		review(1, 3, 10),
+		review(2, 3, 11),
+		review(3, 3, 10),
+		review(4, 4, 10),
+		{ID: 5, Type: vc.CommentPullRequest, PRNumber: 3},
+		review(6, 3, 0),
+	}
+
+	var testCases = []struct {
+		testcase string
+		comment  vc.Comment
+		ids      []int64
+	}{
+		{"comments from the same review", comments[0], []int64{1, 3}},
+		{"single comment review", comments[1], []int64{2}},
+		{"same review ID on another pull request", comments[3], []int64{4}},
+		{"conversation comment", comments[4], nil},
+		{"comment without a review", comments[5], nil},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		var ids []int64
+		for _, c := range reviewComments(comments, tt.comment) {
+			ids = append(ids, c.ID)
+		}
+		require.Equal(t, tt.ids, ids)
+	}
+}
+
+func TestCheckCommentsHandlesReviewsTogether(t *testing.T) {
+	gitRepo := newTestGitRepo(t, map[string]string{
+		"a.go":    "package a\n",
+		"b.go":    "package b\n",
+		"main.go": "package main\n",
+	})
+	gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+
+	review := func(id, reviewID int64, path, body string) vc.Comment {
+		return vc.Comment{
+			ID:       id,
+			Type:     vc.CommentReview,
+			Author:   vc.Author{Handle: "someone"},
+			Body:     body,
+			FilePath: path,
+			Branch:   "pullpal/issue-1",
+			PRNumber: 3,
+			ReviewID: reviewID,
+		}
+	}
+	gh := newFakeGithubClient()
+	gh.reviews[10] = vc.Review{ID: 10, Body: "a few things"}
+	gh.comments = []vc.Comment{
+		review(1, 10, "a.go", "rename A to B"),
+		review(2, 11, "a.go", "this comment is from another review"),
+		review(3, 10, "b.go", "why is this package here?"),
+	}
+
+	// the model tries to change main.go, which was not commented on, and so was not in the prompt
+	model, m := newFakeModel(t, `files:
+  -
+    path: a.go
+    contents: |
+      package a
+
+      var B = 1
+  -
+    path: main.go
+    contents: |
+      package main
+
+      func main() {}
+  -
+    path: c.go
+    contents: |
+      package c
+responses:
+  -
+    comment: 1
+    response: Renamed it.
+`)
+	p := newTestRepo(t, gh)
+	p.localGitClient = gitRepo.client()
+	useModel(p, m)
+
+	require.NoError(t, p.checkComments())
+
+	// both comments from the first review were addressed with a single request, and the other review was left alone
+	require.Equal(t, 1, model.requests())
+	require.Contains(t, model.prompts[0], "rename A to B")
+	require.Contains(t, model.prompts[0], "why is this package here?")
+	require.NotContains(t, model.prompts[0], "another review")
+	require.Equal(t, []string{"Renamed it."}, gh.replies[1])
+	require.Len(t, gh.replies[3], 1)
+	require.Empty(t, gh.replies[2])
+
+	contents, _ := gitRepo.branchFile("pullpal/issue-1", "a.go")
+	require.Equal(t, "package a\n\nvar B = 1\n", contents)
+	// only the pushed commit is reported on
+	head, err := gitRepo.repo.Reference("refs/heads/pullpal/issue-1", true)
+	require.NoError(t, err)
+	require.Len(t, gh.statuses, 1)
+	require.Equal(t, []vc.Status{{Name: statusName, State: vc.StatusSuccess, Description: "changes generated"}}, gh.statuses[head.Hash().String()])
+	// new files can be added, but files the model never saw are not replaced
+	contents, _ = gitRepo.branchFile("pullpal/issue-1", "c.go")
+	require.Equal(t, "package c\n", contents)
+	contents, _ = gitRepo.branchFile("pullpal/issue-1", "main.go")
+	require.Equal(t, "package main\n", contents)
+}
+
+func TestHandleReviewCommentBody(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		body     string
+		// prompt contains text that must be in the prompt
+		prompt []string
+	}{
+		{
+			"markdown rule",
+			"Render this as a table:\n\n---\n\nand keep the header row.",
+			[]string{"Render this as a table:\n\n---\n\nand keep the header row."},
+		},
+		{
+			"files setting",
+			"Also update the other package.\n---\nfiles: b.go",
+			[]string{"Also update the other package.", "package b"},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n", "b.go": "package b\n"})
+		gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+		comment := vc.Comment{
+			ID:       1,
+			Type:     vc.CommentReview,
+			Author:   vc.Author{Handle: "someone"},
+			Body:     tt.body,
+			FilePath: "a.go",
+			Branch:   "pullpal/issue-1",
+			PRNumber: 3,
+		}
+		gh := newFakeGithubClient()
+		model, m := newFakeModel(t, "responseType: 0\nresponse: Sure.\n")
+		p := newTestRepo(t, gh)
+		p.localGitClient = gitRepo.client()
+		useModel(p, m)
+
+		require.NoError(t, p.handleReviewComment(comment))
+		require.Equal(t, 1, model.requests())
+		for _, expected := range tt.prompt {
+			require.Contains(t, model.prompts[0], expected)
+		}
+		require.Equal(t, []string{"Sure."}, gh.replies[1])
+		// nothing changed, so nothing was verified
+		require.Empty(t, gh.statuses)
+	}
+}
+
+func TestHandleReviewCommentLoadsBranchPrompts(t *testing.T) {
+	gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+	// the template was changed on the pull request's branch after pull pal started
+	gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{
+		".pullpal/prompts/comment-diff-request.tmpl": "Answer this like a pirate: {{ .Contents }}",
+	}))
+	comment := vc.Comment{
+		ID:       1,
+		Type:     vc.CommentReview,
+		Author:   vc.Author{Handle: "someone"},
+		Body:     "Why?",
+		FilePath: "a.go",
+		Branch:   "pullpal/issue-1",
+		PRNumber: 3,
+	}
+	gh := newFakeGithubClient()
+	model, m := newFakeModel(t, "responseType: 0\nresponse: Arr.\n")
+	p := newTestRepo(t, gh)
+	p.localGitClient = gitRepo.client()
+	p.promptDirs = []string{filepath.Join(gitRepo.dir, ".pullpal", "prompts")}
+	useModel(p, m)
+
+	require.NoError(t, p.handleReviewComment(comment))
+	require.Equal(t, 1, model.requests())
+	require.Contains(t, model.prompts[0], "Answer this like a pirate: Why?")
+}
+
+func TestHandleReviewCommentOnlyChangesPromptedFiles(t *testing.T) {
+	gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n", "b.go": "package b\n", "main.go": "package main\n"})
+	gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+	comment := vc.Comment{
+		ID:       1,
+		Type:     vc.CommentReview,
+		Author:   vc.Author{Handle: "someone"},
+		Body:     "Rename A to B everywhere.\n---\nfiles: b.go",
+		FilePath: "a.go",
+		Branch:   "pullpal/issue-1",
+		PRNumber: 3,
+	}
+	gh := newFakeGithubClient()
+	// the model also changes main.go, which was not in the prompt
+	_, m := newFakeModel(t, `responseType: 1
+response: Renamed it.
+files:
+  -
+    path: a.go
+    contents: |
+      package a
+
+      var B = 1
+  -
+    path: b.go
+    contents: |
+      package b
+
+      var C = 1
+  -
+    path: main.go
+    contents: |
+      package main
+
+      func main() {}
+  -
+    path: c.go
+    contents: |
+      package c
+`)
+	p := newTestRepo(t, gh)
+	p.localGitClient = gitRepo.client()
+	useModel(p, m)
+
+	require.NoError(t, p.handleReviewComment(comment))
+	require.Equal(t, []string{"Renamed it."}, gh.replies[1])
+	for path, expected := range map[string]string{
+		"a.go":    "package a\n\nvar B = 1\n",
+		"b.go":    "package b\n\nvar C = 1\n",
+		"main.go": "package main\n",
+		"c.go":    "package c\n",
+	} {
+		contents, _ := gitRepo.branchFile("pullpal/issue-1", path)
+		require.Equal(t, expected, contents, path)
+	}
+}
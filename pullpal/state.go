@@ -0,0 +1,132 @@
+package pullpal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IssueState contains settings and progress that are persisted for a single issue.
+type IssueState struct {
+	// Models overrides the chain of models used for the issue, and is set with "/pullpal model".
+	Models []string `json:"models,omitempty"`
+	// Files contains additional files to include when working on the issue, and is set with "/pullpal files".
+	Files []string `json:"files,omitempty"`
+	// Proposals contains the changes proposed for the issue when approval is required, oldest first.
+	Proposals []Proposal `json:"proposals,omitempty"`
+	// BudgetNotified is set once the issue has been told that work on it is paused because a budget was reached, and
+	// cleared when work on it resumes.
+	BudgetNotified bool `json:"budgetNotified,omitempty"`
+}
+
+// pendingProposal returns the index of the proposal waiting for approval, or -1 if there is none.
+func (s IssueState) pendingProposal() int {
+	for i := len(s.Proposals) - 1; i >= 0; i-- {
+		if s.Proposals[i].Status == ProposalPending {
+			return i
+		}
+	}
+	return -1
+}
+
+// StateStore persists the state of issues across restarts, as a single JSON file.
+type StateStore struct {
+	mu     sync.Mutex
+	path   string
+	issues map[string]IssueState
+}
+
+// NewStateStore creates a state store that persists state to path, and loads any state already stored there.
+// If path is empty, state is only kept in memory.
+func NewStateStore(path string) (*StateStore, error) {
+	s := &StateStore{
+		path:   path,
+		issues: make(map[string]IssueState),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &s.issues)
+	if err != nil {
+		return nil, fmt.Errorf("parsing state in %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func issueKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+// Issues returns the state of every issue in repo that has state, keyed by issue number.
+func (s *StateStore) Issues(repo string) map[int]IssueState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issues := make(map[int]IssueState)
+	prefix := repo + "#"
+	for key, state := range s.issues {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			continue
+		}
+		issues[number] = state
+	}
+	return issues
+}
+
+// Issue returns the state of an issue in repo.
+func (s *StateStore) Issue(repo string, number int) IssueState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issues[issueKey(repo, number)]
+}
+
+// UpdateIssue changes the state of an issue in repo, and persists the change.
+func (s *StateStore) UpdateIssue(repo string, number int, update func(*IssueState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := issueKey(repo, number)
+	state := s.issues[key]
+	update(&state)
+	s.issues[key] = state
+
+	return s.save()
+}
+
+// save writes the state to a temporary file, and then replaces the previous state, so that a crash does not leave
+// the state file partially written.
+func (s *StateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.issues, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,160 @@
+package pullpal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// statusName is the name of the commit status or check run pull pal reports its progress with.
+const statusName = "pull-pal"
+
+// verifyTimeout is the maximum duration of the verification command.
+const verifyTimeout = 10 * time.Minute
+
+// maxVerificationOutput is the maximum number of characters of verification output kept. The end of the output is
+// kept, since that is usually where errors are reported.
+const maxVerificationOutput = 10000
+
+// StatusType determines how pull pal reports its progress on its branches.
+type StatusType string
+
+const (
+	// StatusTypeStatuses reports progress as commit statuses.
+	StatusTypeStatuses StatusType = "statuses"
+	// StatusTypeChecks reports progress as check runs, which include the verification output and annotations, but
+	// require pull pal to authenticate as a Github App.
+	StatusTypeChecks StatusType = "checks"
+	// StatusTypeNone does not report progress.
+	StatusTypeNone StatusType = "none"
+)
+
+// verification is the result of running the verification command on a change.
+type verification struct {
+	command string
+	passed  bool
+	output  string
+}
+
+// String returns the verification result in the format included in pull request bodies.
+func (v verification) String() string {
+	result := "passed"
+	if !v.passed {
+		result = "failed"
+	}
+	return fmt.Sprintf("$ %s\n%s\n(%s)", v.command, strings.TrimSpace(v.output), result)
+}
+
+// reportStatus reports the status of work on a commit, and logs any errors.
+func (p *pullPalRepo) reportStatus(sha string, state vc.StatusState, description string, result *verification) {
+	if p.statusType == StatusTypeNone || sha == "" {
+		return
+	}
+	status := vc.Status{
+		Name:        statusName,
+		State:       state,
+		Description: description,
+	}
+	if result != nil {
+		status.Summary = "```\n" + result.String() + "\n```"
+		if !result.passed {
+			status.Annotations = ParseAnnotations(result.output)
+		}
+	}
+	err := p.ghClient.SetStatus(sha, status, p.statusType == StatusTypeChecks)
+	if err != nil {
+		p.log.Error("error reporting status", zap.String("sha", sha), zap.String("description", description), zap.Error(err))
+	}
+}
+
+// reportFailure reports that work on a commit failed, if err is not nil.
+func (p *pullPalRepo) reportFailure(sha string, err error) {
+	if err != nil {
+		p.reportStatus(sha, vc.StatusFailure, "failed: "+err.Error(), nil)
+	}
+}
+
+// headCommit returns the commit that is checked out in the local repository, or an empty string if it cannot be
+// determined, in which case no status is reported for it.
+func (p *pullPalRepo) headCommit() string {
+	sha, err := p.localGitClient.HeadCommit()
+	if err != nil {
+		p.log.Error("error getting head commit", zap.Error(err))
+	}
+	return sha
+}
+
+// verify runs the repository's verification command on the changes committed as sha, and reports the result. If no
+// verification command is configured, the changes are reported as generated and nil is returned.
+func (p *pullPalRepo) verify(sha string) (*verification, error) {
+	if p.verifyCommand == "" {
+		p.reportStatus(sha, vc.StatusSuccess, "changes generated", nil)
+		return nil, nil
+	}
+
+	p.reportStatus(sha, vc.StatusPending, "verifying", nil)
+	ctx, cancel := context.WithTimeout(p.ctx, verifyTimeout)
+	defer cancel()
+	output, err := p.localGitClient.RunCommand(ctx, p.verifyCommand)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("running verification command: %w", err)
+	}
+	if len(output) > maxVerificationOutput {
+		output = "[output shortened]\n" + output[len(output)-maxVerificationOutput:]
+	}
+
+	result := &verification{command: p.verifyCommand, passed: err == nil, output: output}
+	if result.passed {
+		p.reportStatus(sha, vc.StatusSuccess, "verification passed", result)
+	} else {
+		p.reportStatus(sha, vc.StatusFailure, fmt.Sprintf("failed: %q exited with code %d", p.verifyCommand, exitErr.ExitCode()), result)
+	}
+	p.log.Info("verified changes", zap.String("sha", sha), zap.Bool("passed", result.passed))
+	return result, nil
+}
+
+// pushAndVerify pushes the commit that was just made to branch, and verifies it. Nothing is verified if a change
+// results in no commit, since the status of the commit that is already on the branch still applies.
+func (p *pullPalRepo) pushAndVerify(branch string) (err error) {
+	sha := p.headCommit()
+	err = p.localGitClient.PushBranch(branch)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p.reportFailure(sha, err)
+	}()
+
+	_, err = p.verify(sha)
+	return err
+}
+
+var annotationPattern = regexp.MustCompile(`^(?:\./)?([^\s:]+\.[A-Za-z0-9]+):(\d+)(?::\d+)?:\s*(.+)$`)
+
+// ParseAnnotations finds messages about specific lines in verification output, in the "path:line[:column]: message"
+// format used by compilers and linters such as go build and go vet.
+func ParseAnnotations(output string) []vc.Annotation {
+	annotations := []vc.Annotation{}
+	for _, line := range strings.Split(output, "\n") {
+		match := annotationPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		annotations = append(annotations, vc.Annotation{Path: match[1], Line: number, Message: match[3]})
+	}
+	return annotations
+}
@@ -0,0 +1,37 @@
+package pullpal_test
+
+import (
+	"testing"
+
+	"github.com/mobyvb/pull-pal/pullpal"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	var testCases = []struct {
+		testcase    string
+		output      string
+		annotations []vc.Annotation
+	}{
+		{"no output", "", []vc.Annotation{}},
+		{
+			"go build",
+			"# github.com/owner/repo/pkg\npkg/main.go:12:2: undefined: foo\n./pkg/util.go:3:8: \"os\" imported and not used",
+			[]vc.Annotation{
+				{Path: "pkg/main.go", Line: 12, Message: "undefined: foo"},
+				{Path: "pkg/util.go", Line: 3, Message: "\"os\" imported and not used"},
+			},
+		},
+		{
+			"line without column",
+			"    main_test.go:40: expected 1, got 2\n--- FAIL: TestMain (0.00s)\nFAIL",
+			[]vc.Annotation{{Path: "main_test.go", Line: 40, Message: "expected 1, got 2"}},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		require.Equal(t, tt.annotations, pullpal.ParseAnnotations(tt.output))
+	}
+}
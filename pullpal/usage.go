@@ -0,0 +1,262 @@
+package pullpal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mobyvb/pull-pal/llm"
+)
+
+// Price is the cost of using a model, in US dollars per million tokens.
+type Price struct {
+	// Model is a model name prefix. The price with the longest matching prefix is used.
+	Model      string  `mapstructure:"model"`
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+}
+
+// DefaultPrices contains the prices of known OpenAI models. Prices may be overridden in the configuration.
+var DefaultPrices = []Price{
+	{Model: "gpt-3.5-turbo", Prompt: 0.5, Completion: 1.5},
+	{Model: "gpt-4", Prompt: 30, Completion: 60},
+	{Model: "gpt-4-32k", Prompt: 60, Completion: 120},
+	{Model: "gpt-4-turbo", Prompt: 10, Completion: 30},
+	{Model: "gpt-4-1106", Prompt: 10, Completion: 30},
+	{Model: "gpt-4-0125", Prompt: 10, Completion: 30},
+	{Model: "gpt-4o", Prompt: 2.5, Completion: 10},
+	{Model: "gpt-4o-mini", Prompt: 0.15, Completion: 0.6},
+	{Model: "gpt-4.1", Prompt: 2, Completion: 8},
+}
+
+// Budget limits spending on LLM requests, in US dollars. A limit of zero means there is no limit.
+// Days and months are measured in UTC.
+type Budget struct {
+	Daily   float64 `mapstructure:"daily"`
+	Monthly float64 `mapstructure:"monthly"`
+}
+
+// BudgetExceededError is returned when spending has reached a budget.
+type BudgetExceededError struct {
+	// Scope is the repository the budget applies to, or "global".
+	Scope string
+	// Period is "daily" or "monthly".
+	Period string
+	Spent  float64
+	Limit  float64
+}
+
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s %s budget exceeded: spent $%.2f of $%.2f", e.Scope, e.Period, e.Spent, e.Limit)
+}
+
+// UsageRecord is the usage of a single request to a model.
+type UsageRecord struct {
+	Time time.Time `json:"time"`
+	// Repo is the full name of the repository (e.g. "owner/name").
+	Repo string `json:"repo"`
+	// Issue is the issue the request was made for, if any.
+	Issue int `json:"issue,omitempty"`
+	// PR is the pull request the request was made for, if any.
+	PR               int     `json:"pr,omitempty"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageTotals is the sum of a set of usage records.
+type UsageTotals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// Add adds a record to the totals.
+func (t *UsageTotals) Add(r UsageRecord) {
+	t.Requests++
+	t.PromptTokens += r.PromptTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.Cost += r.Cost
+}
+
+// UsageTracker records the tokens used by LLM requests, and the cost of those tokens.
+// Records are appended to a file, one JSON object per line, so that they persist across restarts.
+type UsageTracker struct {
+	mu      sync.Mutex
+	path    string
+	prices  map[string]Price
+	records []UsageRecord
+	now     func() time.Time
+}
+
+// NewUsageTracker creates a usage tracker that persists records to path, and loads any records already stored there.
+// If path is empty, records are only kept in memory. Prices override DefaultPrices for the same model.
+func NewUsageTracker(path string, prices []Price) (*UsageTracker, error) {
+	t := &UsageTracker{
+		path:   path,
+		prices: make(map[string]Price),
+		now:    time.Now,
+	}
+	for _, p := range DefaultPrices {
+		t.prices[p.Model] = p
+	}
+	for _, p := range prices {
+		t.prices[p.Model] = p
+	}
+
+	records, err := ReadUsage(path)
+	if err != nil {
+		return nil, err
+	}
+	t.records = records
+
+	return t, nil
+}
+
+// ReadUsage reads the usage records stored in path. A missing file contains no records.
+func ReadUsage(path string) ([]UsageRecord, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r UsageRecord
+		err = json.Unmarshal([]byte(line), &r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing usage record in %s: %w", path, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// Cost returns the cost in US dollars of the provided tokens. Models without a known price cost nothing.
+func (t *UsageTracker) Cost(model string, promptTokens, completionTokens int) float64 {
+	var price Price
+	longestPrefix := -1
+	for prefix, p := range t.prices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longestPrefix {
+			price = p
+			longestPrefix = len(prefix)
+		}
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
+}
+
+// Record stores the usage of requests made for an issue or pull request in repo.
+func (t *UsageTracker) Record(repo string, issue, pr int, usage []llm.Usage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []byte
+	for _, u := range usage {
+		r := UsageRecord{
+			Time:             t.now().UTC(),
+			Repo:             repo,
+			Issue:            issue,
+			PR:               pr,
+			Model:            u.Model,
+			PromptTokens:     u.PromptTokens,
+			CompletionTokens: u.CompletionTokens,
+			Cost:             t.Cost(u.Model, u.PromptTokens, u.CompletionTokens),
+		}
+		t.records = append(t.records, r)
+
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if t.path == "" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(t.path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(lines)
+	return errors.Join(err, f.Close())
+}
+
+// Totals returns the totals of all records matching filter.
+func (t *UsageTracker) Totals(filter func(UsageRecord) bool) UsageTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var totals UsageTotals
+	for _, r := range t.records {
+		if filter(r) {
+			totals.Add(r)
+		}
+	}
+	return totals
+}
+
+// CheckBudget returns a BudgetExceededError if the spending of repo has reached repoBudget, or the spending of all
+// repositories has reached globalBudget.
+func (t *UsageTracker) CheckBudget(repo string, globalBudget, repoBudget Budget) error {
+	now := t.now().UTC()
+	check := func(scope string, budget Budget, inScope func(UsageRecord) bool) error {
+		if budget.Daily > 0 {
+			spent := t.Totals(func(r UsageRecord) bool { return inScope(r) && SameDay(r.Time, now) }).Cost
+			if spent >= budget.Daily {
+				return BudgetExceededError{Scope: scope, Period: "daily", Spent: spent, Limit: budget.Daily}
+			}
+		}
+		if budget.Monthly > 0 {
+			spent := t.Totals(func(r UsageRecord) bool { return inScope(r) && SameMonth(r.Time, now) }).Cost
+			if spent >= budget.Monthly {
+				return BudgetExceededError{Scope: scope, Period: "monthly", Spent: spent, Limit: budget.Monthly}
+			}
+		}
+		return nil
+	}
+
+	err := check("global", globalBudget, func(UsageRecord) bool { return true })
+	if err != nil {
+		return err
+	}
+	return check(repo, repoBudget, func(r UsageRecord) bool { return strings.EqualFold(r.Repo, repo) })
+}
+
+// SameDay returns true if a and b are on the same day in UTC.
+func SameDay(a, b time.Time) bool {
+	return a.UTC().Format("2006-01-02") == b.UTC().Format("2006-01-02")
+}
+
+// SameMonth returns true if a and b are in the same month in UTC.
+func SameMonth(a, b time.Time) bool {
+	return a.UTC().Format("2006-01") == b.UTC().Format("2006-01")
+}
@@ -0,0 +1,56 @@
+package pullpal_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+	"github.com/mobyvb/pull-pal/pullpal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageTrackerBudgets(t *testing.T) {
+	var testCases = []struct {
+		testcase     string
+		globalBudget pullpal.Budget
+		repoBudget   pullpal.Budget
+		exceeded     string
+	}{
+		{"no budgets", pullpal.Budget{}, pullpal.Budget{}, ""},
+		{"under budgets", pullpal.Budget{Daily: 10, Monthly: 100}, pullpal.Budget{Daily: 5}, ""},
+		{"global daily budget exceeded", pullpal.Budget{Daily: 2}, pullpal.Budget{}, "global daily"},
+		{"global monthly budget exceeded", pullpal.Budget{Monthly: 2}, pullpal.Budget{}, "global monthly"},
+		{"repo budget exceeded", pullpal.Budget{Daily: 10}, pullpal.Budget{Daily: 1}, "owner/a daily"},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		path := filepath.Join(t.TempDir(), "state", "usage.jsonl")
+		prices := []pullpal.Price{{Model: "test", Prompt: 1, Completion: 2}}
+		tracker, err := pullpal.NewUsageTracker(path, prices)
+		require.NoError(t, err)
+
+		// $1 + $2 for owner/a, $0.5 for owner/b
+		require.NoError(t, tracker.Record("owner/a", 1, 0, []llm.Usage{
+			{Model: "test-model", PromptTokens: 1000000},
+			{Model: "test-model", CompletionTokens: 1000000},
+		}))
+		require.NoError(t, tracker.Record("owner/b", 0, 2, []llm.Usage{{Model: "test", PromptTokens: 500000}}))
+
+		// reloading the tracker should keep all records
+		tracker, err = pullpal.NewUsageTracker(path, prices)
+		require.NoError(t, err)
+		issueTotals := tracker.Totals(func(r pullpal.UsageRecord) bool { return r.Repo == "owner/a" && r.Issue == 1 })
+		require.Equal(t, 2, issueTotals.Requests)
+		require.InDelta(t, 3, issueTotals.Cost, 0.0001)
+
+		err = tracker.CheckBudget("owner/a", tt.globalBudget, tt.repoBudget)
+		if tt.exceeded == "" {
+			require.NoError(t, err)
+			continue
+		}
+		require.IsType(t, pullpal.BudgetExceededError{}, err)
+		require.Contains(t, err.Error(), tt.exceeded)
+	}
+}
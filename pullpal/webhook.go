@@ -0,0 +1,59 @@
+package pullpal
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"go.uber.org/zap"
+)
+
+// maxWebhookPayloadSize is the largest webhook payload that will be read (Github caps payloads at 25MB).
+const maxWebhookPayloadSize = 25 << 20
+
+// NewWebhookHandler returns an HTTP handler that verifies Github webhook payloads and enqueues relevant events as jobs.
+// If the job queue is full, the event is dropped - the polling loop will pick up the work later.
+func NewWebhookHandler(log *zap.Logger, secret []byte, jobs chan<- vc.WebhookEvent) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayloadSize))
+		if err != nil {
+			log.Error("error reading webhook payload", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = vc.ValidateWebhookSignature(payload, r.Header.Get(vc.WebhookSignatureHeader), secret)
+		if err != nil {
+			log.Warn("rejecting webhook", zap.Error(err))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		event, err := vc.ParseWebhookEvent(r.Header.Get(vc.WebhookEventHeader), payload)
+		if err != nil {
+			log.Error("error parsing webhook payload", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !event.Relevant() {
+			log.Debug("ignoring webhook event", zap.String("type", string(event.Type)), zap.String("action", event.Action))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		select {
+		case jobs <- event:
+			log.Info("enqueued webhook event", zap.String("type", string(event.Type)), zap.String("repo", event.Repo), zap.Int("number", event.IssueNumber))
+		default:
+			log.Warn("job queue full, dropping webhook event", zap.String("type", string(event.Type)), zap.String("repo", event.Repo))
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
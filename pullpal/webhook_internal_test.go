@@ -0,0 +1,55 @@
+package pullpal
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHandleWebhookEvent(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		event    vc.WebhookEvent
+		// replies maps the ID of each comment that was replied to, to the number of replies
+		replies map[int64]int
+		// issueReplies is the number of comments made on issue 1
+		issueReplies int
+	}{
+		{"review", vc.WebhookEvent{Type: vc.WebhookPullRequestReview, Repo: "owner/repo", IssueNumber: 3, ReviewID: 11}, map[int64]int{2: 1}, 0},
+		{"review comment", vc.WebhookEvent{Type: vc.WebhookPullRequestReviewComment, Repo: "owner/repo", IssueNumber: 3, CommentID: 2, ReviewID: 11}, map[int64]int{2: 1}, 0},
+		{"review comment without a review", vc.WebhookEvent{Type: vc.WebhookPullRequestReviewComment, Repo: "Owner/Repo", IssueNumber: 3, CommentID: 1}, map[int64]int{1: 1}, 0},
+		{"issue comment", vc.WebhookEvent{Type: vc.WebhookIssueComment, Repo: "owner/repo", IssueNumber: 1, CommentID: 20}, map[int64]int{}, 1},
+		{"other repository", vc.WebhookEvent{Type: vc.WebhookIssueComment, Repo: "owner/other", IssueNumber: 1, CommentID: 20}, map[int64]int{}, 0},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		gitRepo := newTestGitRepo(t, map[string]string{"a.go": "package a\n"})
+		gitRepo.setRemoteBranch("pullpal/issue-1", gitRepo.commit(map[string]string{"a.go": "package a\n\nvar A = 1\n"}))
+		gh := newFakeGithubClient()
+		review := func(id, reviewID int64) vc.Comment {
+			return vc.Comment{ID: id, Type: vc.CommentReview, Body: "why?", FilePath: "a.go", Branch: "pullpal/issue-1", PRNumber: 3, ReviewID: reviewID}
+		}
+		gh.comments = []vc.Comment{review(1, 10), review(2, 11)}
+		gh.issueComments = []vc.Comment{{ID: 20, Type: vc.CommentIssue, Body: "why?", IssueNumber: 1}}
+		_, m := newFakeModel(t, "response: Because.\n")
+		p := newTestRepo(t, gh)
+		p.localGitClient = gitRepo.client()
+		p.mu = &sync.Mutex{}
+		useModel(p, m)
+
+		pp := &PullPal{log: zap.NewNop(), repos: []pullPalRepo{*p}}
+		pp.handleWebhookEvent(tt.event)
+
+		replies := make(map[int64]int)
+		for id, r := range gh.replies {
+			replies[id] = len(r)
+		}
+		require.Equal(t, tt.replies, replies)
+		require.Len(t, gh.issueBodies[1], tt.issueReplies)
+	}
+}
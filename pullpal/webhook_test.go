@@ -0,0 +1,146 @@
+package pullpal_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/pullpal"
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	secret := []byte("topsecret")
+
+	var testCases = []struct {
+		testcase  string
+		eventType string
+		payload   string
+		signature string
+		status    int
+		event     *vc.WebhookEvent
+	}{
+		{
+			"opened issue",
+			"issues",
+			`{"action":"opened","issue":{"number":12},"repository":{"full_name":"owner/name"},"sender":{"login":"someone"}}`,
+			"",
+			http.StatusAccepted,
+			&vc.WebhookEvent{Type: vc.WebhookIssues, Action: "opened", Repo: "owner/name", Sender: "someone", IssueNumber: 12},
+		},
+		{
+			"review comment",
+			"pull_request_review_comment",
+			`{"action":"created","comment":{"id":55,"pull_request_review_id":7},"pull_request":{"number":3},"repository":{"full_name":"owner/name"},"sender":{"login":"someone"}}`,
+			"",
+			http.StatusAccepted,
+			&vc.WebhookEvent{Type: vc.WebhookPullRequestReviewComment, Action: "created", Repo: "owner/name", Sender: "someone", IssueNumber: 3, CommentID: 55, ReviewID: 7},
+		},
+		{
+			"submitted review",
+			"pull_request_review",
+			`{"action":"submitted","review":{"id":7},"pull_request":{"number":3},"repository":{"full_name":"owner/name"},"sender":{"login":"someone"}}`,
+			"",
+			http.StatusAccepted,
+			&vc.WebhookEvent{Type: vc.WebhookPullRequestReview, Action: "submitted", Repo: "owner/name", Sender: "someone", IssueNumber: 3, ReviewID: 7},
+		},
+		{
+			"irrelevant action",
+			"issues",
+			`{"action":"closed","issue":{"number":12},"repository":{"full_name":"owner/name"}}`,
+			"",
+			http.StatusNoContent,
+			nil,
+		},
+		{
+			"unsupported event",
+			"ping",
+			`{"zen":"hello"}`,
+			"",
+			http.StatusNoContent,
+			nil,
+		},
+		{
+			"bad signature",
+			"issues",
+			`{"action":"opened","issue":{"number":12},"repository":{"full_name":"owner/name"}}`,
+			"sha256=0123456789abcdef",
+			http.StatusUnauthorized,
+			nil,
+		},
+		{
+			"empty secret",
+			"issues",
+			`{"action":"opened","issue":{"number":12},"repository":{"full_name":"owner/name"}}`,
+			"unkeyed",
+			http.StatusUnauthorized,
+			nil,
+		},
+		{
+			"missing signature",
+			"issues",
+			`{"action":"opened","issue":{"number":12},"repository":{"full_name":"owner/name"}}`,
+			"none",
+			http.StatusUnauthorized,
+			nil,
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		jobs := make(chan vc.WebhookEvent, 1)
+		handlerSecret := secret
+		if tt.signature == "unkeyed" {
+			handlerSecret = nil
+		}
+		server := httptest.NewServer(pullpal.NewWebhookHandler(zap.NewNop(), handlerSecret, jobs))
+
+		req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString(tt.payload))
+		require.NoError(t, err)
+		req.Header.Set(vc.WebhookEventHeader, tt.eventType)
+		switch tt.signature {
+		case "":
+			req.Header.Set(vc.WebhookSignatureHeader, sign(secret, []byte(tt.payload)))
+		case "none":
+		case "unkeyed":
+			req.Header.Set(vc.WebhookSignatureHeader, sign(nil, []byte(tt.payload)))
+		default:
+			req.Header.Set(vc.WebhookSignatureHeader, tt.signature)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		server.Close()
+
+		require.Equal(t, tt.status, resp.StatusCode)
+		if tt.event == nil {
+			require.Len(t, jobs, 0)
+			continue
+		}
+		require.Len(t, jobs, 1)
+		require.Equal(t, *tt.event, <-jobs)
+	}
+}
+
+func TestNewPullPalRequiresWebhookSecret(t *testing.T) {
+	_, err := pullpal.NewPullPal(context.Background(), zap.NewNop(), pullpal.Config{
+		Repos:       []string{"github.com/owner/name"},
+		WebhookAddr: ":0",
+	})
+	require.ErrorIs(t, err, pullpal.ErrWebhookSecretRequired)
+}
@@ -2,6 +2,7 @@ package vc
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/go-git/go-git/v5"
@@ -30,8 +31,19 @@ type ListIssueOptions struct {
 	Handles []string
 }
 
-// Comment represents a comment on a code change request.
-// TODO comments on issue?
+// CommentType defines where a comment was left.
+type CommentType int
+
+const (
+	// CommentReview is a review comment on the diff of a pull request.
+	CommentReview CommentType = iota
+	// CommentPullRequest is a comment in the conversation of a pull request.
+	CommentPullRequest
+	// CommentIssue is a comment on an issue.
+	CommentIssue
+)
+
+// Comment represents a comment on a code change request or issue.
 type Comment struct {
 	ID int64
 	// ChangeID is the local identifier for the code change request this comment was left on (e.g. Github PR number)
@@ -44,6 +56,34 @@ type Comment struct {
 	URL      string
 	Branch   string
 	PRNumber int
+	// Thread contains the earlier comments in the same review thread or conversation, oldest first.
+	Thread []Comment
+	Type   CommentType
+	// IssueNumber is the number of the issue or pull request a conversation comment was left on.
+	IssueNumber int
+	// Issue is the issue or pull request a conversation comment was left on.
+	Issue Issue
+	// ReviewID is the ID of the pull request review a review comment was submitted with.
+	ReviewID int64
+}
+
+// PullRequest represents an open pull request.
+type PullRequest struct {
+	Number     int
+	Subject    string
+	Body       string
+	URL        string
+	Branch     string
+	BaseBranch string
+}
+
+// Review represents a pull request review, which groups review comments that were submitted together.
+type Review struct {
+	ID     int64
+	Author Author
+	// Body is the summary left with the review, if any.
+	Body  string
+	State string
 }
 
 func (c Comment) String() string {
@@ -55,6 +95,9 @@ type ListCommentOptions struct {
 	// Handles defines the list of usernames to list comments from
 	// The comment can be created by *any* user provided.
 	Handles []string
+	// AlwaysInclude returns true for comments that should be listed even in conversations the bot is not part of yet
+	// (e.g. comments containing commands for the bot).
+	AlwaysInclude func(body string) bool
 }
 
 // Author represents a commit, issue, or code change request author on a version control server.
@@ -73,6 +116,11 @@ type Repository struct {
 	localRepo  *git.Repository
 }
 
+// FullName returns the name of the repository including its owner (e.g. "owner/name").
+func (repo Repository) FullName() string {
+	return repo.Owner.Handle + "/" + repo.Name
+}
+
 // SSH returns the SSH connection string for the repository.
 func (repo Repository) SSH() string {
 	return fmt.Sprintf("git@%s:%s/%s.git", repo.HostDomain, repo.Owner.Handle, repo.Name)
@@ -87,11 +135,19 @@ type IssueBody struct {
 	PromptBody string
 	FilePaths  []string
 	BaseBranch string
+	// PullRequest overrides the settings of the pull request opened for the issue.
+	PullRequest PullRequestSettings
 }
 
+// ParseIssueBody parses the prompt and the settings in the body of an issue. The base branch is "main" unless it is set.
 func ParseIssueBody(body string) IssueBody {
+	return parseIssueBody(body, "main")
+}
+
+// parseIssueBody parses the body of an issue, using defaultBase as the base branch unless it is set.
+func parseIssueBody(body, defaultBase string) IssueBody {
 	issueBody := IssueBody{
-		BaseBranch: "main",
+		BaseBranch: defaultBase,
 	}
 	// TODO get rid of parsing like this - "---" may occur in the normal issue body
 	divider := "---"
@@ -122,7 +178,133 @@ func ParseIssueBody(body string) IssueBody {
 			}
 			continue
 		}
+		value := strings.TrimSpace(strings.Join(lineParts[1:], ":"))
+		switch key {
+		case "draft":
+			if draft, err := strconv.ParseBool(value); err == nil {
+				issueBody.PullRequest.Draft = &draft
+			}
+		case "reviewers":
+			issueBody.PullRequest.Reviewers = splitList(value)
+		case "team-reviewers":
+			issueBody.PullRequest.TeamReviewers = splitList(value)
+		case "labels":
+			issueBody.PullRequest.Labels = splitList(value)
+		case "milestone":
+			issueBody.PullRequest.Milestone = value
+		case "assign-issue-author":
+			if assign, err := strconv.ParseBool(value); err == nil {
+				issueBody.PullRequest.AssignIssueAuthor = &assign
+			}
+		}
 	}
 
 	return issueBody
 }
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// PullRequestSettings configures the pull requests opened for issues. Settings can be configured globally, per
+// repository, and per issue; settings that are not set are inherited from the broader level.
+type PullRequestSettings struct {
+	// Draft opens pull requests as drafts.
+	Draft *bool `mapstructure:"draft"`
+	// Reviewers are the handles of users to request reviews from.
+	Reviewers []string `mapstructure:"reviewers"`
+	// TeamReviewers are the slugs of teams to request reviews from.
+	TeamReviewers []string `mapstructure:"team-reviewers"`
+	// Labels are added to pull requests.
+	Labels []string `mapstructure:"labels"`
+	// Milestone is the title or number of the milestone to add pull requests to.
+	Milestone string `mapstructure:"milestone"`
+	// AssignIssueAuthor assigns pull requests to the author of the issue they resolve.
+	AssignIssueAuthor *bool `mapstructure:"assign-issue-author"`
+}
+
+// Merge returns the settings with every setting in override that is set replacing the corresponding setting.
+func (s PullRequestSettings) Merge(override PullRequestSettings) PullRequestSettings {
+	if override.Draft != nil {
+		s.Draft = override.Draft
+	}
+	if override.Reviewers != nil {
+		s.Reviewers = override.Reviewers
+	}
+	if override.TeamReviewers != nil {
+		s.TeamReviewers = override.TeamReviewers
+	}
+	if override.Labels != nil {
+		s.Labels = override.Labels
+	}
+	if override.Milestone != "" {
+		s.Milestone = override.Milestone
+	}
+	if override.AssignIssueAuthor != nil {
+		s.AssignIssueAuthor = override.AssignIssueAuthor
+	}
+	return s
+}
+
+// Options returns the options for opening a pull request resolving issue.
+func (s PullRequestSettings) Options(issue Issue) PullRequestOptions {
+	options := PullRequestOptions{
+		Draft:         s.Draft != nil && *s.Draft,
+		Reviewers:     s.Reviewers,
+		TeamReviewers: s.TeamReviewers,
+		Labels:        s.Labels,
+		Milestone:     s.Milestone,
+	}
+	if s.AssignIssueAuthor != nil && *s.AssignIssueAuthor && issue.Author.Handle != "" {
+		options.Assignees = []string{issue.Author.Handle}
+	}
+	return options
+}
+
+// StatusState is the state of a commit status or check run.
+type StatusState string
+
+const (
+	StatusPending StatusState = "pending"
+	StatusSuccess StatusState = "success"
+	StatusFailure StatusState = "failure"
+)
+
+// Status describes the progress of work on a commit, reported as a commit status or check run.
+type Status struct {
+	// Name identifies the status, so that later statuses with the same name replace it.
+	Name  string
+	State StatusState
+	// Description is a short description of the status, e.g. "verifying".
+	Description string
+	// Summary contains details, and is only shown for check runs.
+	Summary string
+	// Annotations are shown on the lines they refer to, and are only supported by check runs.
+	Annotations []Annotation
+}
+
+// Annotation is a message about a line of a file.
+type Annotation struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+// PullRequestOptions defines options for opening a pull request.
+type PullRequestOptions struct {
+	Draft         bool
+	Reviewers     []string
+	TeamReviewers []string
+	Labels        []string
+	// Milestone is the title or number of a milestone, or empty for no milestone.
+	Milestone string
+	Assignees []string
+}
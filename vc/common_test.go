@@ -85,10 +85,38 @@ nonexistentoption: asdf
 				FilePaths:  []string{"index.html", "main.go"},
 			},
 		},
+		{
+			"issue with pull request settings",
+			`
+add an html file
+---
+files: index.html
+draft: true
+reviewers: alice, bob
+team-reviewers: frontend
+labels: bot,  html
+milestone: v1.0: the first release
+assign-issue-author: false
+			`,
+			vc.IssueBody{
+				PromptBody: "add an html file",
+				BaseBranch: "main",
+				FilePaths:  []string{"index.html"},
+				PullRequest: vc.PullRequestSettings{
+					Draft:             boolPtr(true),
+					Reviewers:         []string{"alice", "bob"},
+					TeamReviewers:     []string{"frontend"},
+					Labels:            []string{"bot", "html"},
+					Milestone:         "v1.0: the first release",
+					AssignIssueAuthor: boolPtr(false),
+				},
+			},
+		},
 	}
 	for _, tt := range testCases {
 		t.Log("testing case:", tt.testcase)
 		parsed := vc.ParseIssueBody(tt.body)
+		require.Equal(t, tt.parsed.PullRequest, parsed.PullRequest)
 		require.Equal(t, tt.parsed.PromptBody, parsed.PromptBody)
 		require.Equal(t, tt.parsed.BaseBranch, parsed.BaseBranch)
 		require.Equal(t, len(tt.parsed.FilePaths), len(parsed.FilePaths))
@@ -97,3 +125,43 @@ nonexistentoption: asdf
 		}
 	}
 }
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestPullRequestSettings(t *testing.T) {
+	issue := vc.Issue{Number: 1, Author: vc.Author{Handle: "alice"}}
+	global := vc.PullRequestSettings{
+		Draft:             boolPtr(true),
+		Reviewers:         []string{"bob"},
+		Labels:            []string{"bot"},
+		AssignIssueAuthor: boolPtr(true),
+	}
+
+	var testCases = []struct {
+		testcase string
+		override vc.PullRequestSettings
+		options  vc.PullRequestOptions
+	}{
+		{
+			"no overrides",
+			vc.PullRequestSettings{},
+			vc.PullRequestOptions{Draft: true, Reviewers: []string{"bob"}, Labels: []string{"bot"}, Assignees: []string{"alice"}},
+		},
+		{
+			"overrides replace settings",
+			vc.PullRequestSettings{Draft: boolPtr(false), Reviewers: []string{"carol"}, TeamReviewers: []string{"core"}, Milestone: "v1", AssignIssueAuthor: boolPtr(false)},
+			vc.PullRequestOptions{Reviewers: []string{"carol"}, TeamReviewers: []string{"core"}, Labels: []string{"bot"}, Milestone: "v1"},
+		},
+		{
+			"empty list clears setting",
+			vc.PullRequestSettings{Labels: []string{}},
+			vc.PullRequestOptions{Draft: true, Reviewers: []string{"bob"}, Labels: []string{}, Assignees: []string{"alice"}},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		require.Equal(t, tt.options, global.Merge(tt.override).Options(issue))
+	}
+}
@@ -0,0 +1,106 @@
+package vc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dryRunLog is the name of the file actions without contents, such as adding labels, are recorded in.
+const dryRunLog = "actions.log"
+
+// DryRun records the changes clients would make to repositories, instead of making them. Each repository's records are
+// written to a subdirectory of dir named after the repository, or to out if dir is empty.
+type DryRun struct {
+	mu  sync.Mutex
+	dir string
+	out io.Writer
+
+	// lastID is the last ID given to a comment that was recorded instead of being created.
+	lastID int64
+	// commentIssues maps the IDs given to recorded comments to the issues they would have been created on.
+	commentIssues map[int64]int
+}
+
+// NewDryRun returns a DryRun that records changes in dir, or writes them to out if dir is empty.
+func NewDryRun(dir string, out io.Writer) *DryRun {
+	return &DryRun{
+		dir:           dir,
+		out:           out,
+		commentIssues: make(map[int64]int),
+	}
+}
+
+// Record records something that would have been created or replaced in repo, such as a comment or a pushed diff.
+// Records with the same name replace each other.
+func (d *DryRun) Record(repo, name, contents string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dir == "" {
+		_, err := fmt.Fprintf(d.out, "[dry run] %s: %s\n%s\n\n", repo, name, strings.TrimRight(contents, "\n"))
+		return err
+	}
+	dir := filepath.Join(d.dir, filepath.FromSlash(repo))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+}
+
+// Action records a change without contents that would have been made to repo, such as adding a label.
+func (d *DryRun) Action(repo, format string, args ...interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	action := fmt.Sprintf(format, args...)
+	if d.dir == "" {
+		_, err := fmt.Fprintf(d.out, "[dry run] %s: %s\n", repo, action)
+		return err
+	}
+	dir := filepath.Join(d.dir, filepath.FromSlash(repo))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, dryRunLog), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339), action)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newComment returns an ID for a comment on an issue that is recorded instead of being created, so that edits to it
+// can be recorded under the same name.
+func (d *DryRun) newComment(issueNumber int) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastID++
+	d.commentIssues[d.lastID] = issueNumber
+	return d.lastID
+}
+
+// commentName returns the name comments are recorded under.
+func (d *DryRun) commentName(commentID int64) string {
+	d.mu.Lock()
+	issueNumber, ok := d.commentIssues[commentID]
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Sprintf("comment-%d.md", commentID)
+	}
+	return fmt.Sprintf("issue-%d-comment-%d.md", issueNumber, commentID)
+}
+
+// branchFileName returns a name for a branch that can be used in file names.
+func branchFileName(branch string) string {
+	return strings.ReplaceAll(branch, "/", "-")
+}
@@ -0,0 +1,154 @@
+package vc
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxCachedResponses limits the number of responses kept by an etagTransport. Listings are cached per pull request, so
+// without a limit the cache would keep growing as pull requests are opened and closed.
+const maxCachedResponses = 500
+
+// volatileParams are query parameters that change with every request, and are left out of cache keys. A response to a
+// request with a different value can still be reused, since the server only reports that a response has not been
+// modified if it has the same ETag.
+var volatileParams = []string{"since"}
+
+// cachedResponse is a response body that can be reused if the server reports that it has not been modified.
+type cachedResponse struct {
+	key    string
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// etagTransport is an http.RoundTripper that makes conditional GET requests using ETags.
+// Github does not count requests that return "304 Not Modified" against the rate limit, so
+// repeatedly listing issues and comments that have not changed is essentially free.
+type etagTransport struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// responses contains the cached responses, most recently used first, and cache indexes them by key.
+	responses *list.List
+	cache     map[string]*list.Element
+	max       int
+}
+
+func newETagTransport(base http.RoundTripper) *etagTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &etagTransport{
+		base:      base,
+		responses: list.New(),
+		cache:     make(map[string]*list.Element),
+		max:       maxCachedResponses,
+	}
+}
+
+// cacheKey returns the key responses to req are cached with. The Accept header is part of the key, since the same URL
+// can return different representations, such as a pull request as JSON or as a diff.
+func cacheKey(req *http.Request) string {
+	query := req.URL.Query()
+	for _, param := range volatileParams {
+		query.Del(param)
+	}
+	return req.Header.Get("Accept") + " " + req.URL.Host + req.URL.Path + "?" + query.Encode()
+}
+
+// get returns the response cached with key, if any.
+func (t *etagTransport) get(key string) (cachedResponse, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.cache[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	t.responses.MoveToFront(e)
+	return e.Value.(cachedResponse), true
+}
+
+// put caches a response, evicting the least recently used response if the cache is full.
+func (t *etagTransport) put(r cachedResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.cache[r.key]; ok {
+		e.Value = r
+		t.responses.MoveToFront(e)
+		return
+	}
+	t.cache[r.key] = t.responses.PushFront(r)
+	if t.responses.Len() > t.max {
+		oldest := t.responses.Back()
+		t.responses.Remove(oldest)
+		delete(t.cache, oldest.Value.(cachedResponse).key)
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	cached, ok := t.get(key)
+
+	if ok {
+		// requests must not be modified by a RoundTripper, so set the header on a copy
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		header := cached.header.Clone()
+		// keep rate limit information up to date
+		for _, h := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+			if v := resp.Header.Get(h); v != "" {
+				header.Set(h, v)
+			}
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       resp.Request,
+		}, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.put(cachedResponse{
+		key:    key,
+		etag:   etag,
+		header: resp.Header.Clone(),
+		body:   body,
+	})
+
+	return resp, nil
+}
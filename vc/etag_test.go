@@ -0,0 +1,128 @@
+package vc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagTransport(t *testing.T) {
+	requests := 0
+	notModified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.Header().Set("X-RateLimit-Remaining", "4999")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("X-RateLimit-Remaining", "4998")
+		w.Write([]byte("issues"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newETagTransport(nil)}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL + "/issues")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "issues", string(body))
+		if i > 0 {
+			require.Equal(t, "4999", resp.Header.Get("X-RateLimit-Remaining"))
+		}
+	}
+	require.Equal(t, 3, requests)
+	require.Equal(t, 2, notModified)
+
+	// a different URL is not served from the cache
+	resp, err := client.Get(server.URL + "/pulls")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 2, notModified)
+}
+
+func TestETagTransportCacheKeys(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		url      string
+		accept   string
+		cached   bool
+	}{
+		{"same URL", "/comments?page=2&since=2023-01-01T00:00:00Z", "application/json", true},
+		{"different since", "/comments?page=2&since=2023-02-01T00:00:00Z", "application/json", true},
+		{"different page", "/comments?page=3&since=2023-01-01T00:00:00Z", "application/json", false},
+		{"different path", "/issues?page=2", "application/json", false},
+		{"different media type", "/comments?page=2&since=2023-01-01T00:00:00Z", "application/vnd.github.v3.diff", false},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		conditional := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") != "" {
+				conditional = true
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(r.Header.Get("Accept")))
+		}))
+
+		client := &http.Client{Transport: newETagTransport(nil)}
+		requests := []struct{ url, accept string }{
+			{"/comments?page=2&since=2023-01-01T00:00:00Z", "application/json"},
+			{tt.url, tt.accept},
+		}
+		for _, r := range requests {
+			req, err := http.NewRequest(http.MethodGet, server.URL+r.url, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", r.accept)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+			// a cached response is only reused for the same representation
+			require.Equal(t, r.accept, string(body))
+		}
+		require.Equal(t, tt.cached, conditional)
+		server.Close()
+	}
+}
+
+func TestETagTransportEvictsLeastRecentlyUsed(t *testing.T) {
+	conditional := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional[r.URL.Path]++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	transport := newETagTransport(nil)
+	transport.max = 2
+	client := &http.Client{Transport: transport}
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/b", "/a"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// /b was evicted when /c was cached, since /a had been used more recently, and /a was evicted when /b was cached again
+	require.Equal(t, map[string]int{"/a": 1}, conditional)
+	require.Equal(t, 2, transport.responses.Len())
+	require.Len(t, transport.cache, 2)
+}
@@ -1,11 +1,13 @@
 package vc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"go/format"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -29,6 +31,12 @@ type LocalGitClient struct {
 
 	worktree *git.Worktree
 	debugDir string
+
+	// dryRun records the branches the client would push, instead of pushing them, if set.
+	dryRun *DryRun
+	// local is true if the repository was opened in place rather than cloned, in which case base branches are checked
+	// out from local branches.
+	local bool
 }
 
 // NewLocalGitClient initializes a local git client by checking out a repository locally.
@@ -45,7 +53,7 @@ func NewLocalGitClient(log *zap.Logger, self Author, repo Repository, debugDir s
 		return nil, err
 	}
 
-	localRepo, err := git.PlainClone(repo.LocalPath, false, &git.CloneOptions{
+	_, err = git.PlainClone(repo.LocalPath, false, &git.CloneOptions{
 		URL: repo.SSH(),
 		// URL: repo.HTTPS(),
 		Auth: &http.BasicAuth{
@@ -56,6 +64,16 @@ func NewLocalGitClient(log *zap.Logger, self Author, repo Repository, debugDir s
 	if err != nil {
 		return nil, err
 	}
+	return OpenClonedGitClient(log, self, repo, debugDir)
+}
+
+// OpenClonedGitClient opens a repository that was already cloned to repo.LocalPath. Like NewLocalGitClient, base
+// branches are checked out from the remote.
+func OpenClonedGitClient(log *zap.Logger, self Author, repo Repository, debugDir string) (*LocalGitClient, error) {
+	localRepo, err := git.PlainOpen(repo.LocalPath)
+	if err != nil {
+		return nil, err
+	}
 	repo.localRepo = localRepo
 
 	return &LocalGitClient{
@@ -66,6 +84,43 @@ func NewLocalGitClient(log *zap.Logger, self Author, repo Repository, debugDir s
 	}, nil
 }
 
+// OpenLocalGitClient opens a repository that is already checked out at localPath, instead of cloning it. Base branches
+// are checked out from local branches rather than from the remote. Opening fails if tracked files have uncommitted
+// changes, since checking out a branch would discard them.
+func OpenLocalGitClient(log *zap.Logger, self Author, localPath, debugDir string) (*LocalGitClient, error) {
+	localRepo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := localRepo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	for path, s := range status {
+		untracked := s.Staging == git.Untracked
+		unmodified := s.Staging == git.Unmodified && s.Worktree == git.Unmodified
+		if !untracked && !unmodified {
+			return nil, fmt.Errorf("%s has uncommitted changes to %s", localPath, path)
+		}
+	}
+
+	return &LocalGitClient{
+		log:  log,
+		self: self,
+		repo: Repository{
+			LocalPath: localPath,
+			Name:      filepath.Base(localPath),
+			localRepo: localRepo,
+		},
+		debugDir: debugDir,
+		local:    true,
+	}, nil
+}
+
 func (gc *LocalGitClient) CheckoutRemoteBranch(branchName string) (err error) {
 	if gc.worktree == nil {
 		return errors.New("worktree is nil - cannot check out a branch")
@@ -99,6 +154,70 @@ func (gc *LocalGitClient) CheckoutRemoteBranch(branchName string) (err error) {
 	return nil
 }
 
+// checkoutLocalBranch checks out a local branch.
+func (gc *LocalGitClient) checkoutLocalBranch(branchName string) error {
+	if gc.worktree == nil {
+		return errors.New("worktree is nil - cannot check out a branch")
+	}
+	return gc.worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+		Force:  true,
+	})
+}
+
+// CreateBranch creates a local branch at the commit that is checked out, and checks it out so that the next commit is
+// made on it. StartCommit must be called first.
+func (gc *LocalGitClient) CreateBranch(branchName string) error {
+	if gc.worktree == nil {
+		return errors.New("worktree is nil - StartCommit must be called")
+	}
+	return gc.worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+		Create: true,
+		Keep:   true,
+	})
+}
+
+// CurrentBranch returns the name of the local branch that is checked out, or an error if HEAD is detached.
+func (gc *LocalGitClient) CurrentBranch() (string, error) {
+	head, err := gc.repo.localRepo.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "", errors.New("HEAD is detached")
+	}
+	return head.Name().Short(), nil
+}
+
+// LocalBranchExists returns true if the local repository has a branch with the provided name.
+func (gc *LocalGitClient) LocalBranchExists(branchName string) bool {
+	_, err := gc.repo.localRepo.Reference(plumbing.NewBranchReferenceName(branchName), false)
+	return err == nil
+}
+
+// DeleteBranch checks out the local branch checkout, and deletes the local branch branchName.
+func (gc *LocalGitClient) DeleteBranch(branchName, checkout string) error {
+	worktree, err := gc.repo.localRepo.Worktree()
+	if err != nil {
+		return err
+	}
+	err = worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(checkout),
+		Force:  true,
+	})
+	if err != nil {
+		return err
+	}
+	return gc.repo.localRepo.Storer.RemoveReference(plumbing.NewBranchReferenceName(branchName))
+}
+
+// SetDryRun makes the client record the changes it would push with d, instead of pushing them. Branches are still
+// created locally.
+func (gc *LocalGitClient) SetDryRun(d *DryRun) {
+	gc.dryRun = d
+}
+
 func (gc *LocalGitClient) PushBranch(branchName string) (err error) {
 	//branchRefName := plumbing.NewBranchReferenceName(branchName)
 	remoteName := "origin"
@@ -114,6 +233,13 @@ func (gc *LocalGitClient) PushBranch(branchName string) (err error) {
 	if err != nil {
 		return err
 	}
+	if gc.dryRun != nil {
+		patch, err := gc.HeadPatch()
+		if err != nil {
+			return err
+		}
+		return gc.dryRun.Record(gc.repo.FullName(), fmt.Sprintf("push-%s.diff", branchFileName(branchName)), patch)
+	}
 
 	// Push the new branch to the remote repository
 	remote, err := gc.repo.localRepo.Remote(remoteName)
@@ -137,7 +263,31 @@ func (gc *LocalGitClient) PushBranch(branchName string) (err error) {
 	return nil
 }
 
+// CheckRepoPath returns an error if path does not refer to a file inside a repository, e.g. because it is absolute,
+// leads outside of the repository with "..", or is inside the .git directory.
+func CheckRepoPath(path string) error {
+	if path == "" {
+		return errors.New("empty file path")
+	}
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") || strings.HasPrefix(path, "\\") {
+		return fmt.Errorf("%q is an absolute path; paths must be relative to the repository root", path)
+	}
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%q is not a file in the repository", path)
+	}
+	if clean == ".git" || strings.HasPrefix(clean, ".git/") {
+		return fmt.Errorf("%q is inside the .git directory", path)
+	}
+	return nil
+}
+
+// GetLocalFile reads a file from the local repository. If the file does not exist, an empty file is returned.
 func (gc *LocalGitClient) GetLocalFile(path string) (llm.File, error) {
+	err := CheckRepoPath(path)
+	if err != nil {
+		return llm.File{}, err
+	}
 	fullPath := filepath.Join(gc.repo.LocalPath, path)
 
 	data, err := ioutil.ReadFile(fullPath)
@@ -159,6 +309,31 @@ func (gc *LocalGitClient) GetLocalFile(path string) (llm.File, error) {
 	}, nil
 }
 
+// FileAtCommit reads a file as it was in the commit with the given hash. If the file did not exist in the commit, an
+// empty file is returned.
+func (gc *LocalGitClient) FileAtCommit(sha, path string) (llm.File, error) {
+	err := CheckRepoPath(path)
+	if err != nil {
+		return llm.File{}, err
+	}
+	commit, err := gc.repo.localRepo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return llm.File{}, err
+	}
+	file, err := commit.File(filepath.ToSlash(filepath.Clean(path)))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return llm.File{Path: path}, nil
+	}
+	if err != nil {
+		return llm.File{}, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return llm.File{}, err
+	}
+	return llm.File{Path: path, Contents: contents}, nil
+}
+
 func (gc *LocalGitClient) StartCommit() error {
 	if gc.worktree != nil {
 		return errors.New("worktree is not nil - cannot start a new commit")
@@ -180,6 +355,11 @@ func (gc *LocalGitClient) ReplaceOrAddLocalFile(newFile llm.File) error {
 		return errors.New("worktree is nil - StartCommit must be called")
 	}
 
+	err := CheckRepoPath(newFile.Path)
+	if err != nil {
+		return err
+	}
+
 	// TODO format non-go files as well
 	if strings.HasSuffix(newFile.Path, ".go") {
 		newContents, err := format.Source([]byte(newFile.Contents))
@@ -195,7 +375,7 @@ func (gc *LocalGitClient) ReplaceOrAddLocalFile(newFile llm.File) error {
 
 	fullPath := filepath.Join(gc.repo.LocalPath, newFile.Path)
 	dirPath := filepath.Dir(fullPath)
-	err := os.MkdirAll(dirPath, 0755)
+	err = os.MkdirAll(dirPath, 0755)
 	if err != nil {
 		return err
 	}
@@ -210,6 +390,51 @@ func (gc *LocalGitClient) ReplaceOrAddLocalFile(newFile llm.File) error {
 	return err
 }
 
+// HeadCommit returns the hash of the commit that is currently checked out.
+func (gc *LocalGitClient) HeadCommit() (string, error) {
+	head, err := gc.repo.localRepo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+// HeadPatch returns the changes made by the commit that is currently checked out, as a unified diff.
+func (gc *LocalGitClient) HeadPatch() (string, error) {
+	head, err := gc.repo.localRepo.Head()
+	if err != nil {
+		return "", err
+	}
+	commit, err := gc.repo.localRepo.CommitObject(head.Hash())
+	if err != nil {
+		return "", err
+	}
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return "", err
+	}
+	patch, err := parent.Patch(commit)
+	if err != nil {
+		return "", err
+	}
+	return patch.String(), nil
+}
+
+// RunCommand runs a shell command in the root of the local repository, and returns its combined output.
+func (gc *LocalGitClient) RunCommand(ctx context.Context, command string) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = gc.repo.LocalPath
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// AbortCommit discards the active worktree without committing, so that a new commit can be started.
+// Changes that were made to files are left in place, and are discarded when the next branch is checked out. Calling it
+// after FinishCommit has no effect.
+func (gc *LocalGitClient) AbortCommit() {
+	gc.worktree = nil
+}
+
 // FinishCommit completes a commit, after which a code change request can be opened or updated.
 func (gc *LocalGitClient) FinishCommit(message string) error {
 	if gc.worktree == nil {
@@ -233,7 +458,8 @@ func (gc *LocalGitClient) FinishCommit(message string) error {
 }
 
 // ParseIssueAndStartCommit parses the information provided in the issue to check out the appropriate branch,
-// get the contents of the files mentioned in the issue, and initialize the worktree.
+// get the contents of the files mentioned in the issue, and initialize the worktree. If the issue does not set a base
+// branch, "main" is used, or for repositories opened in place, the branch that is checked out.
 func (gc *LocalGitClient) ParseIssueAndStartCommit(issue Issue) (llm.CodeChangeRequest, error) {
 	var changeRequest llm.CodeChangeRequest
 
@@ -241,7 +467,13 @@ func (gc *LocalGitClient) ParseIssueAndStartCommit(issue Issue) (llm.CodeChangeR
 		return changeRequest, errors.New("worktree is active - some other work is incomplete")
 	}
 
-	issueBody := ParseIssueBody(issue.Body)
+	defaultBase := "main"
+	if gc.local {
+		if current, err := gc.CurrentBranch(); err == nil {
+			defaultBase = current
+		}
+	}
+	issueBody := parseIssueBody(issue.Body, defaultBase)
 	gc.log.Info("issue body info", zap.Any("files", issueBody.FilePaths))
 
 	// start a worktree
@@ -251,9 +483,13 @@ func (gc *LocalGitClient) ParseIssueAndStartCommit(issue Issue) (llm.CodeChangeR
 		return changeRequest, err
 	}
 
-	err = gc.CheckoutRemoteBranch(issueBody.BaseBranch)
+	if gc.local {
+		err = gc.checkoutLocalBranch(issueBody.BaseBranch)
+	} else {
+		err = gc.CheckoutRemoteBranch(issueBody.BaseBranch)
+	}
 	if err != nil {
-		gc.log.Error("error checking out remote branch", zap.Error(err))
+		gc.log.Error("error checking out base branch", zap.Error(err))
 		return changeRequest, err
 	}
 
@@ -0,0 +1,90 @@
+package vc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mobyvb/pull-pal/vc"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCheckRepoPath(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		path     string
+		valid    bool
+	}{
+		{"file", "main.go", true},
+		{"nested file", "cmd/root.go", true},
+		{"parent directory inside the repository", "cmd/../main.go", true},
+		{"empty", "", false},
+		{"absolute", "/etc/passwd", false},
+		{"parent directory", "../other/main.go", false},
+		{"leads outside the repository", "cmd/../../main.go", false},
+		{"repository root", ".", false},
+		{"git directory", ".git/hooks/pre-commit", false},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+		err := vc.CheckRepoPath(tt.path)
+		if tt.valid {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+	}
+}
+
+func TestFileAtCommit(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(contents string) string {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte(contents), 0644))
+		_, err := worktree.Add("a.go")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("update a.go", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	first := commit("package a\n")
+	second := commit("package a\n\nvar A = 1\n")
+
+	gc, err := vc.OpenLocalGitClient(zap.NewNop(), vc.Author{}, dir, "")
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		testcase string
+		sha      string
+		path     string
+		contents string
+		fails    bool
+	}{
+		{"first commit", first, "a.go", "package a\n", false},
+		{"second commit", second, "a.go", "package a\n\nvar A = 1\n", false},
+		{"missing file", second, "b.go", "", false},
+		{"unclean path", second, "./a.go", "package a\n\nvar A = 1\n", false},
+		{"path outside the repository", second, "../a.go", "", true},
+		{"unknown commit", "0123456789012345678901234567890123456789", "a.go", "", true},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		file, err := gc.FileAtCommit(tt.sha, tt.path)
+		if tt.fails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.path, file.Path)
+		require.Equal(t, tt.contents, file.Contents)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mobyvb/pull-pal/llm"
 
@@ -22,10 +26,34 @@ type GithubClient struct {
 	client *github.Client
 	self   Author
 	repo   Repository
+
+	// prComments caches review comments for each pull request, so that only new or updated comments need to be fetched.
+	prComments map[int]*prCommentCache
+	// issueComments caches comments on issues and pull request conversations.
+	issueComments *issueCommentCache
+
+	// dryRun records the changes the client would make, instead of making them, if set.
+	dryRun *DryRun
+}
+
+// prCommentCache contains the review comments fetched for a pull request so far.
+type prCommentCache struct {
+	// lastUpdated is the latest update time of any cached comment.
+	lastUpdated time.Time
+	comments    map[int64]*github.PullRequestComment
+}
+
+// issueCommentCache contains the comments fetched for issues and pull request conversations so far.
+type issueCommentCache struct {
+	// lastUpdated is the latest update time of any cached comment.
+	lastUpdated time.Time
+	// comments contains the cached comments, keyed by issue number and comment ID.
+	comments map[int]map[int64]*github.IssueComment
 }
 
 // NewGithubClient initializes a Github client and checks out a repository locally.
-func NewGithubClient(ctx context.Context, log *zap.Logger, self Author, repo Repository) (*GithubClient, error) {
+// Requests are sent using the base transport (e.g. a RateLimitTransport shared between clients), or http.DefaultTransport if nil.
+func NewGithubClient(ctx context.Context, log *zap.Logger, self Author, repo Repository, base http.RoundTripper) (*GithubClient, error) {
 	log.Info("Creating new Github client...")
 	if self.Token == "" {
 		return nil, errors.New("Github access token not provided")
@@ -34,38 +62,62 @@ func NewGithubClient(ctx context.Context, log *zap.Logger, self Author, repo Rep
 		&oauth2.Token{AccessToken: self.Token},
 	)
 	// oauth client is used to list issues, open pull requests, etc...
-	tc := oauth2.NewClient(ctx, ts)
+	// requests are made conditionally using ETags to save rate limit quota
+	tc := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: ts,
+			Base:   newETagTransport(base),
+		},
+	}
 
 	log.Info("Success. Github client set up.")
 
 	return &GithubClient{
-		ctx:    ctx,
-		log:    log,
-		client: github.NewClient(tc),
-		self:   self,
-		repo:   repo,
+		ctx:        ctx,
+		log:        log,
+		client:     github.NewClient(tc),
+		self:       self,
+		repo:       repo,
+		prComments: make(map[int]*prCommentCache),
+		issueComments: &issueCommentCache{
+			comments: make(map[int]map[int64]*github.IssueComment),
+		},
 	}, nil
 }
 
-// OpenCodeChangeRequest pushes to a new remote branch and opens a PR on Github.
-func (gc *GithubClient) OpenCodeChangeRequest(req llm.CodeChangeRequest, res llm.CodeChangeResponse, fromBranch string) (id, url string, err error) {
+// SetDryRun makes the client record the changes it would make to the repository with d, instead of making them.
+// Reads, such as listing issues, are still made.
+func (gc *GithubClient) SetDryRun(d *DryRun) {
+	gc.dryRun = d
+}
+
+// OpenCodeChangeRequest opens a PR on Github from a branch that has already been pushed, and applies options such as
+// reviewers and labels to it.
+func (gc *GithubClient) OpenCodeChangeRequest(req llm.CodeChangeRequest, body, fromBranch string, options PullRequestOptions) (id, url string, err error) {
 	// TODO handle gc.ctx canceled
 
 	title := req.Subject
 	if title == "" {
 		title = "update files"
 	}
+	if gc.dryRun != nil {
+		name := fmt.Sprintf("pull-request-%s.md", branchFileName(fromBranch))
+		err = gc.dryRun.Record(gc.repo.FullName(), name, fmt.Sprintf("# %s\n\n%s -> %s\n%+v\n\n%s", title, fromBranch, req.BaseBranch, options, body))
+		return "", "dry run: " + name, err
+	}
 
-	body := res.Notes
-	body += fmt.Sprintf("\n\nResolves #%d", req.IssueNumber)
-
-	// Finally, open a pull request from the new branch.
-	pr, _, err := gc.client.PullRequests.Create(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, &github.NewPullRequest{
+	newPR := &github.NewPullRequest{
 		Title: &title,
 		Head:  &fromBranch,
 		Base:  &req.BaseBranch,
 		Body:  &body,
-	})
+	}
+	var pr *github.PullRequest
+	if options.Draft {
+		pr, err = gc.createDraftPullRequest(newPR)
+	} else {
+		pr, _, err = gc.client.PullRequests.Create(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, newPR)
+	}
 	if err != nil {
 		return "", "", err
 	}
@@ -73,22 +125,116 @@ func (gc *GithubClient) OpenCodeChangeRequest(req llm.CodeChangeRequest, res llm
 	url = pr.GetHTMLURL()
 	id = strconv.Itoa(int(pr.GetID()))
 
+	err = gc.applyPullRequestOptions(pr.GetNumber(), options)
+	if err != nil {
+		return id, url, fmt.Errorf("opened %s, but could not apply options: %w", url, err)
+	}
+
 	return id, url, nil
 }
 
+// createDraftPullRequest opens a draft pull request.
+func (gc *GithubClient) createDraftPullRequest(newPR *github.NewPullRequest) (*github.PullRequest, error) {
+	u := fmt.Sprintf("repos/%s/%s/pulls", gc.repo.Owner.Handle, gc.repo.Name)
+	draftPR := struct {
+		*github.NewPullRequest
+		Draft bool `json:"draft"`
+	}{newPR, true}
+	req, err := gc.newPreviewRequest("POST", u, draftPR, "application/vnd.github.shadow-cat-preview+json")
+	if err != nil {
+		return nil, err
+	}
+	pr := new(github.PullRequest)
+	_, err = gc.client.Do(gc.ctx, req, pr)
+	if err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// applyPullRequestOptions requests reviews, and sets the labels, milestone and assignees of a pull request.
+func (gc *GithubClient) applyPullRequestOptions(number int, options PullRequestOptions) error {
+	if len(options.Reviewers) > 0 || len(options.TeamReviewers) > 0 {
+		_, _, err := gc.client.PullRequests.RequestReviewers(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, github.ReviewersRequest{
+			Reviewers:     options.Reviewers,
+			TeamReviewers: options.TeamReviewers,
+		})
+		if err != nil {
+			return fmt.Errorf("requesting reviewers: %w", err)
+		}
+	}
+
+	if len(options.Labels) > 0 {
+		_, _, err := gc.client.Issues.AddLabelsToIssue(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, options.Labels)
+		if err != nil {
+			return fmt.Errorf("adding labels: %w", err)
+		}
+	}
+
+	// milestones and assignees are set on the issue underlying the pull request
+	edit := &github.IssueRequest{}
+	changed := false
+	if options.Milestone != "" {
+		milestone, err := gc.milestoneNumber(options.Milestone)
+		if err != nil {
+			return err
+		}
+		edit.Milestone = &milestone
+		changed = true
+	}
+	if len(options.Assignees) > 0 {
+		edit.Assignees = &options.Assignees
+		changed = true
+	}
+	if changed {
+		_, _, err := gc.client.Issues.Edit(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, edit)
+		if err != nil {
+			return fmt.Errorf("setting milestone and assignees: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// milestoneNumber returns the number of the milestone with the provided title or number.
+func (gc *GithubClient) milestoneNumber(milestone string) (int, error) {
+	if number, err := strconv.Atoi(milestone); err == nil {
+		return number, nil
+	}
+
+	opt := &github.MilestoneListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		milestones, resp, err := gc.client.Issues.ListMilestones(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range milestones {
+			if strings.EqualFold(m.GetTitle(), milestone) {
+				return m.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return 0, fmt.Errorf("no open milestone named %q", milestone)
+}
+
 // ListOpenIssues lists unresolved issues in the Github repository.
 func (gc *GithubClient) ListOpenIssues(options ListIssueOptions) ([]Issue, error) {
-	// List and parse GitHub issues
-	opt := &github.IssueListByRepoOptions{
-		Labels: options.Labels,
-	}
-	issues, _, err := gc.client.Issues.ListByRepo(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, opt)
+	issues, err := gc.listOpenIssues(options.Labels)
 	if err != nil {
 		return nil, err
 	}
 
 	toReturn := []Issue{}
 	for _, issue := range issues {
+		// the issues API also returns pull requests
+		if issue.IsPullRequest() {
+			continue
+		}
+
 		issueUser := issue.GetUser().GetLogin()
 		allowedUser := false
 		for _, u := range options.Handles {
@@ -101,24 +247,53 @@ func (gc *GithubClient) ListOpenIssues(options ListIssueOptions) ([]Issue, error
 			continue
 		}
 
-		nextIssue := Issue{
-			Number:  issue.GetNumber(),
-			Subject: issue.GetTitle(),
-			Body:    issue.GetBody(),
-			URL:     issue.GetHTMLURL(),
-			Author: Author{
-				Email:  issue.GetUser().GetEmail(),
-				Handle: issue.GetUser().GetLogin(),
-			},
-		}
-		toReturn = append(toReturn, nextIssue)
+		toReturn = append(toReturn, newIssue(issue))
 	}
 
 	return toReturn, nil
 }
 
+// listOpenIssues lists every open issue in the repository with all of the labels provided, including pull requests.
+func (gc *GithubClient) listOpenIssues(labels []string) ([]*github.Issue, error) {
+	opt := &github.IssueListByRepoOptions{
+		State:       "open",
+		Labels:      labels,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	issues := []*github.Issue{}
+	for {
+		page, resp, err := gc.client.Issues.ListByRepo(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, opt)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return issues, nil
+}
+
+func newIssue(issue *github.Issue) Issue {
+	return Issue{
+		Number:  issue.GetNumber(),
+		Subject: issue.GetTitle(),
+		Body:    issue.GetBody(),
+		URL:     issue.GetHTMLURL(),
+		Author: Author{
+			Email:  issue.GetUser().GetEmail(),
+			Handle: issue.GetUser().GetLogin(),
+		},
+	}
+}
+
 // CommentOnIssue adds a comment to the issue provided.
 func (gc *GithubClient) CommentOnIssue(issueNumber int, comment string) error {
+	if gc.dryRun != nil {
+		_, err := gc.CreateIssueComment(issueNumber, comment)
+		return err
+	}
 	ghComment := &github.IssueComment{
 		Body: github.String(comment),
 	}
@@ -128,8 +303,60 @@ func (gc *GithubClient) CommentOnIssue(issueNumber int, comment string) error {
 	return err
 }
 
+// CreateIssueComment comments on an issue or pull request, and returns the ID of the comment so that it can be edited.
+func (gc *GithubClient) CreateIssueComment(issueNumber int, body string) (int64, error) {
+	if gc.dryRun != nil {
+		id := gc.dryRun.newComment(issueNumber)
+		return id, gc.dryRun.Record(gc.repo.FullName(), gc.dryRun.commentName(id), body)
+	}
+	comment, _, err := gc.client.Issues.CreateComment(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, issueNumber, &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return comment.GetID(), nil
+}
+
+// EditIssueComment replaces the body of a comment on an issue or pull request.
+func (gc *GithubClient) EditIssueComment(commentID int64, body string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Record(gc.repo.FullName(), gc.dryRun.commentName(commentID), body)
+	}
+	_, _, err := gc.client.Issues.EditComment(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, commentID, &github.IssueComment{
+		Body: &body,
+	})
+	return err
+}
+
+// AddReactionToIssue reacts to an issue or pull request, e.g. with "eyes".
+func (gc *GithubClient) AddReactionToIssue(issueNumber int, content string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "react to #%d with %q", issueNumber, content)
+	}
+	u := fmt.Sprintf("repos/%s/%s/issues/%d/reactions", gc.repo.Owner.Handle, gc.repo.Name, issueNumber)
+	req, err := gc.newPreviewRequest("POST", u, &github.Reaction{Content: &content}, "application/vnd.github.squirrel-girl-preview+json")
+	if err != nil {
+		return err
+	}
+	_, err = gc.client.Do(gc.ctx, req, nil)
+	return err
+}
+
+// AddLabelsToIssue adds labels to an issue or pull request.
+func (gc *GithubClient) AddLabelsToIssue(issueNumber int, labels []string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "add labels %q to #%d", labels, issueNumber)
+	}
+	_, _, err := gc.client.Issues.AddLabelsToIssue(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, issueNumber, labels)
+	return err
+}
+
 // RemoveLabelFromIssue removes the provided label from an issue if that label is applied.
 func (gc *GithubClient) RemoveLabelFromIssue(issueNumber int, label string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "remove label %q from #%d", label, issueNumber)
+	}
 	hasLabel := false
 	labels, _, err := gc.client.Issues.ListLabelsByIssue(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, issueNumber, nil)
 	if err != nil {
@@ -152,80 +379,699 @@ func (gc *GithubClient) RemoveLabelFromIssue(issueNumber int, label string) erro
 
 // ListOpenComments lists unresolved comments in the Github repository.
 func (gc *GithubClient) ListOpenComments(options ListCommentOptions) ([]Comment, error) {
-	prs, _, err := gc.client.PullRequests.List(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, nil)
+	prs, err := gc.listOpenPullRequests()
 	if err != nil {
 		return nil, err
 	}
 
-	allComments := []Comment{}
-	repliedTo := make(map[int64]bool)
+	toReturn := []Comment{}
+	openPRs := make(map[int]bool)
 
 	for _, pr := range prs {
 		if pr.GetUser().GetLogin() != gc.self.Handle {
 			continue
 		}
+		openPRs[pr.GetNumber()] = true
+
+		comments, err := gc.openReviewComments(pr, options)
+		if err != nil {
+			return nil, err
+		}
+		toReturn = append(toReturn, comments...)
+	}
+
+	// forget comments on pull requests that have been closed
+	for number := range gc.prComments {
+		if !openPRs[number] {
+			delete(gc.prComments, number)
+		}
+	}
+
+	return toReturn, nil
+}
+
+// ListOpenReviewComments lists the unresolved comments submitted in a review of a pull request opened by the bot. If
+// reviewID is 0, only the unresolved comment with commentID is listed.
+func (gc *GithubClient) ListOpenReviewComments(prNumber int, reviewID, commentID int64, options ListCommentOptions) ([]Comment, error) {
+	pr, _, err := gc.client.PullRequests.Get(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	if pr.GetState() != "open" || pr.GetUser().GetLogin() != gc.self.Handle {
+		return nil, nil
+	}
+	comments, err := gc.openReviewComments(pr, options)
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := []Comment{}
+	for _, c := range comments {
+		if (reviewID != 0 && c.ReviewID == reviewID) || (reviewID == 0 && c.ID == commentID) {
+			toReturn = append(toReturn, c)
+		}
+	}
+	return toReturn, nil
+}
+
+// openReviewComments lists the latest comment of each review thread on pr that needs a response, with the earlier
+// comments in its thread.
+func (gc *GithubClient) openReviewComments(pr *github.PullRequest, options ListCommentOptions) ([]Comment, error) {
+	branch := ""
+	if pr.Head != nil {
+		branch = pr.Head.GetLabel()
+		if strings.Contains(branch, ":") {
+			branch = strings.Split(branch, ":")[1]
+		}
+	}
 
-		branch := ""
-		if pr.Head != nil {
-			branch = pr.Head.GetLabel()
-			if strings.Contains(branch, ":") {
-				branch = strings.Split(branch, ":")[1]
+	comments, err := gc.listPRComments(pr.GetNumber())
+	if err != nil {
+		return nil, err
+	}
+
+	// group comments into review threads - on Github, every reply is in reply to the first comment of its thread
+	threads := make(map[int64][]*github.PullRequestComment)
+	roots := []int64{}
+	for _, c := range comments {
+		root := c.GetInReplyTo()
+		if root == 0 {
+			root = c.GetID()
+		}
+		if _, ok := threads[root]; !ok {
+			roots = append(roots, root)
+		}
+		threads[root] = append(threads[root], c)
+	}
+
+	toReturn := []Comment{}
+	for _, root := range roots {
+		// only comments from the bot and allowed users are part of the conversation
+		thread := []Comment{}
+		for _, c := range threads[root] {
+			commentUser := c.GetUser().GetLogin()
+			if commentUser != gc.self.Handle && !containsHandle(options.Handles, commentUser) {
+				continue
 			}
+			thread = append(thread, newReviewComment(pr, branch, c))
+		}
+
+		// the thread needs a response if its latest comment was not written by the bot
+		if len(thread) == 0 {
+			continue
+		}
+		latest := thread[len(thread)-1]
+		if latest.Author.Handle == gc.self.Handle {
+			continue
+		}
+		latest.Thread = thread[:len(thread)-1]
+		latest.Issue = pullRequestIssue(pr)
+		toReturn = append(toReturn, latest)
+	}
+	return toReturn, nil
+}
+
+// newPreviewRequest creates an API request with a preview media type, for endpoints and fields that this version of
+// the Github client does not support.
+func (gc *GithubClient) newPreviewRequest(method, u string, body interface{}, mediaType string) (*http.Request, error) {
+	req, err := gc.client.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaType)
+	return req, nil
+}
+
+// containsHandle returns true if handle is in handles.
+func containsHandle(handles []string, handle string) bool {
+	for _, h := range handles {
+		if h == handle {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReviewComment gets a review comment, with the earlier comments in its thread from the bot and the users in
+// options. Unlike ListOpenComments, the comment is returned even if it has already been responded to.
+func (gc *GithubClient) GetReviewComment(id int64, options ListCommentOptions) (Comment, error) {
+	c, _, err := gc.client.PullRequests.GetComment(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, id)
+	if err != nil {
+		return Comment{}, err
+	}
+	number, err := strconv.Atoi(path.Base(c.GetPullRequestURL()))
+	if err != nil {
+		return Comment{}, fmt.Errorf("finding the pull request of comment %d: %w", id, err)
+	}
+	pr, _, err := gc.client.PullRequests.Get(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number)
+	if err != nil {
+		return Comment{}, err
+	}
+	comments, err := gc.listPRComments(number)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	branch := pr.GetHead().GetRef()
+	root := c.GetInReplyTo()
+	if root == 0 {
+		root = c.GetID()
+	}
+	thread := []Comment{}
+	for _, tc := range comments {
+		if tc.GetID() == c.GetID() {
+			break
+		}
+		if tc.GetID() != root && tc.GetInReplyTo() != root {
+			continue
+		}
+		commentUser := tc.GetUser().GetLogin()
+		if commentUser != gc.self.Handle && !containsHandle(options.Handles, commentUser) {
+			continue
+		}
+		thread = append(thread, newReviewComment(pr, branch, tc))
+	}
+
+	comment := newReviewComment(pr, branch, c)
+	comment.Thread = thread
+	comment.Issue = pullRequestIssue(pr)
+	return comment, nil
+}
+
+// newReviewComment converts a review comment left on pr, whose branch is provided.
+func newReviewComment(pr *github.PullRequest, branch string, c *github.PullRequestComment) Comment {
+	return Comment{
+		ID:       c.GetID(),
+		ChangeID: strconv.Itoa(pr.GetNumber()),
+		URL:      c.GetHTMLURL(),
+		Author: Author{
+			Email:  c.GetUser().GetEmail(),
+			Handle: c.GetUser().GetLogin(),
+		},
+		Body:     c.GetBody(),
+		FilePath: c.GetPath(),
+		Position: c.GetPosition(),
+		DiffHunk: c.GetDiffHunk(),
+		Branch:   branch,
+		PRNumber: pr.GetNumber(),
+		ReviewID: c.GetPullRequestReviewID(),
+	}
+}
+
+// pullRequestIssue returns a pull request as the issue review comments on it are left on.
+func pullRequestIssue(pr *github.PullRequest) Issue {
+	return Issue{
+		Number:  pr.GetNumber(),
+		Subject: pr.GetTitle(),
+		Body:    pr.GetBody(),
+		URL:     pr.GetHTMLURL(),
+	}
+}
+
+// ListOpenIssueComments lists comments in the conversations of open issues and pull requests that need a response.
+// Only conversations the bot is part of are considered: pull requests opened by the bot, and issues the bot has
+// commented on. A conversation needs a response if its latest comment from an allowed user was left after the bot's
+// latest comment.
+func (gc *GithubClient) ListOpenIssueComments(options ListCommentOptions) ([]Comment, error) {
+	issues, err := gc.listOpenIssues(nil)
+	if err != nil {
+		return nil, err
+	}
+	prs, err := gc.listOpenPullRequests()
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[int]string)
+	for _, pr := range prs {
+		branches[pr.GetNumber()] = pr.GetHead().GetRef()
+	}
+	comments, err := gc.listIssueComments()
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := []Comment{}
+	openIssues := make(map[int]bool)
+	for _, issue := range issues {
+		openIssues[issue.GetNumber()] = true
+		comment, ok := gc.openIssueComment(issue, comments[issue.GetNumber()], branches[issue.GetNumber()], options)
+		if ok {
+			toReturn = append(toReturn, comment)
+		}
+	}
+
+	// forget comments on issues that have been closed
+	for number := range gc.issueComments.comments {
+		if !openIssues[number] {
+			delete(gc.issueComments.comments, number)
+		}
+	}
+
+	return toReturn, nil
+}
+
+// GetOpenIssueComment gets the latest comment in the conversation of an open issue or pull request, if it needs a
+// response, like ListOpenIssueComments. Only the comments on that issue are fetched.
+func (gc *GithubClient) GetOpenIssueComment(number int, options ListCommentOptions) (Comment, bool, error) {
+	issue, _, err := gc.client.Issues.Get(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number)
+	if err != nil {
+		return Comment{}, false, err
+	}
+	if issue.GetState() != "open" {
+		return Comment{}, false, nil
+	}
+	branch := ""
+	if issue.IsPullRequest() {
+		pr, _, err := gc.client.PullRequests.Get(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number)
+		if err != nil {
+			return Comment{}, false, err
 		}
+		branch = pr.GetHead().GetRef()
+	}
 
-		comments, _, err := gc.client.PullRequests.ListComments(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, pr.GetNumber(), nil)
+	comments := []*github.IssueComment{}
+	opt := &github.IssueListCommentsOptions{
+		Sort:        "created",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := gc.client.Issues.ListComments(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, opt)
 		if err != nil {
-			return nil, err
+			return Comment{}, false, err
 		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
 
-		for _, c := range comments {
-			commentUser := c.GetUser().GetLogin()
-			if commentUser == gc.self.Handle {
-				repliedTo[c.GetInReplyTo()] = true
+	comment, ok := gc.openIssueComment(issue, comments, branch, options)
+	return comment, ok, nil
+}
+
+// openIssueComment returns the latest of the comments in the conversation of issue, sorted by creation time, if it
+// needs a response. The branch is set on comments on pull requests.
+func (gc *GithubClient) openIssueComment(issue *github.Issue, comments []*github.IssueComment, branch string, options ListCommentOptions) (Comment, bool) {
+	number := issue.GetNumber()
+	isPR := issue.IsPullRequest()
+	if isPR && issue.GetUser().GetLogin() != gc.self.Handle {
+		return Comment{}, false
+	}
+
+	commentType := CommentIssue
+	prNumber := 0
+	if isPR {
+		commentType = CommentPullRequest
+		prNumber = number
+	}
+
+	conversation := []Comment{}
+	botCommented := false
+	for _, c := range comments {
+		commentUser := c.GetUser().GetLogin()
+		if commentUser == gc.self.Handle {
+			botCommented = true
+		} else if !containsHandle(options.Handles, commentUser) {
+			continue
+		}
+		conversation = append(conversation, Comment{
+			ID:       c.GetID(),
+			ChangeID: strconv.Itoa(number),
+			URL:      c.GetHTMLURL(),
+			Author: Author{
+				Email:  c.GetUser().GetEmail(),
+				Handle: commentUser,
+			},
+			Body:        c.GetBody(),
+			Branch:      branch,
+			PRNumber:    prNumber,
+			Type:        commentType,
+			IssueNumber: number,
+		})
+	}
+
+	if len(conversation) == 0 {
+		return Comment{}, false
+	}
+	latest := conversation[len(conversation)-1]
+	alwaysInclude := options.AlwaysInclude != nil && options.AlwaysInclude(latest.Body)
+	if !isPR && !botCommented && !alwaysInclude {
+		return Comment{}, false
+	}
+	if latest.Author.Handle == gc.self.Handle {
+		return Comment{}, false
+	}
+	latest.Thread = conversation[:len(conversation)-1]
+	latest.Issue = newIssue(issue)
+	return latest, true
+}
+
+// listIssueComments lists comments on issues and pull request conversations in the repository, grouped by issue
+// number and sorted by creation time. Every comment is fetched the first time, so that whole conversations are known,
+// and like listPRComments, only new or updated comments are fetched from Github after that.
+func (gc *GithubClient) listIssueComments() (map[int][]*github.IssueComment, error) {
+	cache := gc.issueComments
+
+	opt := &github.IssueListCommentsOptions{
+		Sort:        "updated",
+		Direction:   "asc",
+		Since:       cache.lastUpdated,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		// an issue number of 0 lists comments on every issue in the repository
+		page, resp, err := gc.client.Issues.ListComments(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, 0, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			number, err := strconv.Atoi(path.Base(c.GetIssueURL()))
+			if err != nil {
+				gc.log.Warn("could not parse issue number of comment", zap.String("url", c.GetIssueURL()))
+				continue
 			}
-			allowedUser := false
-			for _, u := range options.Handles {
-				if commentUser == u {
-					allowedUser = true
-					break
-				}
+			if cache.comments[number] == nil {
+				cache.comments[number] = make(map[int64]*github.IssueComment)
 			}
-			if !allowedUser {
-				continue
+			cache.comments[number][c.GetID()] = c
+			if c.GetUpdatedAt().After(cache.lastUpdated) {
+				cache.lastUpdated = c.GetUpdatedAt()
 			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
 
-			nextComment := Comment{
-				ID:       c.GetID(),
-				ChangeID: strconv.Itoa(pr.GetNumber()),
-				URL:      c.GetHTMLURL(),
-				Author: Author{
-					Email:  c.GetUser().GetEmail(),
-					Handle: c.GetUser().GetLogin(),
-				},
-				Body:     c.GetBody(),
-				FilePath: c.GetPath(),
-				Position: c.GetPosition(),
-				DiffHunk: c.GetDiffHunk(),
-				Branch:   branch,
-				PRNumber: pr.GetNumber(),
-			}
-			allComments = append(allComments, nextComment)
+	grouped := make(map[int][]*github.IssueComment)
+	for number, comments := range cache.comments {
+		for _, c := range comments {
+			grouped[number] = append(grouped[number], c)
 		}
+		sort.Slice(grouped[number], func(i, j int) bool {
+			return grouped[number][i].GetCreatedAt().Before(grouped[number][j].GetCreatedAt())
+		})
 	}
 
-	// remove any comments that bot has replied to already from the list
-	toReturn := []Comment{}
-	for _, c := range allComments {
-		if !repliedTo[c.ID] {
-			toReturn = append(toReturn, c)
+	return grouped, nil
+}
+
+// listOpenPullRequests lists every open pull request in the repository.
+func (gc *GithubClient) listOpenPullRequests() ([]*github.PullRequest, error) {
+	opt := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	prs := []*github.PullRequest{}
+	for {
+		page, resp, err := gc.client.PullRequests.List(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, opt)
+		if err != nil {
+			return nil, err
+		}
+		prs = append(prs, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return prs, nil
+}
+
+// listPRComments lists every review comment on a pull request, sorted by creation time.
+// Only comments created or updated since the latest cached comment are fetched from Github. Since the request
+// does not change until new comments arrive, repeated calls can be served with conditional requests.
+func (gc *GithubClient) listPRComments(number int) ([]*github.PullRequestComment, error) {
+	cache, ok := gc.prComments[number]
+	if !ok {
+		cache = &prCommentCache{
+			comments: make(map[int64]*github.PullRequestComment),
+		}
+	}
+
+	opt := &github.PullRequestListCommentsOptions{
+		Sort:        "created",
+		Direction:   "asc",
+		Since:       cache.lastUpdated,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := gc.client.PullRequests.ListComments(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			cache.comments[c.GetID()] = c
+			if c.GetUpdatedAt().After(cache.lastUpdated) {
+				cache.lastUpdated = c.GetUpdatedAt()
+			}
+		}
+		if resp.NextPage == 0 {
+			break
 		}
+		opt.Page = resp.NextPage
 	}
+	gc.prComments[number] = cache
 
+	comments := make([]*github.PullRequestComment, 0, len(cache.comments))
+	for _, c := range cache.comments {
+		comments = append(comments, c)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].GetCreatedAt().Before(comments[j].GetCreatedAt())
+	})
+
+	return comments, nil
+}
+
+// GetIssue gets an issue, regardless of its labels or author.
+func (gc *GithubClient) GetIssue(number int) (Issue, error) {
+	issue, _, err := gc.client.Issues.Get(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number)
+	if err != nil {
+		return Issue{}, err
+	}
+	return newIssue(issue), nil
+}
+
+// ListOwnPullRequests lists the open pull requests opened by the bot.
+func (gc *GithubClient) ListOwnPullRequests() ([]PullRequest, error) {
+	prs, err := gc.listOpenPullRequests()
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := []PullRequest{}
+	for _, pr := range prs {
+		if pr.GetUser().GetLogin() != gc.self.Handle {
+			continue
+		}
+		toReturn = append(toReturn, PullRequest{
+			Number:     pr.GetNumber(),
+			Subject:    pr.GetTitle(),
+			Body:       pr.GetBody(),
+			URL:        pr.GetHTMLURL(),
+			Branch:     pr.GetHead().GetRef(),
+			BaseBranch: pr.GetBase().GetRef(),
+		})
+	}
 	return toReturn, nil
 }
 
+// ClosePullRequest closes a pull request without merging it.
+func (gc *GithubClient) ClosePullRequest(number int) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "close #%d", number)
+	}
+	_, _, err := gc.client.PullRequests.Edit(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, &github.PullRequest{
+		State: github.String("closed"),
+	})
+	return err
+}
+
+// maxStatusDescription is the maximum length of a commit status description allowed by Github.
+const maxStatusDescription = 140
+
+// maxAnnotations is the maximum number of annotations Github accepts in a single check run request.
+const maxAnnotations = 50
+
+// SetStatus reports the status of a commit. If checks is true, the status is reported as a check run, which supports
+// summaries and annotations but requires authenticating as a Github App. Otherwise, it is reported as a commit status.
+func (gc *GithubClient) SetStatus(sha string, status Status, checks bool) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "set status of %s to %s: %s", sha, status.State, status.Description)
+	}
+	if checks {
+		return gc.createCheckRun(sha, status)
+	}
+
+	description := status.Description
+	if len(description) > maxStatusDescription {
+		description = description[:maxStatusDescription-3] + "..."
+	}
+	_, _, err := gc.client.Repositories.CreateStatus(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, sha, &github.RepoStatus{
+		State:       github.String(string(status.State)),
+		Description: &description,
+		Context:     &status.Name,
+	})
+	return err
+}
+
+// checkRunAnnotation is the representation of an annotation expected by the current check runs API, which differs
+// from the one in this version of the Github client.
+type checkRunAnnotation struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Level     string `json:"annotation_level"`
+	Message   string `json:"message"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Annotations []checkRunAnnotation `json:"annotations,omitempty"`
+}
+
+type checkRun struct {
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	Status     string          `json:"status"`
+	Conclusion string          `json:"conclusion,omitempty"`
+	Output     *checkRunOutput `json:"output,omitempty"`
+}
+
+// createCheckRun creates a check run for a commit. Check runs with the same name replace earlier ones on Github.
+func (gc *GithubClient) createCheckRun(sha string, status Status) error {
+	run := checkRun{
+		Name:    status.Name,
+		HeadSHA: sha,
+		Status:  "in_progress",
+		Output: &checkRunOutput{
+			Title:   status.Description,
+			Summary: status.Summary,
+		},
+	}
+	if run.Output.Summary == "" {
+		run.Output.Summary = status.Description
+	}
+	if status.State != StatusPending {
+		run.Status = "completed"
+		run.Conclusion = string(status.State)
+	}
+	for i, a := range status.Annotations {
+		if i == maxAnnotations {
+			break
+		}
+		run.Output.Annotations = append(run.Output.Annotations, checkRunAnnotation{
+			Path:      a.Path,
+			StartLine: a.Line,
+			EndLine:   a.Line,
+			Level:     "failure",
+			Message:   a.Message,
+		})
+	}
+
+	u := fmt.Sprintf("repos/%s/%s/check-runs", gc.repo.Owner.Handle, gc.repo.Name)
+	req, err := gc.newPreviewRequest("POST", u, run, "application/vnd.github.antiope-preview+json")
+	if err != nil {
+		return err
+	}
+	_, err = gc.client.Do(gc.ctx, req, nil)
+	return err
+}
+
+// EditPullRequest replaces the title and body of a pull request.
+func (gc *GithubClient) EditPullRequest(number int, title, body string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Record(gc.repo.FullName(), fmt.Sprintf("pull-request-%d.md", number), fmt.Sprintf("# %s\n\n%s", title, body))
+	}
+	_, _, err := gc.client.PullRequests.Edit(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, &github.PullRequest{
+		Title: &title,
+		Body:  &body,
+	})
+	return err
+}
+
+// BranchExists returns true if the Github repository has a branch with the provided name.
+func (gc *GithubClient) BranchExists(name string) (bool, error) {
+	_, resp, err := gc.client.Repositories.GetBranch(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, name)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdatePullRequestBranch merges the latest changes from the base branch of a pull request into its branch.
+func (gc *GithubClient) UpdatePullRequestBranch(number int) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Action(gc.repo.FullName(), "update the branch of #%d", number)
+	}
+	u := fmt.Sprintf("repos/%s/%s/pulls/%d/update-branch", gc.repo.Owner.Handle, gc.repo.Name, number)
+	req, err := gc.newPreviewRequest("PUT", u, nil, "application/vnd.github.lydian-preview+json")
+	if err != nil {
+		return err
+	}
+	_, err = gc.client.Do(gc.ctx, req, nil)
+	return err
+}
+
+// GetPullRequestDiff gets the diff of a pull request.
+func (gc *GithubClient) GetPullRequestDiff(number int) (string, error) {
+	diff, _, err := gc.client.PullRequests.GetRaw(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, number, github.RawOptions{Type: github.Diff})
+	return diff, err
+}
+
+// ListPullRequestFiles lists the paths of the files added or modified by a pull request.
+func (gc *GithubClient) ListPullRequestFiles(prNumber int) ([]string, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	paths := []string{}
+	for {
+		files, resp, err := gc.client.PullRequests.ListFiles(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, prNumber, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.GetStatus() == "removed" {
+				continue
+			}
+			paths = append(paths, f.GetFilename())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return paths, nil
+}
+
+// GetReview gets a pull request review.
+func (gc *GithubClient) GetReview(prNumber int, reviewID int64) (Review, error) {
+	review, _, err := gc.client.PullRequests.GetReview(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, prNumber, reviewID)
+	if err != nil {
+		return Review{}, err
+	}
+
+	return Review{
+		ID: review.GetID(),
+		Author: Author{
+			Email:  review.GetUser().GetEmail(),
+			Handle: review.GetUser().GetLogin(),
+		},
+		Body:  review.GetBody(),
+		State: review.GetState(),
+	}, nil
+}
+
 // RespondToComment adds a comment to the provided thread.
 func (gc *GithubClient) RespondToComment(prNumber int, commentID int64, comment string) error {
+	if gc.dryRun != nil {
+		return gc.dryRun.Record(gc.repo.FullName(), fmt.Sprintf("pull-request-%d-reply-%d.md", prNumber, commentID), comment)
+	}
 	_, _, err := gc.client.PullRequests.CreateCommentInReplyTo(gc.ctx, gc.repo.Owner.Handle, gc.repo.Name, prNumber, comment, commentID)
 	if err != nil {
 		return err
@@ -0,0 +1,651 @@
+package vc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mobyvb/pull-pal/llm"
+
+	"github.com/google/go-github/github"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestGithubClient returns a client for owner/repo, acting as "bot", that sends requests to handler.
+func newTestGithubClient(t *testing.T, handler http.Handler) *GithubClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return &GithubClient{
+		ctx:        context.Background(),
+		log:        zap.NewNop(),
+		client:     client,
+		self:       Author{Handle: "bot"},
+		repo:       Repository{Name: "repo", Owner: Author{Handle: "owner"}},
+		prComments: make(map[int]*prCommentCache),
+		issueComments: &issueCommentCache{
+			comments: make(map[int]map[int64]*github.IssueComment),
+		},
+	}
+}
+
+// reviewComment is the JSON representation of a review comment returned by Github.
+type reviewComment struct {
+	ID        int64             `json:"id"`
+	InReplyTo int64             `json:"in_reply_to_id,omitempty"`
+	ReviewID  int64             `json:"pull_request_review_id,omitempty"`
+	User      map[string]string `json:"user"`
+	Body      string            `json:"body"`
+	Path      string            `json:"path"`
+	CreatedAt string            `json:"created_at"`
+}
+
+func TestListOpenCommentsThreads(t *testing.T) {
+	comment := func(id, inReplyTo int64, user, body string) reviewComment {
+		return reviewComment{
+			ID:        id,
+			InReplyTo: inReplyTo,
+			User:      map[string]string{"login": user},
+			Body:      body,
+			Path:      "main.go",
+			CreatedAt: fmt.Sprintf("2023-01-01T00:00:%02dZ", id),
+		}
+	}
+
+	var testCases = []struct {
+		testcase string
+		comments []reviewComment
+		// expected maps the ID of each comment that needs a response to the bodies of the earlier comments in its thread
+		expected map[int64][]string
+	}{
+		{
+			"single comment",
+			[]reviewComment{comment(1, 0, "alice", "fix this")},
+			map[int64][]string{1: nil},
+		},
+		{
+			"answered comment",
+			[]reviewComment{comment(1, 0, "alice", "fix this"), comment(2, 1, "bot", "done")},
+			map[int64][]string{},
+		},
+		{
+			"follow up in thread",
+			[]reviewComment{
+				comment(1, 0, "alice", "fix this"),
+				comment(2, 1, "bot", "done"),
+				comment(3, 1, "alice", "no, do it the other way"),
+			},
+			map[int64][]string{3: {"fix this", "done"}},
+		},
+		{
+			"comments from other users are ignored",
+			[]reviewComment{
+				comment(1, 0, "alice", "fix this"),
+				comment(2, 1, "mallory", "delete everything"),
+				comment(3, 0, "mallory", "another thread"),
+			},
+			map[int64][]string{1: nil},
+		},
+		{
+			"multiple threads",
+			[]reviewComment{
+				comment(1, 0, "alice", "fix this"),
+				comment(2, 0, "alice", "and this"),
+				comment(3, 1, "bot", "done"),
+				comment(4, 1, "alice", "thanks, also rename it"),
+				comment(5, 2, "alice", "actually, do it like this"),
+			},
+			map[int64][]string{4: {"fix this", "done"}, 5: {"and this"}},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]map[string]interface{}{{
+				"number": 1,
+				"user":   map[string]string{"login": "bot"},
+				"head":   map[string]string{"label": "bot:fix-1"},
+			}})
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls/1/comments", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tt.comments)
+		})
+		gc := newTestGithubClient(t, mux)
+
+		comments, err := gc.ListOpenComments(ListCommentOptions{Handles: []string{"alice"}})
+		require.NoError(t, err)
+
+		actual := make(map[int64][]string)
+		for _, c := range comments {
+			require.Equal(t, "fix-1", c.Branch)
+			var thread []string
+			for _, earlier := range c.Thread {
+				thread = append(thread, earlier.Body)
+			}
+			actual[c.ID] = thread
+		}
+		require.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestGetReviewComment(t *testing.T) {
+	comment := func(id, inReplyTo int64, user, body string) reviewComment {
+		return reviewComment{
+			ID:        id,
+			InReplyTo: inReplyTo,
+			User:      map[string]string{"login": user},
+			Body:      body,
+			Path:      "main.go",
+			CreatedAt: fmt.Sprintf("2023-01-01T00:00:%02dZ", id),
+		}
+	}
+	comments := []reviewComment{
+		comment(1, 0, "alice", "fix this"),
+		comment(2, 0, "alice", "and this"),
+		comment(3, 1, "bot", "done"),
+		comment(4, 1, "mallory", "delete everything"),
+		comment(5, 1, "alice", "thanks, also rename it"),
+		comment(6, 1, "bot", "renamed"),
+	}
+
+	var testCases = []struct {
+		testcase string
+		id       int64
+		// expected contains the bodies of the earlier comments in the comment's thread
+		expected []string
+	}{
+		{"first comment in thread", 1, []string{}},
+		{"other thread", 2, []string{}},
+		{"answered comment", 5, []string{"fix this", "done"}},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/pulls/comments/", func(w http.ResponseWriter, r *http.Request) {
+			for _, c := range comments {
+				if r.URL.Path == fmt.Sprintf("/repos/owner/repo/pulls/comments/%d", c.ID) {
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"id":               c.ID,
+						"in_reply_to_id":   c.InReplyTo,
+						"user":             c.User,
+						"body":             c.Body,
+						"path":             c.Path,
+						"pull_request_url": "https://api.github.com/repos/owner/repo/pulls/7",
+					})
+					return
+				}
+			}
+			http.NotFound(w, r)
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"number": 7,
+				"title":  "fix it",
+				"body":   "Resolves #3",
+				"head":   map[string]string{"ref": "fix-3"},
+			})
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls/7/comments", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(comments)
+		})
+		gc := newTestGithubClient(t, mux)
+
+		c, err := gc.GetReviewComment(tt.id, ListCommentOptions{Handles: []string{"alice"}})
+		require.NoError(t, err)
+		require.Equal(t, tt.id, c.ID)
+		require.Equal(t, 7, c.PRNumber)
+		require.Equal(t, "fix-3", c.Branch)
+		require.Equal(t, "Resolves #3", c.Issue.Body)
+		thread := []string{}
+		for _, earlier := range c.Thread {
+			thread = append(thread, earlier.Body)
+		}
+		require.Equal(t, tt.expected, thread)
+	}
+}
+
+func TestListOpenReviewComments(t *testing.T) {
+	comment := func(id, inReplyTo, reviewID int64, user string) reviewComment {
+		return reviewComment{
+			ID:        id,
+			InReplyTo: inReplyTo,
+			ReviewID:  reviewID,
+			User:      map[string]string{"login": user},
+			Body:      fmt.Sprintf("comment %d", id),
+			Path:      "main.go",
+			CreatedAt: fmt.Sprintf("2023-01-01T00:00:%02dZ", id),
+		}
+	}
+	comments := []reviewComment{
+		comment(1, 0, 10, "alice"),
+		comment(2, 0, 10, "alice"),
+		comment(3, 1, 11, "bot"),
+		comment(4, 0, 12, "alice"),
+	}
+
+	var testCases = []struct {
+		testcase  string
+		author    string
+		reviewID  int64
+		commentID int64
+		expected  []int64
+	}{
+		{"review", "bot", 10, 0, []int64{2}},
+		{"other review", "bot", 12, 0, []int64{4}},
+		{"answered review", "bot", 11, 0, []int64{}},
+		{"comment without a review", "bot", 0, 4, []int64{4}},
+		{"pull request opened by someone else", "alice", 12, 0, []int64{}},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"number": 7,
+				"state":  "open",
+				"user":   map[string]string{"login": tt.author},
+				"head":   map[string]string{"label": "bot:fix-3"},
+			})
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls/7/comments", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(comments)
+		})
+		gc := newTestGithubClient(t, mux)
+
+		open, err := gc.ListOpenReviewComments(7, tt.reviewID, tt.commentID, ListCommentOptions{Handles: []string{"alice"}})
+		require.NoError(t, err)
+		ids := []int64{}
+		for _, c := range open {
+			require.Equal(t, "fix-3", c.Branch)
+			ids = append(ids, c.ID)
+		}
+		require.Equal(t, tt.expected, ids)
+	}
+}
+
+func TestGetOpenIssueComment(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		state    string
+		pr       bool
+		comments []string
+		// expected is the ID of the comment that needs a response, or 0 if there is none
+		expected int64
+	}{
+		{"follow up on issue", "open", false, []string{"bot", "alice"}, 2},
+		{"answered issue", "open", false, []string{"alice", "bot"}, 0},
+		{"issue the bot has not commented on", "open", false, []string{"alice"}, 0},
+		{"closed issue", "closed", false, []string{"bot", "alice"}, 0},
+		{"pull request", "open", true, []string{"alice"}, 1},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/issues/5", func(w http.ResponseWriter, r *http.Request) {
+			issue := map[string]interface{}{
+				"number": 5,
+				"state":  tt.state,
+				"user":   map[string]string{"login": "bot"},
+			}
+			if tt.pr {
+				issue["pull_request"] = map[string]string{"url": "https://api.github.com/repos/owner/repo/pulls/5"}
+			}
+			json.NewEncoder(w).Encode(issue)
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls/5", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"number": 5, "head": map[string]string{"ref": "fix-5"}})
+		})
+		mux.HandleFunc("/repos/owner/repo/issues/5/comments", func(w http.ResponseWriter, r *http.Request) {
+			comments := []map[string]interface{}{}
+			for i, user := range tt.comments {
+				comments = append(comments, map[string]interface{}{
+					"id":         i + 1,
+					"user":       map[string]string{"login": user},
+					"body":       "hi",
+					"created_at": fmt.Sprintf("2023-01-01T00:00:%02dZ", i),
+				})
+			}
+			json.NewEncoder(w).Encode(comments)
+		})
+		gc := newTestGithubClient(t, mux)
+
+		c, ok, err := gc.GetOpenIssueComment(5, ListCommentOptions{Handles: []string{"alice"}})
+		require.NoError(t, err)
+		require.Equal(t, tt.expected != 0, ok)
+		if !ok {
+			continue
+		}
+		require.Equal(t, tt.expected, c.ID)
+		require.Equal(t, 5, c.IssueNumber)
+		if tt.pr {
+			require.Equal(t, CommentPullRequest, c.Type)
+			require.Equal(t, "fix-5", c.Branch)
+		}
+	}
+}
+
+func TestListOpenIssueComments(t *testing.T) {
+	comment := func(id int64, issue int, user, body string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"issue_url":  fmt.Sprintf("https://api.github.com/repos/owner/repo/issues/%d", issue),
+			"user":       map[string]string{"login": user},
+			"body":       body,
+			"created_at": fmt.Sprintf("2023-01-01T00:00:%02dZ", id),
+			"updated_at": fmt.Sprintf("2023-01-01T00:00:%02dZ", id),
+		}
+	}
+	issue := func(number int, user string, pr bool) map[string]interface{} {
+		i := map[string]interface{}{
+			"number": number,
+			"title":  fmt.Sprintf("issue %d", number),
+			"user":   map[string]string{"login": user},
+		}
+		if pr {
+			i["pull_request"] = map[string]string{"url": "https://api.github.com/repos/owner/repo/pulls/1"}
+		}
+		return i
+	}
+
+	var testCases = []struct {
+		testcase string
+		issues   []map[string]interface{}
+		comments []map[string]interface{}
+		// expected maps the ID of each comment that needs a response to the bodies of the earlier comments in its conversation
+		expected map[int64][]string
+	}{
+		{
+			"issue the bot has not commented on",
+			[]map[string]interface{}{issue(1, "alice", false)},
+			[]map[string]interface{}{comment(1, 1, "alice", "any update?")},
+			map[int64][]string{},
+		},
+		{
+			"follow up on issue the bot commented on",
+			[]map[string]interface{}{issue(1, "alice", false)},
+			[]map[string]interface{}{
+				comment(1, 1, "bot", "which file should I change?"),
+				comment(2, 1, "mallory", "all of them"),
+				comment(3, 1, "alice", "main.go"),
+			},
+			map[int64][]string{3: {"which file should I change?"}},
+		},
+		{
+			"answered issue comment",
+			[]map[string]interface{}{issue(1, "alice", false)},
+			[]map[string]interface{}{
+				comment(1, 1, "bot", "which file should I change?"),
+				comment(2, 1, "alice", "main.go"),
+				comment(3, 1, "bot", "thanks"),
+			},
+			map[int64][]string{},
+		},
+		{
+			"comment on bot pull request",
+			[]map[string]interface{}{issue(2, "bot", true), issue(3, "alice", true)},
+			[]map[string]interface{}{
+				comment(1, 2, "alice", "why did you do this?"),
+				comment(2, 3, "alice", "not the bot's pull request"),
+			},
+			map[int64][]string{1: nil},
+		},
+		{
+			"comment on closed issue",
+			[]map[string]interface{}{},
+			[]map[string]interface{}{
+				comment(1, 1, "bot", "which file should I change?"),
+				comment(2, 1, "alice", "main.go"),
+			},
+			map[int64][]string{},
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/issues", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tt.issues)
+		})
+		mux.HandleFunc("/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]map[string]interface{}{{
+				"number": 2,
+				"user":   map[string]string{"login": "bot"},
+				"head":   map[string]string{"ref": "fix-2"},
+			}})
+		})
+		mux.HandleFunc("/repos/owner/repo/issues/comments", func(w http.ResponseWriter, r *http.Request) {
+			// every comment is listed the first time, however old
+			require.Empty(t, r.URL.Query().Get("since"))
+			json.NewEncoder(w).Encode(tt.comments)
+		})
+		gc := newTestGithubClient(t, mux)
+
+		comments, err := gc.ListOpenIssueComments(ListCommentOptions{Handles: []string{"alice"}})
+		require.NoError(t, err)
+
+		actual := make(map[int64][]string)
+		for _, c := range comments {
+			if c.Type == CommentPullRequest {
+				require.Equal(t, "fix-2", c.Branch)
+				require.Equal(t, c.IssueNumber, c.PRNumber)
+			}
+			var thread []string
+			for _, earlier := range c.Thread {
+				thread = append(thread, earlier.Body)
+			}
+			actual[c.ID] = thread
+		}
+		require.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestOpenCodeChangeRequestOptions(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		options  PullRequestOptions
+		// expected maps each request made after opening the pull request to its JSON body
+		expected map[string]string
+		draft    bool
+	}{
+		{
+			"no options",
+			PullRequestOptions{},
+			map[string]string{},
+			false,
+		},
+		{
+			"all options",
+			PullRequestOptions{
+				Draft:         true,
+				Reviewers:     []string{"alice"},
+				TeamReviewers: []string{"core"},
+				Labels:        []string{"bot"},
+				Milestone:     "v1",
+				Assignees:     []string{"bob"},
+			},
+			map[string]string{
+				"POST /repos/owner/repo/pulls/7/requested_reviewers": `{"reviewers":["alice"],"team_reviewers":["core"]}`,
+				"POST /repos/owner/repo/issues/7/labels":             `["bot"]`,
+				"PATCH /repos/owner/repo/issues/7":                   `{"assignees":["bob"],"milestone":3}`,
+			},
+			true,
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		actual := make(map[string]string)
+		draft := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			draft, _ = body["draft"].(bool)
+			json.NewEncoder(w).Encode(map[string]interface{}{"number": 7, "html_url": "https://github.com/owner/repo/pull/7"})
+		})
+		mux.HandleFunc("/repos/owner/repo/milestones", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"number": 2, "title": "v0"}, {"number": 3, "title": "V1"}})
+		})
+		record := func(w http.ResponseWriter, r *http.Request) {
+			var body interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			encoded, err := json.Marshal(body)
+			require.NoError(t, err)
+			actual[r.Method+" "+r.URL.Path] = string(encoded)
+			w.Write([]byte("{}"))
+		}
+		mux.HandleFunc("/repos/owner/repo/pulls/7/requested_reviewers", record)
+		mux.HandleFunc("/repos/owner/repo/issues/7/labels", func(w http.ResponseWriter, r *http.Request) {
+			record(httptest.NewRecorder(), r)
+			w.Write([]byte("[]"))
+		})
+		mux.HandleFunc("/repos/owner/repo/issues/7", record)
+		gc := newTestGithubClient(t, mux)
+
+		_, url, err := gc.OpenCodeChangeRequest(llm.CodeChangeRequest{Subject: "fix", BaseBranch: "main"}, "body", "fix-1", tt.options)
+		require.NoError(t, err)
+		require.Equal(t, "https://github.com/owner/repo/pull/7", url)
+		require.Equal(t, tt.draft, draft)
+		require.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	status := Status{
+		Name:        "pull-pal",
+		State:       StatusFailure,
+		Description: "failed: build error",
+		Summary:     "output",
+		Annotations: []Annotation{{Path: "main.go", Line: 3, Message: "undefined: foo"}},
+	}
+
+	var testCases = []struct {
+		testcase string
+		status   Status
+		checks   bool
+		path     string
+		expected string
+	}{
+		{
+			"commit status",
+			status,
+			false,
+			"/repos/owner/repo/statuses/abc",
+			`{"context":"pull-pal","description":"failed: build error","state":"failure"}`,
+		},
+		{
+			"check run",
+			status,
+			true,
+			"/repos/owner/repo/check-runs",
+			`{"conclusion":"failure","head_sha":"abc","name":"pull-pal","output":{"annotations":[{"annotation_level":"failure","end_line":3,"message":"undefined: foo","path":"main.go","start_line":3}],"summary":"output","title":"failed: build error"},"status":"completed"}`,
+		},
+		{
+			"pending check run",
+			Status{Name: "pull-pal", State: StatusPending, Description: "generating"},
+			true,
+			"/repos/owner/repo/check-runs",
+			`{"head_sha":"abc","name":"pull-pal","output":{"summary":"generating","title":"generating"},"status":"in_progress"}`,
+		},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		actual := ""
+		mux := http.NewServeMux()
+		mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+			var body interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			encoded, err := json.Marshal(body)
+			require.NoError(t, err)
+			actual = string(encoded)
+			w.Write([]byte("{}"))
+		})
+		gc := newTestGithubClient(t, mux)
+
+		require.NoError(t, gc.SetStatus("abc", tt.status, tt.checks))
+		require.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestAddReactionToIssue(t *testing.T) {
+	var accept, body string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/issues/3/reactions", func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		var reaction map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reaction))
+		body, _ = reaction["content"].(string)
+		w.Write([]byte("{}"))
+	})
+	gc := newTestGithubClient(t, mux)
+
+	require.NoError(t, gc.AddReactionToIssue(3, "eyes"))
+	require.Equal(t, "eyes", body)
+	require.Equal(t, "application/vnd.github.squirrel-girl-preview+json", accept)
+}
+
+func TestDryRun(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request in dry run: %s %s", r.Method, r.URL.Path)
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		gc := newTestGithubClient(t, mux)
+		gc.SetDryRun(NewDryRun(dir, nil))
+
+		id, err := gc.CreateIssueComment(3, "working on it")
+		require.NoError(t, err)
+		require.NoError(t, gc.EditIssueComment(id, "done"))
+		require.NoError(t, gc.RemoveLabelFromIssue(3, "pullpal"))
+		require.NoError(t, gc.AddLabelsToIssue(3, []string{"pullpal:done"}))
+		_, url, err := gc.OpenCodeChangeRequest(llm.CodeChangeRequest{Subject: "fix it", BaseBranch: "main"}, "the body", "pullpal/issue-3", PullRequestOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "dry run: pull-request-pullpal-issue-3.md", url)
+
+		comment, err := os.ReadFile(filepath.Join(dir, "owner", "repo", fmt.Sprintf("issue-3-comment-%d.md", id)))
+		require.NoError(t, err)
+		require.Equal(t, "done", string(comment))
+
+		pr, err := os.ReadFile(filepath.Join(dir, "owner", "repo", "pull-request-pullpal-issue-3.md"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(pr), "# fix it\n\npullpal/issue-3 -> main\n"))
+		require.True(t, strings.HasSuffix(string(pr), "\n\nthe body"))
+
+		actions, err := os.ReadFile(filepath.Join(dir, "owner", "repo", "actions.log"))
+		require.NoError(t, err)
+		require.Contains(t, string(actions), `remove label "pullpal" from #3`)
+		require.Contains(t, string(actions), `add labels ["pullpal:done"] to #3`)
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		var out bytes.Buffer
+		gc := newTestGithubClient(t, mux)
+		gc.SetDryRun(NewDryRun("", &out))
+
+		require.NoError(t, gc.CommentOnIssue(3, "hello"))
+		require.NoError(t, gc.AddReactionToIssue(3, "eyes"))
+		require.Equal(t, "[dry run] owner/repo: issue-3-comment-1.md\nhello\n\n[dry run] owner/repo: react to #3 with \"eyes\"\n", out.String())
+	})
+}
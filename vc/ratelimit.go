@@ -0,0 +1,216 @@
+package vc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mobyvb/pull-pal/internal/timeutil"
+
+	"go.uber.org/zap"
+)
+
+// Quota is the Github API rate limit quota, as of the most recent response.
+type Quota struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimitTransport is an http.RoundTripper that tracks the Github API quota and retries failed requests.
+// A single RateLimitTransport should be shared between all clients that use the same token, since they share a quota.
+//   - If the primary rate limit is exhausted, requests wait until the quota resets.
+//   - If a secondary rate limit is hit, requests wait for the duration given in the Retry-After header.
+//   - Idempotent requests that fail with a network error or a 5xx response are retried with jittered exponential backoff.
+type RateLimitTransport struct {
+	log  *zap.Logger
+	base http.RoundTripper
+
+	// MaxRetries is the maximum number of times a request is retried.
+	MaxRetries int
+	// BaseDelay is the initial delay for exponential backoff.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between any two attempts, including waiting for rate limits to reset.
+	MaxDelay time.Duration
+
+	// sleep is overridden in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	quota Quota
+}
+
+// NewRateLimitTransport creates a RateLimitTransport which sends requests via base (http.DefaultTransport if nil).
+func NewRateLimitTransport(log *zap.Logger, base http.RoundTripper) *RateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RateLimitTransport{
+		log:        log,
+		base:       base,
+		MaxRetries: 5,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Hour,
+		sleep:      timeutil.SleepContext,
+	}
+}
+
+// Quota returns the most recently reported Github API quota.
+func (t *RateLimitTransport) Quota() Quota {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.quota
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		err := t.waitForQuota(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("cannot retry request with a body that cannot be replayed")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			if attempt >= t.MaxRetries || !isIdempotent(req.Method) {
+				return nil, err
+			}
+			delay := t.backoff(attempt)
+			t.log.Warn("github request failed, retrying", zap.String("method", req.Method), zap.String("url", req.URL.Path), zap.Duration("delay", delay), zap.Error(err))
+			if err := t.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		t.updateQuota(resp)
+
+		delay, retry := t.retryDelay(req, resp, attempt)
+		if !retry || attempt >= t.MaxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		t.log.Warn("github request unsuccessful, retrying", zap.String("method", req.Method), zap.String("url", req.URL.Path), zap.Int("status", resp.StatusCode), zap.Duration("delay", delay))
+		if delay > 0 {
+			if err := t.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryDelay determines whether a response should be retried, and how long to wait before doing so.
+func (t *RateLimitTransport) retryDelay(req *http.Request, resp *http.Response, attempt int) (time.Duration, bool) {
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+		// secondary rate limit
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			seconds, err := strconv.Atoi(retryAfter)
+			if err == nil {
+				return t.capDelay(time.Duration(seconds) * time.Second), true
+			}
+		}
+		// primary rate limit - the request will wait for the quota to reset before being sent again
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if resp.StatusCode >= 500 && isIdempotent(req.Method) {
+		return t.backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+// waitForQuota blocks until the primary rate limit resets, if it has been exhausted.
+func (t *RateLimitTransport) waitForQuota(ctx context.Context) error {
+	quota := t.Quota()
+	if quota.Limit == 0 || quota.Remaining > 0 {
+		return nil
+	}
+	wait := time.Until(quota.Reset)
+	if wait <= 0 {
+		return nil
+	}
+	wait = t.capDelay(wait + time.Second)
+
+	t.log.Warn("github rate limit exhausted, waiting for reset", zap.Time("reset", quota.Reset), zap.Duration("wait", wait))
+	err := t.sleep(ctx, wait)
+	if err != nil {
+		return err
+	}
+
+	// assume that the quota has been reset, even if the wait was capped - the next response will correct it
+	t.mu.Lock()
+	t.quota.Remaining = t.quota.Limit
+	t.mu.Unlock()
+
+	return nil
+}
+
+func (t *RateLimitTransport) updateQuota(resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.quota = Quota{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+	t.mu.Unlock()
+}
+
+// backoff returns a random delay between 0 and BaseDelay*2^attempt, capped at MaxDelay.
+func (t *RateLimitTransport) backoff(attempt int) time.Duration {
+	max := t.capDelay(t.BaseDelay << uint(attempt))
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func (t *RateLimitTransport) capDelay(d time.Duration) time.Duration {
+	if d > t.MaxDelay || d < 0 {
+		return t.MaxDelay
+	}
+	return d
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
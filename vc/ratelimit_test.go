@@ -0,0 +1,93 @@
+package vc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	var testCases = []struct {
+		testcase string
+		method   string
+		// responses are the status codes returned by the server, in order
+		responses []int
+		header    http.Header
+		status    int
+		attempts  int
+		slept     int
+	}{
+		{"success", http.MethodGet, []int{200}, nil, 200, 1, 0},
+		{"retry server errors", http.MethodGet, []int{502, 503, 200}, nil, 200, 3, 2},
+		{"do not retry non-idempotent requests", http.MethodPost, []int{502, 200}, nil, 502, 1, 0},
+		{"give up after max retries", http.MethodGet, []int{500, 500, 500, 500}, nil, 500, 3, 2},
+		{"do not retry client errors", http.MethodGet, []int{404, 200}, nil, 404, 1, 0},
+		{"secondary rate limit", http.MethodPost, []int{403, 201}, http.Header{"Retry-After": []string{"30"}}, 201, 2, 1},
+		{"primary rate limit", http.MethodGet, []int{403, 200}, http.Header{
+			"X-Ratelimit-Limit":     []string{"5000"},
+			"X-Ratelimit-Remaining": []string{"0"},
+			"X-Ratelimit-Reset":     []string{strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)},
+		}, 200, 2, 1},
+	}
+	for _, tt := range testCases {
+		t.Log("testing case:", tt.testcase)
+
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tt.responses[attempts]
+			attempts++
+			if status != 200 && status != 201 {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+			}
+			w.WriteHeader(status)
+		}))
+
+		slept := 0
+		transport := NewRateLimitTransport(zap.NewNop(), nil)
+		transport.MaxRetries = 2
+		transport.sleep = func(ctx context.Context, d time.Duration) error {
+			slept++
+			return nil
+		}
+
+		req, err := http.NewRequest(tt.method, server.URL, strings.NewReader("body"))
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		server.Close()
+
+		require.Equal(t, tt.status, resp.StatusCode)
+		require.Equal(t, tt.attempts, attempts)
+		require.Equal(t, tt.slept, slept)
+	}
+}
+
+func TestRateLimitTransportQuota(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "1234")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}))
+	defer server.Close()
+
+	transport := NewRateLimitTransport(zap.NewNop(), nil)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	quota := transport.Quota()
+	require.Equal(t, 5000, quota.Limit)
+	require.Equal(t, 1234, quota.Remaining)
+	require.True(t, reset.Equal(quota.Reset))
+}
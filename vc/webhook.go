@@ -0,0 +1,127 @@
+package vc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// WebhookSignatureHeader is the header Github uses to pass the HMAC-SHA256 hexdigest of a webhook payload.
+	WebhookSignatureHeader = "X-Hub-Signature-256"
+	// WebhookEventHeader is the header Github uses to pass the type of a webhook event.
+	WebhookEventHeader = "X-GitHub-Event"
+)
+
+// WebhookEventType is the type of a webhook event, as provided in the WebhookEventHeader.
+type WebhookEventType string
+
+const (
+	WebhookIssues                   WebhookEventType = "issues"
+	WebhookIssueComment             WebhookEventType = "issue_comment"
+	WebhookPullRequestReviewComment WebhookEventType = "pull_request_review_comment"
+	WebhookPullRequestReview        WebhookEventType = "pull_request_review"
+)
+
+// ErrInvalidSignature is returned when a webhook payload does not match its signature.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// WebhookEvent contains the information from a webhook payload that is needed to schedule work.
+type WebhookEvent struct {
+	Type   WebhookEventType
+	Action string
+	// Repo is the full name of the repository the event occurred in (e.g. "owner/name").
+	Repo   string
+	Sender string
+	// IssueNumber is the issue or pull request number the event refers to.
+	IssueNumber int
+	// CommentID is the ID of the comment the event refers to, if any.
+	CommentID int64
+	// ReviewID is the ID of the pull request review the event refers to, or the review comment was submitted in, if any.
+	ReviewID int64
+}
+
+// Relevant returns true if the event could result in new work for Pull Pal.
+func (e WebhookEvent) Relevant() bool {
+	switch e.Type {
+	case WebhookIssues:
+		return e.Action == "opened" || e.Action == "edited" || e.Action == "labeled" || e.Action == "reopened"
+	case WebhookIssueComment, WebhookPullRequestReviewComment:
+		return e.Action == "created"
+	case WebhookPullRequestReview:
+		return e.Action == "submitted"
+	}
+	return false
+}
+
+// ValidateWebhookSignature checks that the signature provided by Github (e.g. "sha256=abc123") matches the payload.
+// Payloads are never valid for an empty secret.
+func ValidateWebhookSignature(payload []byte, signature string, secret []byte) error {
+	if len(secret) == 0 || !strings.HasPrefix(signature, "sha256=") {
+		return ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// ParseWebhookEvent parses a webhook payload of the provided type.
+// Unsupported event types are returned with only the Type field populated.
+func ParseWebhookEvent(eventType string, payload []byte) (WebhookEvent, error) {
+	event := WebhookEvent{
+		Type: WebhookEventType(eventType),
+	}
+
+	switch event.Type {
+	case WebhookIssues, WebhookIssueComment, WebhookPullRequestReviewComment, WebhookPullRequestReview:
+	default:
+		return event, nil
+	}
+
+	parsed, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return event, err
+	}
+
+	switch e := parsed.(type) {
+	case *github.IssuesEvent:
+		event.Action = e.GetAction()
+		event.Repo = e.GetRepo().GetFullName()
+		event.Sender = e.GetSender().GetLogin()
+		event.IssueNumber = e.GetIssue().GetNumber()
+	case *github.IssueCommentEvent:
+		event.Action = e.GetAction()
+		event.Repo = e.GetRepo().GetFullName()
+		event.Sender = e.GetSender().GetLogin()
+		event.IssueNumber = e.GetIssue().GetNumber()
+		event.CommentID = e.GetComment().GetID()
+	case *github.PullRequestReviewCommentEvent:
+		event.Action = e.GetAction()
+		event.Repo = e.GetRepo().GetFullName()
+		event.Sender = e.GetSender().GetLogin()
+		event.IssueNumber = e.GetPullRequest().GetNumber()
+		event.CommentID = e.GetComment().GetID()
+		event.ReviewID = e.GetComment().GetPullRequestReviewID()
+	case *github.PullRequestReviewEvent:
+		event.Action = e.GetAction()
+		event.Repo = e.GetRepo().GetFullName()
+		event.Sender = e.GetSender().GetLogin()
+		event.IssueNumber = e.GetPullRequest().GetNumber()
+		event.ReviewID = e.GetReview().GetID()
+	}
+
+	return event, nil
+}